@@ -24,6 +24,9 @@ var (
 	exporterNamespace = kingpin.Flag("namespace", "prefix of built-in metrics, (pg|pgbouncer) by default").Short('n').Default("").Envar("PG_EXPORTER_NAMESPACE").String()
 	failFast          = kingpin.Flag("fail-fast", "fail fast instead of waiting during start-up").Short('f').Envar("PG_EXPORTER_FAIL_FAST").Default("false").Bool()
 	connectTimeout    = kingpin.Flag("connect-timeout", "connect timeout in ms, 100 by default").Short('T').Envar("PG_EXPORTER_CONNECT_TIMEOUT").Default("100").Int()
+	planCheck         = kingpin.Flag("plan-check", "validate queries with EXPLAIN during planning, discard queries the role could not run").Default("false").Envar("PG_EXPORTER_PLAN_CHECK").Bool()
+	cacheDir          = kingpin.Flag("cache.dir", "persist results of long ttl queries in this dir, and restore them after restart if still valid").Default("").Envar("PG_EXPORTER_CACHE_DIR").String()
+	precheckSettings  = kingpin.Flag("precheck-settings", "pg_settings gathered during precheck for planning: comma separated list of setting name").Default("").Envar("PG_EXPORTER_PRECHECK_SETTINGS").String()
 
 	// prometheus http
 	metricPath = kingpin.Flag("web.telemetry-path", "URL path under which to expose metrics.").Short('P').Default("/metrics").Envar("PG_EXPORTER_TELEMETRY_PATH").String()
@@ -31,6 +34,7 @@ var (
 	// action
 	dryRun      = kingpin.Flag("dry-run", "dry run and print raw configs").Default("false").Short('D').Bool()
 	explainOnly = kingpin.Flag("explain", "explain server planned queries").Default("false").Short('E').Bool()
+	simulate    = kingpin.Flag("simulate", "explain planning against synthetic server facts without connecting, e.g. version=160004,recovery=true,extensions=pg_stat_statements,tags=foo (repeatable)").Strings()
 
 	// logger setting
 	logLevel  = kingpin.Flag("log.level", "log level: debug|info|warn|error").Default("info").String()
@@ -23,6 +23,7 @@ type predicateCacheEntry struct {
 }
 
 type histogramMetricDescriptors struct {
+	native *prometheus.Desc // only for native histogram, others are nil
 	bucket *prometheus.Desc
 	count  *prometheus.Desc
 	sum    *prometheus.Desc
@@ -37,6 +38,19 @@ type histogramAccumulator struct {
 	counts []uint64 // non-cumulative counts; last element is the +Inf interval
 	count  uint64
 	sum    float64
+
+	// native histogram buckets, only for native columns
+	positive map[int]int64
+	negative map[int]int64
+	zero     uint64
+
+	// pre-aggregated buckets, only for HISTOGRAM_BUCKET columns
+	cumulative []uint64 // cumulative count of each configured bucket
+	seen       []bool   // whether the configured bucket is returned by SQL
+	inf        uint64   // cumulative count of +Inf bucket
+	hasInf     bool
+	hasSum     bool
+	hasCount   bool
 }
 
 // Collector holds runtime information of a Query running on a Server
@@ -46,13 +60,26 @@ type Collector struct {
 	Server *Server // It's a query, but holds a server
 
 	// runtime information
-	lock          sync.RWMutex                // scrape lock
-	result        []prometheus.Metric         // cached metrics
-	descriptors   map[string]*prometheus.Desc // scalar column name to descriptor, built on init
-	histogramDesc map[string]histogramMetricDescriptors
-	cacheHit      bool   // indicate last scrape was served from cache or real execution
-	predicateSkip string // if nonempty, predicate query caused skip of this scrape
-	err           error
+	lock            sync.RWMutex                // scrape lock
+	result          []prometheus.Metric         // cached metrics
+	descriptors     map[string]*prometheus.Desc // scalar column name to descriptor, built on init
+	histogramDesc   map[string]histogramMetricDescriptors
+	infoDesc        *prometheus.Desc        // info metric descriptor, only for info query
+	relabelDescs    *relabelDescs           // descriptors recorded for exporter-wide metric relabel rules
+	cacheHit        bool                    // indicate last scrape was served from cache or real execution
+	predicateSkip   string                  // if nonempty, predicate query caused skip of this scrape
+	unknownValues   int                     // values outside STATESET states or column mapping in last execution
+	droppedSeries   int                     // series dropped by max_series in last execution
+	deltas          map[string]*deltaSample // previous values of delta/rate series, by column and label tuple
+	topNKept        map[string]bool         // keys of rows kept by top_n in last execution
+	boundSQL        *boundSQL               // SQL with named parameters, compiled on init
+	boundForeach    *boundSQL               // foreach driver SQL with named parameters
+	boundPredicates []*boundSQL             // predicate SQL with named parameters, by index
+	jitter          time.Duration           // random delay of TTL expiry and schedule, drawn on init
+	plannedAt       time.Time               // creation time, cron schedules fire after it before first execution
+	key             string                  // identity of query definition and server, see persistKey
+	persistedAt     time.Time               // execution time of the result last written to --cache.dir
+	err             error
 
 	// predicate cache. Entry i caches PredicateQueries[i] if it has a positive TTL.
 	predicateCache []predicateCacheEntry
@@ -74,7 +101,16 @@ func NewCollector(q *Query, s *Server) *Collector {
 	if len(q.PredicateQueries) > 0 {
 		instance.predicateCache = make([]predicateCacheEntry, len(q.PredicateQueries))
 	}
+	instance.jitter = q.drawJitter()
+	instance.plannedAt = time.Now()
+	instance.boundSQL = compileNamedParams(q.SQL)
+	instance.boundForeach = compileNamedParams(q.Foreach)
+	for _, pq := range q.PredicateQueries {
+		instance.boundPredicates = append(instance.boundPredicates, compileNamedParams(pq.SQL))
+	}
 	instance.makeDescMap()
+	instance.key = instance.persistKey()
+	instance.restoreResult()
 	return instance
 }
 
@@ -88,19 +124,26 @@ func (q *Collector) Describe(ch chan<- *prometheus.Desc) {
 // Collect implement prometheus.Collector
 func (q *Collector) Collect(ch chan<- prometheus.Metric) {
 	q.lock.Lock()
-	defer q.lock.Unlock()
+	persist := func() {}
 	q.scrapeBegin = time.Now()
-	if q.cacheExpired() || q.Server.DisableCache {
+	if q.refreshDue() {
 		q.execute()
 		q.cacheHit = false
 		q.scrapeDone = time.Now()
 		q.scrapeDuration = q.scrapeDone.Sub(q.scrapeBegin)
-		q.lastScrape = q.Server.scrapeBegin
+		if q.err == nil || q.schedule == nil { // a failed scheduled execution is retried at next scrape
+			q.lastScrape = q.Server.scrapeBegin
+		}
+		if q.err == nil && q.predicateSkip == "" {
+			persist = q.persistResult()
+		}
 	} else { // serve from cache
 		q.cacheHit = true
 		q.scrapeDone = time.Now()
 	}
 	q.sendMetrics(ch) // a failed real execution intentionally leaves an empty result
+	q.lock.Unlock()
+	persist() // file I/O does not block scrapes of this collector
 }
 
 // ResultSize report last scraped metric count
@@ -159,7 +202,11 @@ func (q *Collector) executePredicateQueries(ctx context.Context) bool {
 
 		// Execute the predicate query.
 		logDebugf("%s executing predicate query", msgPrefix)
-		rows, err := q.Server.QueryContext(ctx, predicateQuery.SQL)
+		predicateSQL, args, err := q.bind(q.boundPredicates[i], 0)
+		var rows *sql.Rows
+		if err == nil {
+			rows, err = q.Server.QueryContext(ctx, predicateSQL, args...)
+		}
 		if err != nil {
 			// If a predicate query fails that's treated as a skip, and the err
 			// flag is set so Fatal will be respected if set.
@@ -242,6 +289,8 @@ func (q *Collector) execute() {
 	q.result = nil
 	q.err = nil
 	q.predicateSkip = ""
+	q.unknownValues = 0
+	q.droppedSeries = 0
 	var rows *sql.Rows
 	var err error
 
@@ -261,8 +310,27 @@ func (q *Collector) execute() {
 		return
 	}
 
-	// main query execution
-	rows, err = q.Server.QueryContext(ctx, q.SQL)
+	// main query execution, once per driver row with foreach
+	var driver *foreachRows
+	var params []interface{}
+	if q.Foreach != "" {
+		if driver, err = q.executeForeach(ctx); err == nil {
+			if len(driver.rows) == 0 { // nothing to iterate over, publish an empty result
+				q.result, q.deltas, q.topNKept = pending, nil, nil
+				logDebugf("query [%s] foreach driver returned no rows", q.Name)
+				return
+			}
+			params = driver.rows[0]
+		}
+	}
+	var mainSQL string
+	var named []interface{}
+	if err == nil {
+		mainSQL, named, err = q.bind(q.boundSQL, len(params))
+	}
+	if err == nil {
+		rows, err = q.Server.QueryContext(ctx, mainSQL, append(params, named...)...)
+	}
 
 	// error handling: if query failed because of timeout or error, record and return
 	if err != nil {
@@ -288,14 +356,29 @@ func (q *Collector) execute() {
 	for i, n := range columnNames {
 		columnIndexes[n] = i
 	}
+	converters, err := q.valueConverters(rows, columnNames)
+	if err != nil {
+		q.err = fmt.Errorf("query [%s] fail retriving column types: %w", q.Name, err)
+		return
+	}
 	nColumn := len(columnNames)
-	colData := make([]interface{}, nColumn)
+	if driver != nil { // driver values follow main result columns
+		for _, name := range driver.columns {
+			if _, found := columnIndexes[name]; found {
+				q.err = fmt.Errorf("query [%s] foreach column %s.%s conflicts with result column", q.Name, q.Name, name)
+				return
+			}
+			columnIndexes[name] = len(columnNames)
+			columnNames = append(columnNames, name)
+		}
+	}
+	colData := make([]interface{}, len(columnNames))
 	colArgs := make([]interface{}, nColumn)
-	for i := range colData {
+	for i := range colArgs {
 		colArgs[i] = &colData[i]
 	}
-	if len(columnNames) != len(q.Columns) { // warn if column count not match
-		logWarnf("query [%s] column count not match, result %d ≠ config %d", q.Name, len(columnNames), len(q.Columns))
+	if len(columnNames) != q.ResultColumnCount() { // warn if column count not match
+		logWarnf("query [%s] column count not match, result %d ≠ config %d", q.Name, len(columnNames), q.ResultColumnCount())
 	}
 	// A missing label invalidates the identity of every resulting series, so fail
 	// this collector instead of substituting an empty label value.
@@ -306,38 +389,202 @@ func (q *Collector) execute() {
 		}
 	}
 	// A missing scalar column preserves the historic warn-and-skip behavior. A
-	// missing histogram column is an error because silently emitting no groups is
+	// missing histogram or summary column is an error because silently emitting no groups is
 	// indistinguishable from a valid empty population.
 	for _, metricName := range q.MetricNames {
 		column := q.Columns[metricName]
-		if column != nil && column.IsHistogram() {
+		if column != nil && (column.IsHistogram() || column.IsSummary()) {
+			kind := "histogram"
+			if column.IsSummary() {
+				kind = "summary"
+			}
 			if _, found := columnIndexes[metricName]; !found {
-				q.err = fmt.Errorf("query [%s] missing histogram column %s.%s in result", q.Name, q.Name, metricName)
+				q.err = fmt.Errorf("query [%s] missing %s column %s.%s in result", q.Name, kind, q.Name, metricName)
 				return
 			}
+			for _, source := range column.sourceColumns() {
+				if _, found := columnIndexes[source]; !found {
+					q.err = fmt.Errorf("query [%s] missing %s source column %s.%s in result", q.Name, kind, q.Name, source)
+					return
+				}
+			}
 		}
 	}
 
+	missingOperands := q.missingExprOperands(columnIndexes)
+
 	histograms := make(map[string]map[string]*histogramAccumulator)
+	summaries := make(map[string]map[string]*summaryAccumulator)
 
-	// scan loop: for each row, extract labels from all label columns, then generate a new metric for each metric column
-	for rows.Next() {
-		err = rows.Scan(colArgs...)
-		if err != nil {
-			q.err = fmt.Errorf("fail scanning rows: %w", err)
+	// sample time of a row is its TIMESTAMP column value, or the execution
+	// time when keep_timestamp is set, so cached results keep that timestamp
+	var executionTime time.Time
+	if q.KeepTimestamp {
+		executionTime = q.scrapeBegin
+	}
+	timestampIndex, hasTimestamp := columnIndexes[q.TimestampName]
+	var pivotDescs map[string]*prometheus.Desc
+	if q.Pivot != nil {
+		pivotDescs = make(map[string]*prometheus.Desc)
+		defer func() { // pivot descriptors live for one execution only
+			for _, desc := range pivotDescs {
+				q.relabelDescs.forget(desc)
+			}
+		}()
+		if _, found := columnIndexes[q.Pivot.Name]; !found {
+			q.err = fmt.Errorf("query [%s] missing pivot name column %s.%s in result", q.Name, q.Name, q.Pivot.Name)
 			return
 		}
+	}
+	if q.TimestampName != "" && !hasTimestamp {
+		logWarnf("missing timestamp column %s.%s in result", q.Name, q.TimestampName)
+	}
+	// relabeled label tuples may collide, and max_series admits the label
+	// tuples that sort first, so result order does not decide which are kept
+	var merger seriesMerger
+	if q.HasRelabel() || (q.TopN != nil && q.TopN.Others) {
+		merger = make(seriesMerger)
+	}
+	var limiter *seriesLimiter
+	if q.MaxSeries > 0 {
+		limiter = newSeriesLimiter(q.Query)
+	}
+	// previous values are replaced only by a complete execution
+	var deltas map[string]*deltaSample
+	if q.HasDelta() {
+		deltas = make(map[string]*deltaSample)
+	}
 
-		// get labels, sequence matters, empty string for null labels
+	// lookup values of LABEL columns, by column name
+	var lookups map[string]map[string]string
+	if q.HasLookup() {
+		lookups = make(map[string]map[string]string)
+		for _, labelName := range q.LabelNames {
+			if ref := q.Columns[labelName].Lookup; ref != "" {
+				lookups[labelName] = q.Server.lookupValues(ref)
+			}
+		}
+	}
+
+	// top_n reads the whole result before replaying kept rows in result order
+	scan := q.rowScanner(rows, colArgs, colData, converters, columnNames)
+	if driver != nil {
+		scanner := &foreachScanner{q: q, ctx: ctx, driver: driver, rows: rows, nColumn: nColumn,
+			sql: mainSQL, named: named, colArgs: colArgs, colData: colData, converters: converters, columns: columnNames}
+		defer scanner.close()
+		scan = scanner.scan
+	}
+	var ranked *rankedRows
+	if q.TopN != nil {
+		if ranked, err = q.rankRows(scan, colData, columnIndexes); err != nil {
+			q.err = err
+			return
+		}
+		scan = func() (bool, error) { return ranked.scan(q, colData, columnIndexes), nil }
+	}
+
+	// get labels, sequence matters, empty string for null labels
+	rowLabels := func() []string {
 		labels := make([]string, len(q.LabelNames))
 		for i, labelName := range q.LabelNames {
 			labels[i] = castString(colData[columnIndexes[labelName]])
+			if values, found := lookups[labelName]; found {
+				if value, found := values[labels[i]]; found {
+					labels[i] = value
+				}
+			}
+			if labelColumn := q.Columns[labelName]; labelColumn.HasRelabel() {
+				labels[i] = labelColumn.relabel(labels[i])
+			}
+		}
+		return labels
+	}
+	// series key of a row under max_series, rows of filtered pivot names yield nothing and cost nothing
+	seriesKey := func(labels []string) (string, bool) {
+		key := encodeLabelTuple(labels)
+		if q.Pivot != nil {
+			name := colData[columnIndexes[q.Pivot.Name]]
+			if name == nil || !q.Pivot.Allowed(castString(name)) {
+				return "", false
+			}
+			key += encodeLabelTuple([]string{castString(name)})
+		}
+		return key, true
+	}
+	if limiter != nil {
+		if scan, err = limiter.buffer(scan, colData, func() (string, bool) { return seriesKey(rowLabels()) }); err != nil {
+			q.err = err
+			return
+		}
+	}
+
+	// scan loop: for each row, extract labels from all label columns, then generate a new metric for each metric column
+	for {
+		more, err := scan()
+		if err != nil {
+			q.err = err
+			return
+		}
+		if !more {
+			break
+		}
+
+		labels := rowLabels()
+		if limiter != nil {
+			key, counted := seriesKey(labels)
+			if !counted {
+				continue
+			}
+			admitted, dropped := limiter.admit(key)
+			q.droppedSeries += dropped
+			if !admitted {
+				continue
+			}
+		}
+		sampleTime := executionTime
+		if hasTimestamp {
+			if ts := castTimestamp(colData[timestampIndex], q.Columns[q.TimestampName]); !ts.IsZero() {
+				sampleTime = ts
+			}
+		}
+		rowBegin := len(pending)
+		var deltaKey string
+		if deltas != nil {
+			deltaKey = q.rowKey(colData, columnIndexes)
+		}
+
+		// info query emits a constant 1 for each row
+		if q.infoDesc != nil && (merger == nil || merger.first(q.infoDesc, labels)) {
+			metric, metricErr := q.newConstMetric(q.infoDesc, prometheus.GaugeValue, 1, labels...)
+			if metricErr != nil {
+				q.err = fmt.Errorf("query [%s] failed building metric %s: %w", q.Name, q.InfoName(), metricErr)
+				return
+			}
+			if metric != nil {
+				pending = append(pending, metric)
+			}
 		}
 
 		// get metrics, warn if column not exist
 		for _, metricName := range q.MetricNames {
-			if dataIndex, found := columnIndexes[metricName]; found { // the metric column is found in result
+			if dataIndex, found := columnIndexes[metricName]; found || q.Columns[metricName].IsComputed() { // the metric column is found in result or computed
 				column := q.Columns[metricName]
+				if column.IsSummary() {
+					accumulator := summaryGroup(summaries, metricName, column, labels)
+					if summaryErr := q.observeSummary(accumulator, colData, columnIndexes, dataIndex); summaryErr != nil {
+						q.err = fmt.Errorf("query [%s] summary column %s.%s: %w", q.Name, q.Name, metricName, summaryErr)
+						return
+					}
+					continue
+				}
+				if column.IsHistogramBucket() {
+					accumulator := histogramGroup(histograms, metricName, column, labels)
+					if bucketErr := q.observeHistogramBucket(accumulator, colData, columnIndexes, dataIndex); bucketErr != nil {
+						q.err = fmt.Errorf("query [%s] histogram bucket column %s.%s: %w", q.Name, q.Name, metricName, bucketErr)
+						return
+					}
+					continue
+				}
 				if column.IsHistogram() {
 					value, present, castErr := castHistogramFloat64(colData[dataIndex], column)
 					if castErr != nil {
@@ -348,23 +595,12 @@ func (q *Collector) execute() {
 						continue
 					}
 
-					groups := histograms[metricName]
-					if groups == nil {
-						groups = make(map[string]*histogramAccumulator)
-						histograms[metricName] = groups
-					}
-					labelKey := encodeLabelTuple(labels)
-					accumulator := groups[labelKey]
-					if accumulator == nil {
-						accumulator = &histogramAccumulator{
-							column: column,
-							labels: append([]string(nil), labels...),
-							counts: make([]uint64, len(column.Bucket)+1),
-						}
-						groups[labelKey] = accumulator
-					}
+					accumulator := histogramGroup(histograms, metricName, column, labels)
 					bucketIndex := sort.SearchFloat64s(column.Bucket, value)
 					accumulator.counts[bucketIndex]++
+					if column.Native {
+						accumulator.observeNative(value)
+					}
 					accumulator.count++
 					accumulator.sum += value
 					if math.IsNaN(accumulator.sum) || math.IsInf(accumulator.sum, 0) {
@@ -373,12 +609,81 @@ func (q *Collector) execute() {
 					}
 					continue
 				}
+				if column.IsStateSet() {
+					if merger != nil && !merger.first(q.descriptors[metricName], labels) {
+						continue
+					}
+					metrics, metricErr := q.stateSetMetrics(metricName, column, colData[dataIndex], labels)
+					if metricErr != nil {
+						q.err = fmt.Errorf("query [%s] failed building stateset %s.%s: %w", q.Name, q.Name, metricName, metricErr)
+						return
+					}
+					pending = append(pending, metrics...)
+					continue
+				}
 
+				var value float64
+				if column.IsComputed() {
+					if missingOperands[metricName] {
+						continue
+					}
+					value = q.evalExpr(column, colData, columnIndexes)
+				} else if len(column.Mapping) > 0 {
+					mapped, known := column.mapValue(colData[dataIndex])
+					switch {
+					case known:
+						value = mapped
+					case colData[dataIndex] == nil && column.hasDefault:
+						value = column.defaultValue
+					default:
+						if colData[dataIndex] != nil {
+							q.unknownValues++
+						}
+						value = math.NaN()
+					}
+				} else {
+					value = castFloat64(colData[dataIndex], column)
+				}
+				if column.DeltaMode() != "" {
+					var reset string
+					if resetIndex, found := columnIndexes[column.ResetColumn]; found {
+						reset = castString(colData[resetIndex])
+					}
+					at := sampleTime
+					if at.IsZero() {
+						at = q.scrapeBegin
+					}
+					delta, ok := q.observeDelta(deltas, column, metricName+deltaKey, value, reset, at)
+					if !ok {
+						continue
+					}
+					value = delta
+				}
+				desc := q.descriptors[metricName] // always find desc & column via name
+				if q.Pivot != nil {
+					if desc = q.pivotDesc(pivotDescs, colData, columnIndexes); desc == nil {
+						continue
+					}
+				}
+				desc, seriesLabels := q.relabelSeries(desc, valueTypeKind(column.PrometheusValueType()), labels)
+				if desc == nil {
+					continue
+				}
+				if merger != nil {
+					merged, mergeErr := merger.merge(pending, desc, column.PrometheusValueType(), value, seriesLabels, len(pending), sampleTime)
+					if mergeErr != nil {
+						q.err = fmt.Errorf("query [%s] failed merging metric %s.%s: %w", q.Name, q.Name, metricName, mergeErr)
+						return
+					}
+					if merged {
+						continue
+					}
+				}
 				metric, metricErr := prometheus.NewConstMetric(
-					q.descriptors[metricName], // always find desc & column via name
+					desc,
 					column.PrometheusValueType(),
-					castFloat64(colData[dataIndex], column),
-					labels...,
+					value,
+					seriesLabels...,
 				)
 				if metricErr != nil {
 					q.err = fmt.Errorf("query [%s] failed building metric %s.%s: %w", q.Name, q.Name, metricName, metricErr)
@@ -389,12 +694,14 @@ func (q *Collector) execute() {
 				logWarnf("missing metric column %s.%s in result", q.Name, metricName)
 			}
 		}
+		stampMetrics(pending[rowBegin:], sampleTime)
 	}
 	if err = rows.Err(); err != nil {
 		q.err = fmt.Errorf("query [%s] failed while iterating rows: %w", q.Name, err)
 		return
 	}
 
+	aggregateBegin := len(pending)
 	// Emit histogram groups in config metric order and collision-safe label-key
 	// order. Each group is bucket(s), +Inf, count, then sum.
 	for _, metricName := range q.MetricNames {
@@ -419,20 +726,93 @@ func (q *Collector) execute() {
 		}
 	}
 
+	// Emit summary groups in config metric order and label-key order
+	for _, metricName := range q.MetricNames {
+		column := q.Columns[metricName]
+		if column == nil || !column.IsSummary() {
+			continue
+		}
+		groups := summaries[metricName]
+		groupKeys := make([]string, 0, len(groups))
+		for groupKey := range groups {
+			groupKeys = append(groupKeys, groupKey)
+		}
+		sort.Strings(groupKeys)
+		for _, groupKey := range groupKeys {
+			metric, metricErr := q.summaryMetric(metricName, groups[groupKey])
+			if metricErr != nil {
+				q.err = fmt.Errorf("query [%s] failed building summary %s.%s: %w", q.Name, q.Name, metricName, metricErr)
+				return
+			}
+			if metric != nil {
+				pending = append(pending, metric)
+			}
+		}
+	}
+
+	stampMetrics(pending[aggregateBegin:], executionTime)
+
 	q.result = pending
+	q.deltas = deltas
+	if ranked != nil {
+		q.topNKept = ranked.kept
+	}
 	q.err = nil
 	logDebugf("query [%s] executing complete in %v, metrics count: %d",
 		q.Name, time.Since(q.scrapeBegin), len(q.result))
 }
 
+// stampMetrics sets explicit timestamp of metrics in place, zero time is a no-op
+func stampMetrics(metrics []prometheus.Metric, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	for i, metric := range metrics {
+		metrics[i] = prometheus.NewMetricWithTimestamp(t, metric)
+	}
+}
+
+// histogramGroup returns the accumulator of a histogram column and label tuple, creating it on demand
+func histogramGroup(histograms map[string]map[string]*histogramAccumulator, metricName string, column *Column, labels []string) *histogramAccumulator {
+	groups := histograms[metricName]
+	if groups == nil {
+		groups = make(map[string]*histogramAccumulator)
+		histograms[metricName] = groups
+	}
+	labelKey := encodeLabelTuple(labels)
+	accumulator := groups[labelKey]
+	if accumulator == nil {
+		accumulator = &histogramAccumulator{
+			column: column,
+			labels: append([]string(nil), labels...),
+			counts: make([]uint64, len(column.Bucket)+1),
+		}
+		groups[labelKey] = accumulator
+	}
+	return accumulator
+}
+
 // histogramMetrics materializes a snapshot histogram as ordinary Gauge series.
 // It deliberately does not use prometheus.Histogram: SQL refreshes are
 // independent snapshots whose bucket/count/sum values may decrease.
+// A native column is the opt-in exception and emits one native histogram.
 func (q *Collector) histogramMetrics(metricName string, accumulator *histogramAccumulator) ([]prometheus.Metric, error) {
 	descriptors, found := q.histogramDesc[metricName]
 	if !found {
 		return nil, fmt.Errorf("missing descriptors")
 	}
+	if accumulator.column.IsHistogramBucket() {
+		if err := accumulator.resolveBuckets(); err != nil {
+			return nil, err
+		}
+	}
+	if descriptors.native != nil {
+		metric, err := q.nativeHistogramMetric(descriptors.native, accumulator)
+		if err != nil || metric == nil {
+			return nil, err
+		}
+		return []prometheus.Metric{metric}, nil
+	}
 
 	result := make([]prometheus.Metric, 0, len(accumulator.column.Bucket)+3)
 	bucketLabels := make([]string, len(accumulator.labels)+1)
@@ -441,39 +821,86 @@ func (q *Collector) histogramMetrics(metricName string, accumulator *histogramAc
 	for i, upperBound := range accumulator.column.Bucket {
 		cumulative += accumulator.counts[i]
 		bucketLabels[len(bucketLabels)-1] = strconv.FormatFloat(upperBound, 'g', -1, 64)
-		metric, err := prometheus.NewConstMetric(descriptors.bucket, prometheus.GaugeValue, float64(cumulative), bucketLabels...)
+		metric, err := q.newConstMetric(descriptors.bucket, prometheus.GaugeValue, float64(cumulative), bucketLabels...)
 		if err != nil {
 			return nil, err
 		}
-		result = append(result, metric)
+		if metric != nil {
+			result = append(result, metric)
+		}
 	}
 
 	cumulative += accumulator.counts[len(accumulator.column.Bucket)]
 	bucketLabels[len(bucketLabels)-1] = "+Inf"
-	metric, err := prometheus.NewConstMetric(descriptors.bucket, prometheus.GaugeValue, float64(cumulative), bucketLabels...)
-	if err != nil {
-		return nil, err
+	for _, series := range []struct {
+		desc   *prometheus.Desc
+		value  float64
+		labels []string
+	}{
+		{descriptors.bucket, float64(cumulative), bucketLabels},
+		{descriptors.count, float64(accumulator.count), accumulator.labels},
+		{descriptors.sum, accumulator.sum, accumulator.labels},
+	} {
+		metric, err := q.newConstMetric(series.desc, prometheus.GaugeValue, series.value, series.labels...)
+		if err != nil {
+			return nil, err
+		}
+		if metric != nil {
+			result = append(result, metric)
+		}
 	}
-	result = append(result, metric)
+	return result, nil
+}
 
-	metric, err = prometheus.NewConstMetric(descriptors.count, prometheus.GaugeValue, float64(accumulator.count), accumulator.labels...)
-	if err != nil {
-		return nil, err
+// stateSetMetrics one-hot encodes a STATESET value: one series per configured
+// state, 1 for the current state and 0 for others. A NULL value is absent, and
+// a value outside configured states yields all zeros and is counted as unknown.
+func (q *Collector) stateSetMetrics(metricName string, column *Column, raw interface{}, labels []string) ([]prometheus.Metric, error) {
+	if raw == nil {
+		return nil, nil
 	}
-	result = append(result, metric)
-
-	metric, err = prometheus.NewConstMetric(descriptors.sum, prometheus.GaugeValue, accumulator.sum, accumulator.labels...)
-	if err != nil {
-		return nil, err
+	current := castString(raw)
+	known := false
+	result := make([]prometheus.Metric, 0, len(column.States))
+	stateLabels := make([]string, len(labels)+1)
+	copy(stateLabels, labels)
+	for _, state := range column.States {
+		value := 0.0
+		if state == current {
+			value, known = 1, true
+		}
+		stateLabels[len(labels)] = state
+		metric, err := q.newConstMetric(q.descriptors[metricName], prometheus.GaugeValue, value, stateLabels...)
+		if err != nil {
+			return nil, err
+		}
+		if metric != nil {
+			result = append(result, metric)
+		}
+	}
+	if !known {
+		q.unknownValues++
+		logDebugf("query [%s] stateset %s got unknown state %q", q.Name, metricName, current)
 	}
-	result = append(result, metric)
 	return result, nil
 }
 
+// DroppedSeries reports how many series in last execution are dropped by max_series
+func (q *Collector) DroppedSeries() int {
+	return q.droppedSeries
+}
+
+// UnknownValues reports how many values in last execution are outside STATESET states or column mapping
+func (q *Collector) UnknownValues() int {
+	return q.unknownValues
+}
+
 /* ================ Collector Auxiliary ================ */
 
 // makeDescMap will generate descriptor map from Query
 func (q *Collector) makeDescMap() {
+	q.relabelDescs = newRelabelDescs()
+	constLabels := q.constLabels()
 	descriptors := make(map[string]*prometheus.Desc)
 	histogramDescriptors := make(map[string]histogramMetricDescriptors)
 
@@ -495,27 +922,59 @@ func (q *Collector) makeDescMap() {
 		if metricColumn.Rename != "" {
 			prometheusName = fmt.Sprintf("%s_%s", q.Name, metricColumn.Rename)
 		}
+		if metricColumn.IsNativeHistogram() {
+			histogramDescriptors[metricName] = histogramMetricDescriptors{
+				native: q.relabelDescs.newDesc(prometheusName, metricColumn.Desc, labelNames, constLabels),
+			}
+			continue
+		}
 		if metricColumn.IsHistogram() {
 			bucketLabelNames := append(append([]string(nil), labelNames...), "le")
 			histogramDescriptors[metricName] = histogramMetricDescriptors{
-				bucket: prometheus.NewDesc(
-					prometheusName+"_bucket", histogramHelp(metricColumn.Desc, "bucket"), bucketLabelNames, q.Server.labels,
+				bucket: q.relabelDescs.newDesc(
+					prometheusName+"_bucket", histogramHelp(metricColumn.Desc, "bucket"), bucketLabelNames, constLabels,
 				),
-				count: prometheus.NewDesc(
-					prometheusName+"_count", histogramHelp(metricColumn.Desc, "count"), labelNames, q.Server.labels,
+				count: q.relabelDescs.newDesc(
+					prometheusName+"_count", histogramHelp(metricColumn.Desc, "count"), labelNames, constLabels,
 				),
-				sum: prometheus.NewDesc(
-					prometheusName+"_sum", histogramHelp(metricColumn.Desc, "sum"), labelNames, q.Server.labels,
+				sum: q.relabelDescs.newDesc(
+					prometheusName+"_sum", histogramHelp(metricColumn.Desc, "sum"), labelNames, constLabels,
 				),
 			}
 			continue
 		}
-		descriptors[metricColumn.Name] = prometheus.NewDesc(
-			prometheusName, metricColumn.Desc, labelNames, q.Server.labels,
+		if metricColumn.IsStateSet() {
+			stateLabelNames := append(append([]string(nil), labelNames...), metricColumn.StateLabel())
+			descriptors[metricColumn.Name] = q.relabelDescs.newDesc(
+				prometheusName, metricColumn.Desc, stateLabelNames, constLabels,
+			)
+			continue
+		}
+		descriptors[metricColumn.Name] = q.relabelDescs.newDesc(
+			prometheusName, metricColumn.Desc, labelNames, constLabels,
 		)
 	}
 	q.descriptors = descriptors
 	q.histogramDesc = histogramDescriptors
+	q.infoDesc = nil
+	if q.Info {
+		q.infoDesc = q.relabelDescs.newDesc(q.InfoName(), q.infoColumn().Desc, labelNames, constLabels)
+	}
+}
+
+// constLabels returns constant labels of the server merged with static labels of the query
+func (q *Collector) constLabels() prometheus.Labels {
+	if len(q.Labels) == 0 {
+		return q.Server.labels
+	}
+	labels := make(prometheus.Labels, len(q.Server.labels)+len(q.Labels))
+	for k, v := range q.Server.labels {
+		labels[k] = v
+	}
+	for k, v := range q.Labels {
+		labels[k] = v
+	}
+	return labels
 }
 
 func (q *Collector) sendDescriptors(ch chan<- *prometheus.Desc) {
@@ -523,10 +982,17 @@ func (q *Collector) sendDescriptors(ch chan<- *prometheus.Desc) {
 		ch <- desc
 	}
 	for _, descriptors := range q.histogramDesc {
+		if descriptors.native != nil {
+			ch <- descriptors.native
+			continue
+		}
 		ch <- descriptors.bucket
 		ch <- descriptors.count
 		ch <- descriptors.sum
 	}
+	if q.infoDesc != nil {
+		ch <- q.infoDesc
+	}
 }
 
 func histogramHelp(description, component string) string {
@@ -547,11 +1013,11 @@ func histogramHelp(description, component string) string {
 // cacheExpired report whether this instance needs actual execution
 // Note you have to use Server.scrapeBegin as "now", and set that timestamp as
 func (q *Collector) cacheExpired() bool {
-	return q.Server.scrapeBegin.Sub(q.lastScrape) > time.Duration(q.TTL*float64(time.Second))
+	return q.Server.scrapeBegin.Sub(q.lastScrape) > time.Duration(q.TTL*float64(time.Second))+q.jitter
 }
 
 func (q *Collector) cacheTTL() float64 {
-	return q.TTL - q.Server.scrapeBegin.Sub(q.lastScrape).Seconds()
+	return q.TTL + q.jitter.Seconds() - q.Server.scrapeBegin.Sub(q.lastScrape).Seconds()
 }
 
 // sendMetrics will send cached result to ch
@@ -2,6 +2,7 @@ package exporter
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -16,32 +17,67 @@ const (
 	COUNTER   = "COUNTER"   // Use this column as a counter
 	GAUGE     = "GAUGE"     // Use this column as a gauge
 	HISTOGRAM = "HISTOGRAM" // Use this column as a snapshot histogram observation
+	STATESET  = "STATESET"  // Use this text column as a one-hot encoded state set
+	INFO      = "INFO"      // Generated by query level `info: true`, not a valid column usage
+	TIMESTAMP = "TIMESTAMP" // Use this column as sample timestamp of other metrics in the same row
+
+	HISTOGRAM_BUCKET = "HISTOGRAM_BUCKET" // Use this column as cumulative count of a pre-aggregated histogram bucket
+	SUMMARY          = "SUMMARY"          // Use this column as summary observation, or count of SQL computed quantiles
 )
 
 // ColumnUsage determine how to use query result column
 var ColumnUsage = map[string]bool{
 	DISCARD:   false,
 	LABEL:     false,
+	TIMESTAMP: false,
 	COUNTER:   true,
 	GAUGE:     true,
 	HISTOGRAM: true,
+	STATESET:  true,
+
+	HISTOGRAM_BUCKET: true,
+	SUMMARY:          true,
 }
 
 // Column holds the metadata of query result
 type Column struct {
-	Name    string    `yaml:"name"`
-	Usage   string    `yaml:"usage,omitempty"`   // column usage
-	Rename  string    `yaml:"rename,omitempty"`  // rename column
-	Bucket  []float64 `yaml:"bucket,omitempty"`  // histogram bucket
-	Scale   string    `yaml:"scale,omitempty"`   // scale factor
-	Default string    `yaml:"default,omitempty"` // default value
-	Desc    string    `yaml:"description,omitempty"`
+	Name            string             `yaml:"name"`
+	Usage           string             `yaml:"usage,omitempty"`            // column usage
+	Rename          string             `yaml:"rename,omitempty"`           // rename column
+	Bucket          []float64          `yaml:"bucket,omitempty"`           // histogram bucket
+	Native          bool               `yaml:"native,omitempty"`           // build native (exponential) histogram
+	Schema          string             `yaml:"schema,omitempty"`           // native histogram schema, -4 ~ 8
+	Le              string             `yaml:"le,omitempty"`               // HISTOGRAM_BUCKET upper bound column, `le` by default
+	Sum             string             `yaml:"sum,omitempty"`              // HISTOGRAM_BUCKET or SUMMARY observation sum column
+	Count           string             `yaml:"count,omitempty"`            // HISTOGRAM_BUCKET observation count column, optional
+	Quantiles       []float64          `yaml:"quantiles,omitempty"`        // SUMMARY quantiles
+	QuantileColumns []string           `yaml:"quantile_columns,omitempty"` // SUMMARY columns holding SQL computed quantiles
+	States          []string           `yaml:"states,omitempty"`           // stateset states
+	Mapping         map[string]float64 `yaml:"mapping,omitempty"`          // text value to number mapping of GAUGE/COUNTER
+	Regex           string             `yaml:"regex,omitempty"`            // LABEL value regexp to be replaced
+	Replacement     string             `yaml:"replacement,omitempty"`      // LABEL regex replacement, $1 style expansion
+	Lower           bool               `yaml:"lower,omitempty"`            // lowercase LABEL value
+	Truncate        int                `yaml:"truncate,omitempty"`         // truncate LABEL value to N characters
+	Values          []string           `yaml:"values,omitempty"`           // LABEL value allowlist, others become `other`
+	Lookup          string             `yaml:"lookup,omitempty"`           // replace LABEL value with value of this lookup
+	Expr            string             `yaml:"expr,omitempty"`             // compute GAUGE/COUNTER value from other columns of the row
+	Delta           bool               `yaml:"delta,omitempty"`            // emit COUNTER increment since previous execution
+	Rate            bool               `yaml:"rate,omitempty"`             // emit COUNTER per-second rate since previous execution
+	ResetColumn     string             `yaml:"reset_column,omitempty"`     // column whose change marks a counter reset, e.g. stats_reset
+	Scale           string             `yaml:"scale,omitempty"`            // scale factor
+	Default         string             `yaml:"default,omitempty"`          // default value
+	Desc            string             `yaml:"description,omitempty"`
 
 	// Parsed numeric options (filled during config parsing).
 	scaleFactor  float64
 	hasScale     bool
 	defaultValue float64
 	hasDefault   bool
+	schema       int32
+	regex        *regexp.Regexp  // compiled LABEL regex
+	valueSet     map[string]bool // LABEL value allowlist
+	expr         exprNode        // parsed computed column expression
+	exprOperands []string        // operand column names of expr
 }
 
 func (c *Column) parseNumbers() error {
@@ -61,22 +97,107 @@ func (c *Column) parseNumbers() error {
 		c.defaultValue = f
 		c.hasDefault = true
 	}
+	c.schema = defaultNativeHistogramSchema
+	if c.Schema != "" {
+		n, err := strconv.ParseInt(c.Schema, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid schema %q: %w", c.Schema, err)
+		}
+		c.schema = int32(n)
+	}
 	return nil
 }
 
-// IsHistogram reports whether this column is a HISTOGRAM value column.
-// Usage is normalized to uppercase during config parsing, so exact
-// comparison is the single predicate every path must share.
+// IsHistogram reports whether this column is a HISTOGRAM or HISTOGRAM_BUCKET
+// value column. Usage is normalized to uppercase during config parsing, so
+// exact comparison is the single predicate every path must share.
 func (c *Column) IsHistogram() bool {
-	return c.Usage == HISTOGRAM
+	return c.Usage == HISTOGRAM || c.Usage == HISTOGRAM_BUCKET
+}
+
+// IsHistogramBucket reports whether this column holds pre-aggregated cumulative bucket counts.
+func (c *Column) IsHistogramBucket() bool {
+	return c.Usage == HISTOGRAM_BUCKET
+}
+
+// IsSummary reports whether this column is a SUMMARY value column.
+func (c *Column) IsSummary() bool {
+	return c.Usage == SUMMARY
+}
+
+// sourceColumns returns the names of columns read by a pre-aggregated column
+func (c *Column) sourceColumns() []string {
+	switch {
+	case c.IsHistogramBucket():
+		sources := []string{c.LeColumn(), c.Sum}
+		if c.Count != "" {
+			sources = append(sources, c.Count)
+		}
+		return sources
+	case c.IsSummary() && c.Sum != "":
+		return append([]string{c.Sum}, c.QuantileColumns...)
+	}
+	return nil
+}
+
+// LeColumn returns the column name that holds bucket upper bound of a HISTOGRAM_BUCKET column
+func (c *Column) LeColumn() string {
+	if c.Le != "" {
+		return c.Le
+	}
+	return "le"
+}
+
+// IsNativeHistogram reports whether this HISTOGRAM column builds a native histogram.
+func (c *Column) IsNativeHistogram() bool {
+	return c.Usage == HISTOGRAM && c.Native
+}
+
+// NativeSchema returns the parsed native histogram schema
+func (c *Column) NativeSchema() int32 {
+	return c.schema
+}
+
+// IsStateSet reports whether this column is a STATESET value column.
+func (c *Column) IsStateSet() bool {
+	return c.Usage == STATESET
+}
+
+// StateLabel returns the label name that holds state of a STATESET column,
+// which is the metric name suffix, following OpenMetrics stateset convention
+func (c *Column) StateLabel() string {
+	if c.Rename != "" {
+		return c.Rename
+	}
+	return c.Name
+}
+
+// mapValue maps a text value with column mapping, found is false for unknown values
+func (c *Column) mapValue(t interface{}) (value float64, found bool) {
+	var key string
+	switch v := t.(type) {
+	case string:
+		key = v
+	case []byte:
+		key = string(v)
+	case nil:
+		return 0, false
+	default:
+		key = castString(v)
+	}
+	value, found = c.Mapping[key]
+	return value, found
 }
 
 // PrometheusValueType returns column's corresponding prometheus value type
 func (c *Column) PrometheusValueType() prometheus.ValueType {
 	switch strings.ToUpper(c.Usage) {
-	case GAUGE:
+	case GAUGE, STATESET:
 		return prometheus.GaugeValue
 	case COUNTER:
+		if c.Delta || c.Rate { // increments and rates go up and down
+			return prometheus.GaugeValue
+		}
 		return prometheus.CounterValue
 	default:
 		// it's user's responsibility to make sure this is a value column
@@ -35,9 +35,23 @@ func GetConfig() (res string) {
 // ParseConfig turn config content into Query struct
 func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 	queries = make(map[string]*Query)
-	if err = yaml.Unmarshal(content, &queries); err != nil {
+	var branches map[string]yaml.Node
+	if err = yaml.Unmarshal(content, &branches); err != nil {
 		return nil, fmt.Errorf("malformed config: %w", err)
 	}
+	for branch, node := range branches {
+		if branch == relabelConfigKey || branch == lookupConfigKey { // reserved, see ParseRelabel and ParseLookups
+			if hasMappingKey(&node, "query") || hasMappingKey(&node, "query_file") {
+				return nil, fmt.Errorf("query %q uses a reserved config key, rename the query branch", branch)
+			}
+			continue
+		}
+		var query *Query
+		if err = node.Decode(&query); err != nil {
+			return nil, fmt.Errorf("malformed config: %w", err)
+		}
+		queries[branch] = query
+	}
 
 	// parse additional fields
 	for branch, query := range queries {
@@ -48,14 +62,42 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 		if query.Name == "" {
 			query.Name = branch
 		}
-		if strings.TrimSpace(query.SQL) == "" {
+		if query.SQLFile != "" {
+			if strings.TrimSpace(query.SQL) != "" {
+				return nil, fmt.Errorf("query %q defines both query and query_file", branch)
+			}
+		} else if strings.TrimSpace(query.SQL) == "" {
 			return nil, fmt.Errorf("query %q has empty SQL", branch)
 		}
+		if query.Foreach != "" && strings.TrimSpace(query.Foreach) == "" {
+			return nil, fmt.Errorf("query %q has empty foreach driver SQL", branch)
+		}
 		if query.TTL < 0 {
 			return nil, fmt.Errorf("query %q has negative ttl: %v", branch, query.TTL)
 		}
+		if query.Jitter < 0 {
+			return nil, fmt.Errorf("query %q has negative jitter: %v", branch, query.Jitter)
+		}
+		if query.Schedule != "" {
+			if query.schedule, err = parseSchedule(query.Schedule); err != nil {
+				return nil, fmt.Errorf("query %q schedule: %w", branch, err)
+			}
+		}
+		for _, tag := range query.Tags {
+			if _, err := parseTag(tag); err != nil {
+				return nil, fmt.Errorf("query %q tag %q: %w", branch, tag, err)
+			}
+		}
+		// templates in query files are parsed once the files are resolved
+		if err := query.validateTemplates(); err != nil {
+			return nil, fmt.Errorf("query %q %w", branch, err)
+		}
 		for i, pq := range query.PredicateQueries {
-			if strings.TrimSpace(pq.SQL) == "" {
+			if pq.SQLFile != "" {
+				if strings.TrimSpace(pq.SQL) != "" {
+					return nil, fmt.Errorf("query %q defines both predicate_query and predicate_query_file at index %d", branch, i)
+				}
+			} else if strings.TrimSpace(pq.SQL) == "" {
 				return nil, fmt.Errorf("query %q has empty predicate_query at index %d", branch, i)
 			}
 			if pq.TTL < 0 {
@@ -68,6 +110,7 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 		// parse query column info
 		columns := make(map[string]*Column, len(query.Metrics))
 		var allColumns, labelColumns, metricColumns []string
+		var timestampColumn string
 		for _, colMap := range query.Metrics {
 			if len(colMap) == 0 {
 				return nil, fmt.Errorf("query %q has an empty metrics entry", branch)
@@ -93,14 +136,29 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 				if err := column.parseNumbers(); err != nil {
 					return nil, fmt.Errorf("query %q column %q: %w", branch, colName, err)
 				}
+				if err := validateRelabel(column); err != nil {
+					return nil, fmt.Errorf("query %q column %q: %w", branch, colName, err)
+				}
+				if err := validateLookup(column); err != nil {
+					return nil, fmt.Errorf("query %q column %q: %w", branch, colName, err)
+				}
 				switch column.Usage {
 				case LABEL:
 					labelColumns = append(labelColumns, column.Name)
-				case GAUGE, COUNTER, HISTOGRAM:
-					if column.IsHistogram() {
-						if err := validateHistogramBuckets(column.Bucket); err != nil {
-							return nil, fmt.Errorf("query %q column %q: %w", branch, colName, err)
-						}
+				case TIMESTAMP:
+					if timestampColumn != "" {
+						return nil, fmt.Errorf("query %q has more than one TIMESTAMP column: %q and %q", branch, timestampColumn, column.Name)
+					}
+					timestampColumn = column.Name
+				case GAUGE, COUNTER, HISTOGRAM, HISTOGRAM_BUCKET, SUMMARY, STATESET:
+					if err := validateHistogram(column); err != nil {
+						return nil, fmt.Errorf("query %q column %q: %w", branch, colName, err)
+					}
+					if err := validateSummary(column); err != nil {
+						return nil, fmt.Errorf("query %q column %q: %w", branch, colName, err)
+					}
+					if err := validateStateSet(column); err != nil {
+						return nil, fmt.Errorf("query %q column %q: %w", branch, colName, err)
 					}
 					metricColumns = append(metricColumns, column.Name)
 				}
@@ -111,11 +169,43 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 				columns[column.Name] = column
 			}
 		}
-		if len(metricColumns) == 0 {
-			return nil, fmt.Errorf("query %q defines no GAUGE/COUNTER/HISTOGRAM columns", branch)
+		if len(metricColumns) == 0 && !query.Info {
+			return nil, fmt.Errorf("query %q defines no GAUGE/COUNTER/HISTOGRAM/STATESET columns", branch)
+		}
+		if query.Info && len(labelColumns) == 0 {
+			return nil, fmt.Errorf("query %q is an info query but defines no LABEL columns", branch)
 		}
 		query.Columns, query.ColumnNames, query.LabelNames, query.MetricNames = columns, allColumns, labelColumns, metricColumns
-		hasHistogram := query.HasHistogram()
+		query.TimestampName = timestampColumn
+		if query.MaxSeries < 0 {
+			return nil, fmt.Errorf("query %q max_series must be non-negative, got %d", branch, query.MaxSeries)
+		}
+		if query.Pivot != nil {
+			if err := validatePivot(query); err != nil {
+				return nil, fmt.Errorf("query %q: %w", branch, err)
+			}
+		}
+		// histogram and summary aggregate many rows, so they have no single row timestamp
+		if timestampColumn != "" && (query.HasHistogram() || query.HasSummary()) {
+			return nil, fmt.Errorf("query %q TIMESTAMP column %q cannot be used with HISTOGRAM or SUMMARY columns", branch, timestampColumn)
+		}
+		for _, column := range columns {
+			if err := validateSourceColumns(column, columns); err != nil {
+				return nil, fmt.Errorf("query %q column %q: %w", branch, column.Name, err)
+			}
+			if err := validateExpr(column, columns); err != nil {
+				return nil, fmt.Errorf("query %q column %q: %w", branch, column.Name, err)
+			}
+			if err := validateDelta(column, columns); err != nil {
+				return nil, fmt.Errorf("query %q column %q: %w", branch, column.Name, err)
+			}
+		}
+		if query.TopN != nil {
+			if err := validateTopN(query); err != nil {
+				return nil, fmt.Errorf("query %q: %w", branch, err)
+			}
+		}
+		hasHistogram, hasSummary := query.HasHistogram(), query.HasSummary()
 
 		// Validate prometheus label names and metric names. This prevents panics at scrape time.
 		seenLabels := make(map[string]bool, len(query.LabelNames))
@@ -134,12 +224,32 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 			if hasHistogram && lbl == "le" {
 				return nil, fmt.Errorf("query %q label %q conflicts with generated Histogram bucket label %q", branch, lbl, "le")
 			}
+			if hasSummary && lbl == "quantile" {
+				return nil, fmt.Errorf("query %q label %q conflicts with generated Summary quantile label %q", branch, lbl, "quantile")
+			}
 			if seenLabels[lbl] {
 				return nil, fmt.Errorf("query %q has duplicate label name %q", branch, lbl)
 			}
 			seenLabels[lbl] = true
 		}
 
+		// Static labels share the label namespace with label columns and generated labels
+		for _, lbl := range sortedKeys(query.Labels) {
+			if err := validatePromLabelName(lbl); err != nil {
+				return nil, fmt.Errorf("query %q static label %q: %w", branch, lbl, err)
+			}
+			if hasHistogram && lbl == "le" {
+				return nil, fmt.Errorf("query %q static label %q conflicts with generated Histogram bucket label %q", branch, lbl, "le")
+			}
+			if hasSummary && lbl == "quantile" {
+				return nil, fmt.Errorf("query %q static label %q conflicts with generated Summary quantile label %q", branch, lbl, "quantile")
+			}
+			if seenLabels[lbl] {
+				return nil, fmt.Errorf("query %q static label %q conflicts with label column %q", branch, lbl, lbl)
+			}
+			seenLabels[lbl] = true
+		}
+
 		// Reserve every logical base name and every emitted family name. Reserving
 		// Histogram bases as well keeps post-rename names unambiguous even though
 		// version 1 emits only the derived _bucket, _count, and _sum families.
@@ -158,6 +268,16 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 				return nil, fmt.Errorf("query %q metric %q: %w", branch, metricName, err)
 			}
 
+			if c.IsStateSet() {
+				stateLabel := c.StateLabel()
+				if err := validatePromLabelName(stateLabel); err != nil {
+					return nil, fmt.Errorf("query %q stateset %q state label: %w", branch, metricName, err)
+				}
+				if seenLabels[stateLabel] {
+					return nil, fmt.Errorf("query %q stateset %q state label %q conflicts with label %q", branch, metricName, stateLabel, stateLabel)
+				}
+			}
+
 			familyNames := []string{metricName}
 			if c.IsHistogram() {
 				familyNames = append(familyNames,
@@ -166,6 +286,12 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 					metricName+"_sum",
 				)
 			}
+			if c.IsSummary() {
+				familyNames = append(familyNames,
+					metricName+"_count",
+					metricName+"_sum",
+				)
+			}
 			for _, familyName := range familyNames {
 				if err := validatePromMetricName(familyName); err != nil {
 					return nil, fmt.Errorf("query %q metric %q derived family %q: %w", branch, metricName, familyName, err)
@@ -176,6 +302,15 @@ func ParseConfig(content []byte) (queries map[string]*Query, err error) {
 				seenMetrics[familyName] = metricName
 			}
 		}
+		if query.Info {
+			infoName := query.InfoName()
+			if err := validatePromMetricName(infoName); err != nil {
+				return nil, fmt.Errorf("query %q info metric %q: %w", branch, infoName, err)
+			}
+			if previous, exists := seenMetrics[infoName]; exists {
+				return nil, fmt.Errorf("query %q info metric %q conflicts with metric %q", branch, infoName, previous)
+			}
+		}
 	}
 	return
 }
@@ -198,6 +333,140 @@ func validateHistogramBuckets(buckets []float64) error {
 	return nil
 }
 
+// validateHistogram validates bucket and native options of a HISTOGRAM
+// column. A native histogram derives buckets from its exponential schema,
+// so configured buckets are optional and only used for classic exposition.
+func validateHistogram(column *Column) error {
+	if column.Usage != HISTOGRAM && (column.Native || column.Schema != "") {
+		return fmt.Errorf("native and schema are only supported by HISTOGRAM, got %s", column.Usage)
+	}
+	if !column.IsHistogram() {
+		return nil
+	}
+	if column.Schema != "" && !column.Native {
+		return fmt.Errorf("HISTOGRAM schema requires native: true")
+	}
+	if column.Native {
+		if column.schema < nativeHistogramSchemaMin || column.schema > nativeHistogramSchemaMax {
+			return fmt.Errorf("HISTOGRAM schema must be between %d and %d, got %d", nativeHistogramSchemaMin, nativeHistogramSchemaMax, column.schema)
+		}
+		if len(column.Bucket) == 0 {
+			return nil
+		}
+	}
+	return validateHistogramBuckets(column.Bucket)
+}
+
+// validateSummary validates quantiles of a SUMMARY column, which are
+// rejected on other usages.
+func validateSummary(column *Column) error {
+	if !column.IsSummary() {
+		if len(column.Quantiles) > 0 {
+			return fmt.Errorf("quantiles is only supported by SUMMARY, got %s", column.Usage)
+		}
+		return nil
+	}
+	if len(column.Quantiles) == 0 {
+		return fmt.Errorf("SUMMARY requires at least one quantile")
+	}
+	for i, quantile := range column.Quantiles {
+		if math.IsNaN(quantile) || quantile < 0 || quantile > 1 {
+			return fmt.Errorf("SUMMARY quantile[%d] must be between 0 and 1, got %v", i, quantile)
+		}
+		if i > 0 && quantile <= column.Quantiles[i-1] {
+			return fmt.Errorf("SUMMARY quantiles must be strictly increasing: quantile[%d]=%v follows %v", i, quantile, column.Quantiles[i-1])
+		}
+	}
+	if len(column.Bucket) > 0 {
+		return fmt.Errorf("SUMMARY does not support bucket")
+	}
+	return nil
+}
+
+// validateSourceColumns validates columns referenced by a HISTOGRAM_BUCKET
+// column (le, sum, count) or a pre-aggregated SUMMARY column (sum and
+// quantile_columns). They must be distinct DISCARD columns of the same query,
+// so they are read from result rows but never emitted on their own.
+func validateSourceColumns(column *Column, columns map[string]*Column) error {
+	switch {
+	case column.IsHistogramBucket():
+		if column.Sum == "" {
+			return fmt.Errorf("HISTOGRAM_BUCKET requires a sum column")
+		}
+		if len(column.QuantileColumns) > 0 {
+			return fmt.Errorf("quantile_columns is only supported by SUMMARY")
+		}
+	case column.IsSummary():
+		if column.Le != "" || column.Count != "" {
+			return fmt.Errorf("le and count are only supported by HISTOGRAM_BUCKET, SUMMARY column itself is the count")
+		}
+		if len(column.QuantileColumns) > 0 && column.Sum == "" {
+			return fmt.Errorf("SUMMARY with quantile_columns requires a sum column")
+		}
+		if column.Sum != "" && len(column.QuantileColumns) != len(column.Quantiles) {
+			return fmt.Errorf("SUMMARY has %d quantiles but %d quantile_columns", len(column.Quantiles), len(column.QuantileColumns))
+		}
+	default:
+		if column.Le != "" || column.Sum != "" || column.Count != "" || len(column.QuantileColumns) > 0 {
+			return fmt.Errorf("le, sum, count and quantile_columns are not supported by %s", column.Usage)
+		}
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range column.sourceColumns() {
+		source := columns[name]
+		if source == nil {
+			return fmt.Errorf("%s source column %q is not defined", column.Usage, name)
+		}
+		if source.Usage != DISCARD {
+			return fmt.Errorf("%s source column %q must be DISCARD, got %s", column.Usage, name, source.Usage)
+		}
+		if seen[name] {
+			return fmt.Errorf("%s source column %q is referenced more than once", column.Usage, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// validateStateSet validates states of a STATESET column and mapping of a
+// GAUGE/COUNTER column. Both options are rejected on other usages.
+func validateStateSet(column *Column) error {
+	if column.IsStateSet() {
+		if len(column.States) == 0 {
+			return fmt.Errorf("STATESET requires at least one state")
+		}
+		seen := make(map[string]bool, len(column.States))
+		for i, state := range column.States {
+			if state == "" {
+				return fmt.Errorf("STATESET state[%d] is empty", i)
+			}
+			if seen[state] {
+				return fmt.Errorf("STATESET has duplicate state %q", state)
+			}
+			seen[state] = true
+		}
+		if len(column.Mapping) > 0 || len(column.Bucket) > 0 {
+			return fmt.Errorf("STATESET does not support mapping or bucket")
+		}
+		return nil
+	}
+	if len(column.States) > 0 {
+		return fmt.Errorf("states is only supported by STATESET, got %s", column.Usage)
+	}
+	if len(column.Mapping) > 0 {
+		if column.Usage != GAUGE && column.Usage != COUNTER {
+			return fmt.Errorf("mapping is only supported by GAUGE and COUNTER, got %s", column.Usage)
+		}
+		for key, value := range column.Mapping {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				return fmt.Errorf("mapping value of %q must be finite, got %v", key, value)
+			}
+		}
+	}
+	return nil
+}
+
 func FinalizeQueries(queries map[string]*Query, source string) error {
 	for branch, q := range queries {
 		if q == nil {
@@ -216,6 +485,58 @@ func FinalizeQueries(queries map[string]*Query, source string) error {
 	return nil
 }
 
+// resolveQueryFiles loads query_file and predicate_query_file contents into
+// SQL. Relative paths are resolved against baseDir, which is the directory of
+// the YAML file that references them. The resolved location is recorded in
+// Path so that explain output tells where the SQL actually came from.
+func resolveQueryFiles(queries map[string]*Query, baseDir string) error {
+	for branch, q := range queries {
+		if q == nil {
+			continue
+		}
+		if q.SQLFile != "" {
+			sqlPath, content, err := readQueryFile(baseDir, q.SQLFile)
+			if err != nil {
+				return fmt.Errorf("query %q query_file: %w", branch, err)
+			}
+			q.SQL = content
+			q.Path = fmt.Sprintf("%s (query_file: %s)", q.Path, sqlPath)
+		}
+		for i := range q.PredicateQueries {
+			pq := &q.PredicateQueries[i]
+			if pq.SQLFile == "" {
+				continue
+			}
+			sqlPath, content, err := readQueryFile(baseDir, pq.SQLFile)
+			if err != nil {
+				return fmt.Errorf("query %q predicate_queries[%d].predicate_query_file: %w", branch, i, err)
+			}
+			pq.SQL = content
+			pq.Path = sqlPath
+		}
+		if err := q.validateTemplates(); err != nil {
+			return fmt.Errorf("query %q %w", branch, err)
+		}
+	}
+	return nil
+}
+
+// readQueryFile reads a single external SQL file, rejecting empty content
+func readQueryFile(baseDir, name string) (sqlPath string, content string, err error) {
+	sqlPath = name
+	if !filepath.IsAbs(sqlPath) {
+		sqlPath = filepath.Join(baseDir, sqlPath)
+	}
+	buf, err := os.ReadFile(sqlPath)
+	if err != nil {
+		return sqlPath, "", fmt.Errorf("fail reading sql file %s: %w", sqlPath, err)
+	}
+	if strings.TrimSpace(string(buf)) == "" {
+		return sqlPath, "", fmt.Errorf("sql file %s is empty", sqlPath)
+	}
+	return sqlPath, string(buf), nil
+}
+
 // ParseQuery generate a single query from config string
 func ParseQuery(config string) (*Query, error) {
 	queries, err := ParseConfig([]byte(config))
@@ -231,82 +552,122 @@ func ParseQuery(config string) (*Query, error) {
 	if err := FinalizeQueries(queries, "<inline>"); err != nil {
 		return nil, err
 	}
+	if err := resolveQueryFiles(queries, "."); err != nil {
+		return nil, err
+	}
 	for _, q := range queries {
 		return q, nil // return the only query instance
 	}
 	return nil, fmt.Errorf("no query definition found")
 }
 
-// LoadConfig will read single conf file or read multiple conf file if a dir is given
-// conf file in a dir will be load in alphabetic order, query with same name will overwrite predecessor
-func LoadConfig(configPath string) (queries map[string]*Query, err error) {
+// hasMappingKey reports whether a yaml node is a mapping with given key
+func hasMappingKey(node *yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// walkConfigFiles calls load with path and content of each config file, which
+// is configPath itself, or each yaml file directly under configPath in
+// alphabetic order if a dir is given. Within a dir, a file failing to load is
+// skipped with a warning naming what is skipped, and the first such error is
+// returned as skipped. files is the number of yaml files in a dir, 0 for a
+// single config file, whose error is returned as err.
+func walkConfigFiles(configPath, what string, load func(confPath string, content []byte) error) (files int, skipped error, err error) {
 	stat, err := os.Stat(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("invalid config path: %s: %w", configPath, err)
+		return 0, nil, fmt.Errorf("invalid config path: %s: %w", configPath, err)
 	}
-	if stat.IsDir() { // iterate conf files (non-recursive) if a dir is given
-		files, err := os.ReadDir(configPath)
+	if !stat.IsDir() {
+		content, err := os.ReadFile(configPath)
 		if err != nil {
-			return nil, fmt.Errorf("fail reading config dir: %s: %w", configPath, err)
+			return 0, nil, fmt.Errorf("fail reading config file %s: %w", configPath, err)
 		}
-
-		logDebugf("load config from dir: %s", configPath)
-		confFiles := make([]string, 0)
-		for _, conf := range files {
-			if conf.IsDir() {
-				continue // skip subdirectories
-			}
-			if !(strings.HasSuffix(conf.Name(), ".yaml") || strings.HasSuffix(conf.Name(), ".yml")) {
-				continue // skip non-yaml files
+		return 0, nil, load(configPath, content)
+	}
+	entries, err := os.ReadDir(configPath)
+	if err != nil {
+		return 0, nil, fmt.Errorf("fail reading config dir: %s: %w", configPath, err)
+	}
+	for _, conf := range entries {
+		if conf.IsDir() {
+			continue // skip subdirectories
+		}
+		if !(strings.HasSuffix(conf.Name(), ".yaml") || strings.HasSuffix(conf.Name(), ".yml")) {
+			continue // skip non-yaml files
+		}
+		files++
+		confPath := filepath.Join(configPath, conf.Name())
+		content, err := os.ReadFile(confPath)
+		if err != nil {
+			err = fmt.Errorf("fail reading config file %s: %w", confPath, err)
+		} else {
+			err = load(confPath, content)
+		}
+		if err != nil {
+			logWarnf("skip %s of config %s due to error: %s", what, confPath, err.Error())
+			if skipped == nil {
+				skipped = err
 			}
-			confFiles = append(confFiles, filepath.Join(configPath, conf.Name()))
 		}
+	}
+	return files, skipped, nil
+}
 
-		// make global config map and assign priority according to config file alphabetic orders
-		// priority is an integer range from 1 to 999, where 1 - 99 is reserved for user
-		queries = make(map[string]*Query)
-		var queryCount, configCount int
-		var firstErr error
-		for _, confPath := range confFiles {
-			if singleQueries, err := LoadConfig(confPath); err != nil {
-				logWarnf("skip config %s due to error: %s", confPath, err.Error())
-				if firstErr == nil {
-					firstErr = err
-				}
-			} else {
-				configCount++
-				for name, query := range singleQueries {
-					queryCount++
-					if query.Priority == 0 { // set to config rank if not manually set
-						query.Priority = 100 + configCount
-					}
-					queries[name] = query // so the later one will overwrite former one
-				}
-			}
+// LoadConfig will read single conf file or read multiple conf file if a dir is given
+// conf file in a dir will be load in alphabetic order, query with same name will overwrite predecessor
+func LoadConfig(configPath string) (queries map[string]*Query, err error) {
+	queries = make(map[string]*Query)
+	var queryCount, configCount int
+	files, firstErr, err := walkConfigFiles(configPath, "queries", func(confPath string, content []byte) error {
+		fileQueries, err := parseConfigFile(confPath, content)
+		if err != nil {
+			return err
 		}
-		if len(confFiles) > 0 && len(queries) == 0 {
-			if firstErr != nil {
-				return nil, fmt.Errorf("no valid queries loaded from config dir %s (%d yaml files), first error: %w", configPath, len(confFiles), firstErr)
+		configCount++
+		for name, query := range fileQueries {
+			queryCount++
+			// priority is an integer range from 1 to 999, where 1 - 99 is reserved for user,
+			// queries in a dir get priority according to config file alphabetic orders
+			if confPath != configPath && query.Priority == 0 {
+				query.Priority = 100 + configCount
 			}
-			return nil, fmt.Errorf("no queries loaded from config dir %s (%d yaml files)", configPath, len(confFiles))
+			queries[name] = query // so the later one will overwrite former one
 		}
-		logDebugf("load %d of %d queries from %d config files", len(queries), queryCount, configCount)
-		return queries, nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	if files > 0 && len(queries) == 0 {
+		if firstErr != nil {
+			return nil, fmt.Errorf("no valid queries loaded from config dir %s (%d yaml files), first error: %w", configPath, files, firstErr)
+		}
+		return nil, fmt.Errorf("no queries loaded from config dir %s (%d yaml files)", configPath, files)
+	}
+	logDebugf("load %d of %d queries from %d config files in %s", len(queries), queryCount, configCount, configPath)
+	return queries, nil
+}
 
-	// single file case: recursive exit condition
-	content, err := os.ReadFile(configPath)
+// parseConfigFile parses queries of a single config file and resolves their sql files
+func parseConfigFile(confPath string, content []byte) (map[string]*Query, error) {
+	queries, err := ParseConfig(content)
 	if err != nil {
-		return nil, fmt.Errorf("fail reading config file %s: %w", configPath, err)
+		return nil, err
 	}
-	queries, err = ParseConfig(content)
-	if err != nil {
+	if err := FinalizeQueries(queries, filepath.Base(confPath)); err != nil {
 		return nil, err
 	}
-	if err := FinalizeQueries(queries, stat.Name()); err != nil {
+	// sql files are re-read on every load, so a reload picks up their changes too
+	if err := resolveQueryFiles(queries, filepath.Dir(confPath)); err != nil {
 		return nil, err
 	}
-	logDebugf("load %d queries from %s", len(queries), configPath)
 	return queries, nil
-
 }
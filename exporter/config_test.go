@@ -270,3 +270,100 @@ func TestGetConfigPrecedence(t *testing.T) {
 		t.Fatalf("GetConfig env fallback failed: got %s", got)
 	}
 }
+
+func TestLoadConfigQueryFileResolvedRelativeToConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sql"), 0o755); err != nil {
+		t.Fatalf("mkdir sql dir failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sql", "bloat.sql"), []byte("SELECT 1 AS metric"), 0o644); err != nil {
+		t.Fatalf("write sql file failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sql", "ready.sql"), []byte("SELECT true"), 0o644); err != nil {
+		t.Fatalf("write predicate sql file failed: %v", err)
+	}
+	cfg := `
+q_file:
+  query_file: sql/bloat.sql
+  predicate_queries:
+    - name: ready
+      predicate_query_file: sql/ready.sql
+  metrics:
+    - metric:
+        usage: gauge
+`
+	cfgPath := filepath.Join(dir, "0100-file.yml")
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0o644); err != nil {
+		t.Fatalf("write config failed: %v", err)
+	}
+
+	queries, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	q := queries["q_file"]
+	if q == nil {
+		t.Fatal("q_file not loaded")
+	}
+	if q.SQL != "SELECT 1 AS metric" {
+		t.Fatalf("SQL = %q, want content of query_file", q.SQL)
+	}
+	wantPath := filepath.Join(dir, "sql", "bloat.sql")
+	if !strings.Contains(q.Path, wantPath) || !strings.HasPrefix(q.Path, "0100-file.yml") {
+		t.Fatalf("Path = %q, want config name and resolved sql path %q", q.Path, wantPath)
+	}
+	if !strings.Contains(q.Explain(), wantPath) {
+		t.Fatalf("Explain does not show resolved sql path:\n%s", q.Explain())
+	}
+	if pq := q.PredicateQueries[0]; pq.SQL != "SELECT true" || pq.Path != filepath.Join(dir, "sql", "ready.sql") {
+		t.Fatalf("predicate query = %#v, want content and path of predicate_query_file", pq)
+	}
+
+	// sql files are part of the config set: changing one is picked up by the next load
+	if err := os.WriteFile(filepath.Join(dir, "sql", "bloat.sql"), []byte("SELECT 2 AS metric"), 0o644); err != nil {
+		t.Fatalf("rewrite sql file failed: %v", err)
+	}
+	if queries, err = LoadConfig(cfgPath); err != nil {
+		t.Fatalf("LoadConfig reload failed: %v", err)
+	}
+	if got := queries["q_file"].SQL; got != "SELECT 2 AS metric" {
+		t.Fatalf("reloaded SQL = %q, want updated file content", got)
+	}
+}
+
+func TestQueryFileErrors(t *testing.T) {
+	both := `
+q:
+  query: SELECT 1 AS metric
+  query_file: q.sql
+  metrics:
+    - metric:
+        usage: gauge
+`
+	if _, err := ParseConfig([]byte(both)); err == nil || !strings.Contains(err.Error(), "both query and query_file") {
+		t.Fatalf("ParseConfig error = %v, want query/query_file conflict", err)
+	}
+
+	dir := t.TempDir()
+	missing := `
+q:
+  query_file: missing.sql
+  metrics:
+    - metric:
+        usage: gauge
+`
+	cfgPath := filepath.Join(dir, "q.yml")
+	if err := os.WriteFile(cfgPath, []byte(missing), 0o644); err != nil {
+		t.Fatalf("write config failed: %v", err)
+	}
+	if _, err := LoadConfig(cfgPath); err == nil || !strings.Contains(err.Error(), "missing.sql") {
+		t.Fatalf("LoadConfig error = %v, want missing sql file error", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "missing.sql"), []byte("  \n"), 0o644); err != nil {
+		t.Fatalf("write empty sql file failed: %v", err)
+	}
+	if _, err := LoadConfig(cfgPath); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Fatalf("LoadConfig error = %v, want empty sql file error", err)
+	}
+}
@@ -0,0 +1,98 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/* ================ Delta & Rate ================ */
+
+// A COUNTER column with `delta: true` or `rate: true` emits the increment, or
+// the per-second rate, since the previous execution as a GAUGE, for dashboards
+// that cannot use PromQL rate(). The previous value is kept per label tuple,
+// so the first execution of a series emits nothing. A counter is considered
+// reset when its value decreases, or when the value of `reset_column` (e.g.
+// stats_reset) changes, and then the current value is the increment. Series
+// absent from a result, e.g. evicted pg_stat_statements entries, are forgotten.
+
+// deltaSample is the previous observation of a delta/rate series
+type deltaSample struct {
+	value float64
+	reset string // value of reset column, empty if none
+	at    time.Time
+}
+
+// validateDelta validates delta, rate and reset_column of a column
+func validateDelta(column *Column, columns map[string]*Column) error {
+	if !column.Delta && !column.Rate {
+		if column.ResetColumn != "" {
+			return fmt.Errorf("reset_column requires delta or rate")
+		}
+		return nil
+	}
+	if column.Usage != COUNTER {
+		return fmt.Errorf("delta and rate are only supported by COUNTER, got %s", column.Usage)
+	}
+	if column.Delta && column.Rate {
+		return fmt.Errorf("delta and rate are mutually exclusive")
+	}
+	if column.ResetColumn != "" {
+		reset := columns[column.ResetColumn]
+		if reset == nil {
+			return fmt.Errorf("reset_column %q is not defined", column.ResetColumn)
+		}
+		if reset == column || reset.IsComputed() {
+			return fmt.Errorf("reset_column %q must be another column read from result", column.ResetColumn)
+		}
+	}
+	return nil
+}
+
+// DeltaMode returns `delta` or `rate` if this column emits values since the
+// previous execution, empty otherwise
+func (c *Column) DeltaMode() string {
+	switch {
+	case c.Delta:
+		return "delta"
+	case c.Rate:
+		return "rate"
+	}
+	return ""
+}
+
+// HasDelta reports whether any column of this query emits delta or rate
+func (q *Query) HasDelta() bool {
+	for _, name := range q.MetricNames {
+		if q.Columns[name].DeltaMode() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// observeDelta records the value of a delta/rate series into next and returns
+// its increment or rate since the previous execution, false if there is none
+func (q *Collector) observeDelta(next map[string]*deltaSample, column *Column, key string, value float64, reset string, at time.Time) (float64, bool) {
+	if math.IsNaN(value) {
+		return 0, false
+	}
+	next[key] = &deltaSample{value: value, reset: reset, at: at}
+	prev := q.deltas[key]
+	if prev == nil {
+		return 0, false
+	}
+	increment := value - prev.value
+	if value < prev.value || reset != prev.reset {
+		logDebugf("query [%s] column %s counter reset detected", q.Name, column.Name)
+		increment = value
+	}
+	if column.Rate {
+		elapsed := at.Sub(prev.at).Seconds()
+		if elapsed <= 0 {
+			return 0, false
+		}
+		return increment / elapsed, true
+	}
+	return increment, true
+}
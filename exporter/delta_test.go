@@ -0,0 +1,100 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const deltaConfig = `
+pg_db:
+  query: SELECT datname, xact_commit, blks_read, stats_reset FROM test
+  metrics:
+    - datname:     { usage: LABEL }
+    - xact_commit: { usage: COUNTER, delta: true, reset_column: stats_reset }
+    - blks_read:   { usage: COUNTER, rate: true }
+    - stats_reset: { usage: DISCARD }
+`
+
+func TestParseConfigDelta(t *testing.T) {
+	queries, err := ParseConfig([]byte(deltaConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_db"]
+	if !q.HasDelta() || q.Columns["xact_commit"].PrometheusValueType() != prometheus.GaugeValue {
+		t.Fatalf("delta column should be emitted as gauge: %+v", q.Columns["xact_commit"])
+	}
+	explain := q.Explain()
+	if !strings.Contains(explain, "Emit delta since last execution, reset on stats_reset change") || !strings.Contains(explain, "Emit rate since last execution") {
+		t.Fatalf("explain should render delta and rate:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"gauge":          `{ usage: GAUGE, delta: true }`,
+		"delta and rate": `{ usage: COUNTER, delta: true, rate: true }`,
+		"reset only":     `{ usage: COUNTER, reset_column: r }`,
+		"unknown reset":  `{ usage: COUNTER, rate: true, reset_column: x }`,
+		"self reset":     `{ usage: COUNTER, rate: true, reset_column: c }`,
+	}
+	for name, column := range tests {
+		content := "q:\n  query: SELECT 1\n  metrics:\n    - r: { usage: DISCARD }\n    - c: " + column + "\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCollectorDelta(t *testing.T) {
+	queries, err := ParseConfig([]byte(deltaConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values [][]driver.Value
+	collector := newFakeCollector(t, queries["pg_db"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "xact_commit", "blks_read", "stats_reset"}, values: values}
+	}, nil)
+	begin := time.Now()
+	execute := func(offset time.Duration, rows ...[]driver.Value) map[string]float64 {
+		t.Helper()
+		values = rows
+		collector.scrapeBegin = begin.Add(offset)
+		collector.execute()
+		if err := collector.Error(); err != nil {
+			t.Fatal(err)
+		}
+		samples, _ := gatherSamples(t, collector)
+		return samples
+	}
+	lbl := func(datname string) map[string]string {
+		return map[string]string{"cluster": "c1", "datname": datname}
+	}
+
+	// first execution has no previous value
+	if samples := execute(0, []driver.Value{"a", int64(100), int64(1000), "r1"}, []driver.Value{"b", int64(5), int64(50), "r1"}); len(samples) != 0 {
+		t.Fatalf("first execution should emit nothing, got %v", samples)
+	}
+	samples := execute(10*time.Second, []driver.Value{"a", int64(130), int64(1500), "r1"}, []driver.Value{"b", int64(2), int64(80), "r1"})
+	requireSample(t, samples, "pg_db_xact_commit", lbl("a"), 30)
+	requireSample(t, samples, "pg_db_blks_read", lbl("a"), 50)
+	requireSample(t, samples, "pg_db_xact_commit", lbl("b"), 2) // value decreased, counter reset
+	requireSample(t, samples, "pg_db_blks_read", lbl("b"), 3)
+
+	// stats_reset changes while value still increases, and b is evicted
+	samples = execute(20*time.Second, []driver.Value{"a", int64(140), int64(1600), "r2"})
+	requireSample(t, samples, "pg_db_xact_commit", lbl("a"), 140)
+	requireSample(t, samples, "pg_db_blks_read", lbl("a"), 10)
+	if len(samples) != 2 {
+		t.Fatalf("evicted series should not be emitted, got %v", samples)
+	}
+	// b reappears and starts over
+	samples = execute(30*time.Second, []driver.Value{"a", int64(150), int64(1600), "r2"}, []driver.Value{"b", int64(9), int64(90), "r1"})
+	requireSample(t, samples, "pg_db_xact_commit", lbl("a"), 10)
+	requireSample(t, samples, "pg_db_blks_read", lbl("a"), 0)
+	if len(samples) != 2 {
+		t.Fatalf("reappeared series should have no previous value, got %v", samples)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 /* ================ Exporter ================ */
@@ -41,6 +42,9 @@ type Exporter struct {
 	tags            []string          // tags passed to this exporter for scheduling purpose
 	namespace       string            // metrics prefix ('pg' or 'pgbouncer' by default)
 	connectTimeout  int               // timeout in ms when perform server pre-check
+	settings        []string          // pg_settings gathered during server pre-check for planning
+	planCheck       bool              // validate queries with EXPLAIN during planning
+	cacheDir        string            // dir persisting results of long ttl queries, disabled if empty
 
 	// internal status
 	lock    sync.RWMutex       // export lock
@@ -48,6 +52,9 @@ type Exporter struct {
 	sLock   sync.RWMutex       // server map lock
 	servers map[string]*Server // auto discovered peripheral servers
 	queries map[string]*Query  // metrics query definition
+	relabel RelabelRules       // exporter-wide metric relabel rules
+	lookups map[string]*Lookup // lookup definitions used by LABEL columns
+	descs   *relabelDescs      // internal metric descriptors recorded for relabel rules
 
 	// internal stats
 	scrapeBegin time.Time // server level scrape begin
@@ -78,6 +85,9 @@ type Exporter struct {
 	queryScrapePredicateSkipCountDesc *prometheus.Desc // {datname,query} query level: predicate skip count
 	queryScrapeDurationDesc           *prometheus.Desc // {datname,query} query level: execution duration (seconds)
 	queryScrapeMetricCountDesc        *prometheus.Desc // {datname,query} query level: returned metric count
+	queryScrapeUnknownValueCountDesc  *prometheus.Desc // {datname,query} query level: unknown stateset or mapping values
+	queryScrapeSeriesDroppedDesc      *prometheus.Desc // {datname,query} query level: series dropped by max_series
+	queryPlannedDesc                  *prometheus.Desc // {datname,query,status} query level: planning status
 	queryScrapeHitCountDesc           *prometheus.Desc // {datname,query} query level: cache hit count
 
 	// lock-free health snapshot for high-frequency probes
@@ -302,34 +312,46 @@ func (e *Exporter) collectServerMetrics(ch chan<- prometheus.Metric) {
 		queryScrapeErrorCount := s.queryScrapeErrorCount
 		queryScrapePredicateSkipCount := s.queryScrapePredicateSkipCount
 		queryScrapeMetricCount := s.queryScrapeMetricCount
+		queryScrapeUnknownValueCount := s.queryScrapeUnknownValueCount
+		queryScrapeSeriesDropped := s.queryScrapeSeriesDropped
 		queryScrapeDuration := s.queryScrapeDuration
+		planStatus := s.planStatus
 		s.lock.RUnlock()
 
-		ch <- prometheus.MustNewConstMetric(e.serverScrapeDurationDesc, prometheus.GaugeValue, scrapeDur, datname)
-		ch <- prometheus.MustNewConstMetric(e.serverScrapeTotalSecondsDesc, prometheus.GaugeValue, totalSeconds, datname)
-		ch <- prometheus.MustNewConstMetric(e.serverScrapeTotalCountDesc, prometheus.GaugeValue, totalCount, datname)
-		ch <- prometheus.MustNewConstMetric(e.serverScrapeErrorCountDesc, prometheus.GaugeValue, errorCount, datname)
+		e.emit(ch, e.serverScrapeDurationDesc, prometheus.GaugeValue, scrapeDur, datname)
+		e.emit(ch, e.serverScrapeTotalSecondsDesc, prometheus.GaugeValue, totalSeconds, datname)
+		e.emit(ch, e.serverScrapeTotalCountDesc, prometheus.GaugeValue, totalCount, datname)
+		e.emit(ch, e.serverScrapeErrorCountDesc, prometheus.GaugeValue, errorCount, datname)
 
 		for queryName, v := range queryCacheTTL {
-			ch <- prometheus.MustNewConstMetric(e.queryCacheTTLDesc, prometheus.GaugeValue, v, datname, queryName)
+			e.emit(ch, e.queryCacheTTLDesc, prometheus.GaugeValue, v, datname, queryName)
 		}
 		for queryName, v := range queryScrapeTotalCount {
-			ch <- prometheus.MustNewConstMetric(e.queryScrapeTotalCountDesc, prometheus.GaugeValue, v, datname, queryName)
+			e.emit(ch, e.queryScrapeTotalCountDesc, prometheus.GaugeValue, v, datname, queryName)
 		}
 		for queryName, v := range queryScrapeHitCount {
-			ch <- prometheus.MustNewConstMetric(e.queryScrapeHitCountDesc, prometheus.GaugeValue, v, datname, queryName)
+			e.emit(ch, e.queryScrapeHitCountDesc, prometheus.GaugeValue, v, datname, queryName)
 		}
 		for queryName, v := range queryScrapeErrorCount {
-			ch <- prometheus.MustNewConstMetric(e.queryScrapeErrorCountDesc, prometheus.GaugeValue, v, datname, queryName)
+			e.emit(ch, e.queryScrapeErrorCountDesc, prometheus.GaugeValue, v, datname, queryName)
 		}
 		for queryName, v := range queryScrapePredicateSkipCount {
-			ch <- prometheus.MustNewConstMetric(e.queryScrapePredicateSkipCountDesc, prometheus.GaugeValue, v, datname, queryName)
+			e.emit(ch, e.queryScrapePredicateSkipCountDesc, prometheus.GaugeValue, v, datname, queryName)
 		}
 		for queryName, v := range queryScrapeMetricCount {
-			ch <- prometheus.MustNewConstMetric(e.queryScrapeMetricCountDesc, prometheus.GaugeValue, v, datname, queryName)
+			e.emit(ch, e.queryScrapeMetricCountDesc, prometheus.GaugeValue, v, datname, queryName)
+		}
+		for queryName, v := range queryScrapeUnknownValueCount {
+			e.emit(ch, e.queryScrapeUnknownValueCountDesc, prometheus.GaugeValue, v, datname, queryName)
+		}
+		for queryName, v := range queryScrapeSeriesDropped {
+			e.emit(ch, e.queryScrapeSeriesDroppedDesc, prometheus.CounterValue, v, datname, queryName)
 		}
 		for queryName, v := range queryScrapeDuration {
-			ch <- prometheus.MustNewConstMetric(e.queryScrapeDurationDesc, prometheus.GaugeValue, v, datname, queryName)
+			e.emit(ch, e.queryScrapeDurationDesc, prometheus.GaugeValue, v, datname, queryName)
+		}
+		for branch, status := range planStatus {
+			e.emit(ch, e.queryPlannedDesc, prometheus.GaugeValue, 1, datname, branch, status)
 		}
 	}
 }
@@ -390,16 +412,18 @@ func (e *Exporter) setupInternalMetrics() {
 		}
 	}
 
+	e.descs = newRelabelDescs()
+
 	// major fact
-	e.up = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.up = e.internalGauge(prometheus.GaugeOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Name: "up", Help: "last scrape was able to connect to the server: 1 for yes, 0 for no",
 	})
-	e.version = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.version = e.internalGauge(prometheus.GaugeOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Name: "version", Help: "server version number",
 	})
-	e.recovery = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.recovery = e.internalGauge(prometheus.GaugeOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Name: "in_recovery", Help: "server is in recovery mode? 1 for yes 0 for no",
 	})
@@ -418,7 +442,7 @@ func (e *Exporter) setupInternalMetrics() {
 	for k, v := range e.constLabels {
 		buildInfoLabels[k] = v
 	}
-	e.buildInfo = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.buildInfo = e.internalGauge(prometheus.GaugeOpts{
 		Namespace:   e.namespace,
 		Name:        "exporter_build_info",
 		Help:        "A metric with a constant '1' value labeled with version, revision, branch, goversion, builddate, goos, and goarch from which pg_exporter was built.",
@@ -428,106 +452,163 @@ func (e *Exporter) setupInternalMetrics() {
 	e.buildInfo.Set(1)
 
 	// exporter level metrics
-	e.exporterUp = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.exporterUp = e.internalGauge(prometheus.GaugeOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Subsystem: "exporter", Name: "up", Help: "always be 1 if your could retrieve metrics",
 	})
-	e.exporterUptime = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.exporterUptime = e.internalGauge(prometheus.GaugeOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Subsystem: "exporter", Name: "uptime", Help: "seconds since exporter primary server inited",
 	})
-	e.scrapeTotalCount = prometheus.NewCounter(prometheus.CounterOpts{
+	e.scrapeTotalCount = e.internalCounter(prometheus.CounterOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Subsystem: "exporter", Name: "scrape_total_count", Help: "times exporter was scraped for metrics",
 	})
-	e.scrapeErrorCount = prometheus.NewCounter(prometheus.CounterOpts{
+	e.scrapeErrorCount = e.internalCounter(prometheus.CounterOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Subsystem: "exporter", Name: "scrape_error_count", Help: "times exporter was scraped for metrics and failed",
 	})
-	e.scrapeDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.scrapeDuration = e.internalGauge(prometheus.GaugeOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Subsystem: "exporter", Name: "scrape_duration", Help: "seconds exporter spending on scraping",
 	})
-	e.lastScrapeTime = prometheus.NewGauge(prometheus.GaugeOpts{
+	e.lastScrapeTime = e.internalGauge(prometheus.GaugeOpts{
 		Namespace: e.namespace, ConstLabels: e.constLabels,
 		Subsystem: "exporter", Name: "last_scrape_time", Help: "last scrape timestamp",
 	})
 
 	// Dynamic per-server/per-query series.
 	// These are described via *prometheus.Desc and emitted as const metrics on each scrape.
-	e.serverScrapeDurationDesc = prometheus.NewDesc(
+	e.serverScrapeDurationDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_server", "scrape_duration"),
 		"seconds exporter server spending on scraping last scrape",
 		[]string{"datname"}, e.constLabels,
 	)
-	e.serverScrapeTotalSecondsDesc = prometheus.NewDesc(
+	e.serverScrapeTotalSecondsDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_server", "scrape_total_seconds"),
 		"cumulative total seconds exporter server spending on scraping",
 		[]string{"datname"}, e.constLabels,
 	)
-	e.serverScrapeTotalCountDesc = prometheus.NewDesc(
+	e.serverScrapeTotalCountDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_server", "scrape_total_count"),
 		"times exporter server was scraped for metrics",
 		[]string{"datname"}, e.constLabels,
 	)
-	e.serverScrapeErrorCountDesc = prometheus.NewDesc(
+	e.serverScrapeErrorCountDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_server", "scrape_error_count"),
 		"cumulative times exporter server scrape failed (fatal scrape failures only)",
 		[]string{"datname"}, e.constLabels,
 	)
 
-	e.queryCacheTTLDesc = prometheus.NewDesc(
+	e.queryCacheTTLDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_query", "cache_ttl"),
 		"times to live of query cache",
 		[]string{"datname", "query"}, e.constLabels,
 	)
-	e.queryScrapeTotalCountDesc = prometheus.NewDesc(
+	e.queryScrapeTotalCountDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_query", "scrape_total_count"),
 		"times exporter server was scraped for metrics",
 		[]string{"datname", "query"}, e.constLabels,
 	)
-	e.queryScrapeErrorCountDesc = prometheus.NewDesc(
+	e.queryScrapeErrorCountDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_query", "scrape_error_count"),
 		"times the query failed",
 		[]string{"datname", "query"}, e.constLabels,
 	)
-	e.queryScrapePredicateSkipCountDesc = prometheus.NewDesc(
+	e.queryScrapePredicateSkipCountDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_query", "scrape_predicate_skip_count"),
 		"times the query was skipped due to a predicate returning false",
 		[]string{"datname", "query"}, e.constLabels,
 	)
-	e.queryScrapeDurationDesc = prometheus.NewDesc(
+	e.queryScrapeDurationDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_query", "scrape_duration"),
 		"seconds query spending on scraping",
 		[]string{"datname", "query"}, e.constLabels,
 	)
-	e.queryScrapeMetricCountDesc = prometheus.NewDesc(
+	e.queryScrapeMetricCountDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_query", "scrape_metric_count"),
 		"numbers of metrics been scraped from this query",
 		[]string{"datname", "query"}, e.constLabels,
 	)
-	e.queryScrapeHitCountDesc = prometheus.NewDesc(
+	e.queryScrapeUnknownValueCountDesc = e.descs.newDesc(
+		prometheus.BuildFQName(e.namespace, "exporter_query", "scrape_unknown_value_count"),
+		"times the query returned a value outside STATESET states or column mapping",
+		[]string{"datname", "query"}, e.constLabels,
+	)
+	e.queryScrapeSeriesDroppedDesc = e.descs.newDesc(
+		prometheus.BuildFQName(e.namespace, "exporter_query", "series_dropped_total"),
+		"series dropped because the query exceeded max_series",
+		[]string{"datname", "query"}, e.constLabels,
+	)
+	e.queryScrapeHitCountDesc = e.descs.newDesc(
 		prometheus.BuildFQName(e.namespace, "exporter_query", "scrape_hit_count"),
 		"numbers been scraped from this query",
 		[]string{"datname", "query"}, e.constLabels,
 	)
+	e.queryPlannedDesc = e.descs.newDesc(
+		prometheus.BuildFQName(e.namespace, "exporter_query", "planned"),
+		"planning status of query branch: installed, incompatible, permission_denied, undefined_table or template_error",
+		[]string{"datname", "query", "status"}, e.constLabels,
+	)
 
 	e.exporterUp.Set(1) // always be true
 	e.healthStatus.Store(healthStatusUnknown)
 }
 
 func (e *Exporter) collectInternalMetrics(ch chan<- prometheus.Metric) {
-	ch <- e.up
-	ch <- e.version
-	ch <- e.recovery
+	e.emitMetric(ch, e.up)
+	e.emitMetric(ch, e.version)
+	e.emitMetric(ch, e.recovery)
+
+	e.emitMetric(ch, e.buildInfo)
+	e.emitMetric(ch, e.exporterUp)
+	e.emitMetric(ch, e.exporterUptime)
+	e.emitMetric(ch, e.lastScrapeTime)
+	e.emitMetric(ch, e.scrapeTotalCount)
+	e.emitMetric(ch, e.scrapeErrorCount)
+	e.emitMetric(ch, e.scrapeDuration)
+}
 
-	ch <- e.buildInfo
-	ch <- e.exporterUp
-	ch <- e.exporterUptime
-	ch <- e.lastScrapeTime
-	ch <- e.scrapeTotalCount
-	ch <- e.scrapeErrorCount
-	ch <- e.scrapeDuration
+// internalGauge creates an internal gauge and records its descriptor for relabel rules
+func (e *Exporter) internalGauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	gauge := prometheus.NewGauge(opts)
+	e.descs.register(gauge.Desc(), prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, nil, opts.ConstLabels)
+	return gauge
+}
+
+// internalCounter creates an internal counter and records its descriptor for relabel rules
+func (e *Exporter) internalCounter(opts prometheus.CounterOpts) prometheus.Counter {
+	counter := prometheus.NewCounter(opts)
+	e.descs.register(counter.Desc(), prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, nil, opts.ConstLabels)
+	return counter
+}
+
+// emit builds an internal const metric after applying relabel rules, nothing
+// is sent if the series is dropped
+func (e *Exporter) emit(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labels ...string) {
+	if desc, labels = e.descs.apply(e.relabel, desc, valueTypeKind(valueType), labels); desc == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, valueType, value, labels...)
+}
+
+// emitMetric sends an internal gauge or counter, which is rebuilt as a const
+// metric when relabel rules are configured
+func (e *Exporter) emitMetric(ch chan<- prometheus.Metric, metric prometheus.Metric) {
+	if len(e.relabel) == 0 {
+		ch <- metric
+		return
+	}
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		logErrorf("fail writing internal metric %s: %s", metric.Desc(), err.Error())
+		return
+	}
+	if m.Counter != nil {
+		e.emit(ch, metric.Desc(), prometheus.CounterValue, m.Counter.GetValue())
+		return
+	}
+	e.emit(ch, metric.Desc(), prometheus.GaugeValue, m.GetGauge().GetValue())
 }
 
 /* ================ Exporter Creation ================ */
@@ -550,6 +631,12 @@ func newExporterWithServerFactory(dsn string, serverFactory func(string, ...Serv
 		if e.queries, err = LoadConfig(e.configPath); err != nil {
 			return nil, fmt.Errorf("fail loading config file %s: %w", e.configPath, err)
 		}
+		if e.relabel, err = LoadRelabel(e.configPath); err != nil {
+			return nil, fmt.Errorf("fail loading relabel rules %s: %w", e.configPath, err)
+		}
+		if e.lookups, err = LoadLookups(e.configPath); err != nil {
+			return nil, fmt.Errorf("fail loading lookups %s: %w", e.configPath, err)
+		}
 	}
 	if e.configReader != nil {
 		b, rerr := io.ReadAll(e.configReader)
@@ -559,14 +646,26 @@ func newExporterWithServerFactory(dsn string, serverFactory func(string, ...Serv
 		if e.queries, err = ParseConfig(b); err != nil {
 			return nil, fmt.Errorf("fail parsing config file: %w", err)
 		}
+		if e.relabel, err = ParseRelabel(b); err != nil {
+			return nil, fmt.Errorf("fail parsing relabel rules: %w", err)
+		}
+		if e.lookups, err = ParseLookups(b); err != nil {
+			return nil, fmt.Errorf("fail parsing lookups: %w", err)
+		}
 		if err := FinalizeQueries(e.queries, "<reader>"); err != nil {
 			return nil, fmt.Errorf("fail finalizing config: %w", err)
 		}
+		if err := resolveQueryFiles(e.queries, "."); err != nil {
+			return nil, fmt.Errorf("fail resolving query files: %w", err)
+		}
 	}
 
 	if err := validateConstLabelConflicts(e.constLabels, e.queries, e.disableIntro); err != nil {
 		return nil, fmt.Errorf("invalid constant labels: %w", err)
 	}
+	if err := validateLookupRefs(e.queries, e.lookups); err != nil {
+		return nil, fmt.Errorf("invalid lookups: %w", err)
+	}
 
 	logDebugf("exporter init with %d queries", len(e.queries))
 
@@ -574,10 +673,15 @@ func newExporterWithServerFactory(dsn string, serverFactory func(string, ...Serv
 	e.server = serverFactory(
 		dsn,
 		WithQueries(e.queries),
+		WithRelabel(e.relabel),
+		WithLookups(e.lookups),
 		WithConstLabel(e.constLabels),
 		WithCachePolicy(e.disableCache),
 		WithServerTags(e.tags),
 		WithServerConnectTimeout(e.connectTimeout),
+		WithServerSettings(e.settings),
+		WithServerPlanCheck(e.planCheck),
+		WithServerCacheDir(e.cacheDir),
 	)
 
 	// register db change callback
@@ -652,10 +756,15 @@ func (e *Exporter) CreateServer(dbname string) {
 	newServer := NewServer(
 		newDSN,
 		WithQueries(e.queries),
+		WithRelabel(e.relabel),
+		WithLookups(e.lookups),
 		WithConstLabel(e.constLabels),
 		WithCachePolicy(e.disableCache),
 		WithServerTags(e.tags),
 		WithServerConnectTimeout(e.connectTimeout),
+		WithServerSettings(e.settings),
+		WithServerPlanCheck(e.planCheck),
+		WithServerCacheDir(e.cacheDir),
 	)
 	newServer.Forked = true // important!
 
@@ -800,6 +909,30 @@ func WithConnectTimeout(timeout int) ExporterOpt {
 	}
 }
 
+// WithPrecheckSettings will gather given pg_settings during server pre-check.
+// They are shown in explain and could be used in query tags such as setting:track_io_timing=on
+func WithPrecheckSettings(settings string) ExporterOpt {
+	return func(e *Exporter) {
+		e.settings = parseCSV(settings)
+	}
+}
+
+// WithPlanCheck will validate each query with a zero-row EXPLAIN during planning,
+// and discard queries the monitor role could not run
+func WithPlanCheck(planCheck bool) ExporterOpt {
+	return func(e *Exporter) {
+		e.planCheck = planCheck
+	}
+}
+
+// WithCacheDir will persist results of long ttl queries in given dir, and
+// restore them after restart if they are still valid
+func WithCacheDir(dir string) ExporterOpt {
+	return func(e *Exporter) {
+		e.cacheDir = dir
+	}
+}
+
 /* ================ Exporter RESTAPI ================ */
 
 func currentExporter() *Exporter {
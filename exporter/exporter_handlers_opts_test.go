@@ -22,6 +22,7 @@ func TestExporterOptionHelpers(t *testing.T) {
 	WithExcludeDatabase("template0,template1")(e)
 	WithIncludeDatabase("app,metrics")(e)
 	WithConnectTimeout(500)(e)
+	WithPrecheckSettings("track_io_timing, shared_buffers")(e)
 
 	if e.configPath != "/tmp/c.yml" {
 		t.Fatalf("configPath = %s", e.configPath)
@@ -47,6 +48,9 @@ func TestExporterOptionHelpers(t *testing.T) {
 	if e.connectTimeout != 500 {
 		t.Fatalf("connectTimeout = %d", e.connectTimeout)
 	}
+	if len(e.settings) != 2 || e.settings[0] != "track_io_timing" || e.settings[1] != "shared_buffers" {
+		t.Fatalf("settings = %#v", e.settings)
+	}
 }
 
 func TestPublicHandlers(t *testing.T) {
@@ -0,0 +1,352 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* ================ Computed Column ================ */
+
+// A GAUGE/COUNTER column with `expr` is computed from other columns of the
+// same row instead of being read from the result, e.g.
+//
+//	hit_ratio: { usage: GAUGE, expr: "blks_hit / (blks_hit + blks_read)" }
+//
+// Expressions support numbers, column names, true/false, parentheses,
+// arithmetic `+ - * / %`, comparisons `< <= > >= == !=` and logic `&& || !`,
+// where comparisons and logic yield 1 or 0. Operands are cast like metric
+// values, so their scale and default apply, and NULL operands become NaN.
+// NaN propagates through the expression, division or modulo by zero yields
+// NaN instead of Inf, and a NaN result falls back to the column default.
+// Scale of the computed column applies to the result.
+
+// exprNode is a node of a parsed expression, values are evaluated as float64
+type exprNode interface {
+	eval(operand func(name string) float64) float64
+}
+
+type exprNumber float64
+
+type exprColumn string
+
+type exprUnary struct {
+	op string
+	x  exprNode
+}
+
+type exprBinary struct {
+	op   string
+	x, y exprNode
+}
+
+func (n exprNumber) eval(func(string) float64) float64 { return float64(n) }
+
+func (n exprColumn) eval(operand func(string) float64) float64 { return operand(string(n)) }
+
+func (n *exprUnary) eval(operand func(string) float64) float64 {
+	x := n.x.eval(operand)
+	if math.IsNaN(x) {
+		return x
+	}
+	if n.op == "!" {
+		return exprBool(x == 0)
+	}
+	return -x
+}
+
+func (n *exprBinary) eval(operand func(string) float64) float64 {
+	x, y := n.x.eval(operand), n.y.eval(operand)
+	if math.IsNaN(x) || math.IsNaN(y) {
+		return math.NaN()
+	}
+	switch n.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return math.NaN()
+		}
+		return x / y
+	case "%":
+		if y == 0 {
+			return math.NaN()
+		}
+		return math.Mod(x, y)
+	case "<":
+		return exprBool(x < y)
+	case "<=":
+		return exprBool(x <= y)
+	case ">":
+		return exprBool(x > y)
+	case ">=":
+		return exprBool(x >= y)
+	case "==":
+		return exprBool(x == y)
+	case "!=":
+		return exprBool(x != y)
+	case "&&":
+		return exprBool(x != 0 && y != 0)
+	case "||":
+		return exprBool(x != 0 || y != 0)
+	}
+	return math.NaN()
+}
+
+func exprBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// exprParser is a recursive descent parser of computed column expressions
+type exprParser struct {
+	tokens  []string
+	pos     int
+	columns []string // referenced column names in order of appearance
+}
+
+// exprBinaryLevels lists binary operators from the lowest precedence to the highest
+var exprBinaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<=", ">=", "<", ">"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseExpr parses an expression and returns its root node and referenced columns
+func parseExpr(expr string) (exprNode, []string, error) {
+	tokens, err := tokenizeExpr(expr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("empty expression")
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseBinary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("unexpected %q at token %d", p.tokens[p.pos], p.pos+1)
+	}
+	return node, p.columns, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseBinary(level int) (exprNode, error) {
+	if level == len(exprBinaryLevels) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if !slices.Contains(exprBinaryLevels[level], op) {
+			return x, nil
+		}
+		p.pos++
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: op, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	switch op := p.peek(); op {
+	case "-", "!", "+":
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil || op == "+" {
+			return x, err
+		}
+		return &exprUnary{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	token := p.peek()
+	p.pos++
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		x, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return x, nil
+	case token == "true":
+		return exprNumber(1), nil
+	case token == "false":
+		return exprNumber(0), nil
+	case isExprIdentStart(rune(token[0])):
+		p.columns = append(p.columns, token)
+		return exprColumn(token), nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		f, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return exprNumber(f), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at token %d", token, p.pos)
+}
+
+// tokenizeExpr splits an expression into numbers, identifiers, operators and parentheses
+func tokenizeExpr(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case isExprIdentStart(r):
+			j := i + 1
+			for j < len(runes) && (isExprIdentStart(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case unicode.IsDigit(r) || r == '.':
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' ||
+				(runes[j] == 'e' || runes[j] == 'E') ||
+				((runes[j] == '+' || runes[j] == '-') && (runes[j-1] == 'e' || runes[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			if i+1 < len(runes) {
+				if op := string(runes[i : i+2]); slices.Contains([]string{"<=", ">=", "==", "!=", "&&", "||"}, op) {
+					tokens = append(tokens, op)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%<>!()", r) {
+				return nil, fmt.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isExprIdentStart(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// validateExpr parses expr of a computed column, which is only supported by
+// GAUGE and COUNTER. Operands must be other columns of the same query that
+// are read from the result, so computed columns cannot reference each other.
+func validateExpr(column *Column, columns map[string]*Column) error {
+	if column.Expr == "" {
+		return nil
+	}
+	if column.Usage != GAUGE && column.Usage != COUNTER {
+		return fmt.Errorf("expr is only supported by GAUGE and COUNTER, got %s", column.Usage)
+	}
+	if len(column.Mapping) > 0 {
+		return fmt.Errorf("expr cannot be used with mapping")
+	}
+	node, operands, err := parseExpr(column.Expr)
+	if err != nil {
+		return fmt.Errorf("invalid expr %q: %w", column.Expr, err)
+	}
+	for _, name := range operands {
+		operand := columns[name]
+		if operand == nil {
+			return fmt.Errorf("expr operand column %q is not defined", name)
+		}
+		if operand.Expr != "" {
+			return fmt.Errorf("expr operand column %q is a computed column", name)
+		}
+	}
+	column.expr, column.exprOperands = node, operands
+	return nil
+}
+
+// IsComputed reports whether this column is computed from expr instead of read from result
+func (c *Column) IsComputed() bool {
+	return c.expr != nil
+}
+
+// missingExprOperands returns computed metric columns with an operand column
+// missing in result, warning once per execution for each of them. They are
+// skipped like a missing metric column.
+func (q *Collector) missingExprOperands(columnIndexes map[string]int) map[string]bool {
+	var missing map[string]bool
+	for _, metricName := range q.MetricNames {
+		column := q.Columns[metricName]
+		if !column.IsComputed() {
+			continue
+		}
+		for _, name := range column.exprOperands {
+			if _, found := columnIndexes[name]; !found {
+				logWarnf("missing expr operand column %s.%s of %s in result", q.Name, name, metricName)
+				if missing == nil {
+					missing = make(map[string]bool)
+				}
+				missing[metricName] = true
+				break
+			}
+		}
+	}
+	return missing
+}
+
+// evalExpr computes the value of a computed column from one result row,
+// operand columns are checked by missingExprOperands in advance
+func (q *Collector) evalExpr(column *Column, colData []interface{}, columnIndexes map[string]int) float64 {
+	value := column.expr.eval(func(name string) float64 {
+		return castFloat64(colData[columnIndexes[name]], q.Columns[name])
+	})
+	if math.IsNaN(value) && column.hasDefault {
+		value = column.defaultValue
+	}
+	if column.hasScale {
+		value *= column.scaleFactor
+	}
+	return value
+}
+
+// ResultColumnCount returns how many columns this query reads from result,
+// which excludes computed columns
+func (q *Query) ResultColumnCount() int {
+	n := len(q.Columns)
+	for _, column := range q.Columns {
+		if column.IsComputed() {
+			n--
+		}
+	}
+	return n
+}
@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const exprConfig = `
+pg_db:
+  query: SELECT datname, blks_hit, blks_read, age, is_template FROM test
+  metrics:
+    - datname:     { usage: LABEL }
+    - blks_hit:    { usage: GAUGE }
+    - blks_read:   { usage: DISCARD }
+    - age:         { usage: DISCARD }
+    - is_template: { usage: DISCARD }
+    - hit_ratio:   { usage: GAUGE, expr: "blks_hit / (blks_hit + blks_read)", default: -1 }
+    - age_pct:     { usage: GAUGE, expr: "age / 2e9", scale: 100 }
+    - template:    { usage: GAUGE, expr: "is_template" }
+    - stale:       { usage: GAUGE, expr: "age > 1.5e9 && !is_template" }
+`
+
+func TestParseExpr(t *testing.T) {
+	operand := func(name string) float64 {
+		return map[string]float64{"a": 3, "b": 4, "z": 0}[name]
+	}
+	tests := map[string]float64{
+		"1 + 2 * 3":         7,
+		"(1 + 2) * 3":       9,
+		"-a + b":            1,
+		"a / b":             0.75,
+		"b % a":             1,
+		"2e3 / 1E+3":        2,
+		"a < b && b <= 4":   1,
+		"a == b || !z":      1,
+		"!(a != 3)":         1,
+		"true + false":      1,
+		"a >= b":            0,
+		"10 - 4 - 3":        3,
+		"a > b == false":    1,
+		"- - a":             3,
+		".5 * 4":            2,
+		"a / z":             math.NaN(),
+		"a % z":             math.NaN(),
+		"(a / z) * 0 + 1":   math.NaN(),
+		"!(a / z) || 1":     math.NaN(),
+		"a_1 + 1":           1,
+		"+a":                3,
+		"a*b-a*b/b":         9,
+		"(((a)))":           3,
+		"1 / 3 < 0.34":      1,
+		"a > 1 && b > 1":    1,
+		"a > 1 && b > 5":    0,
+		"-2e-1 * 10":        -2,
+		"z || z || b":       1,
+		"1 - 2 + 3":         2,
+		"12 / 3 / 2":        2,
+		"2 * (3 + (4 - 1))": 12,
+	}
+	for expr, want := range tests {
+		node, _, err := parseExpr(expr)
+		if err != nil {
+			t.Fatalf("parse %q: %v", expr, err)
+		}
+		got := node.eval(operand)
+		if got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+			t.Fatalf("eval %q = %v, want %v", expr, got, want)
+		}
+	}
+
+	if _, operands, _ := parseExpr("a / (a + b_2)"); strings.Join(operands, ",") != "a,a,b_2" {
+		t.Fatalf("unexpected operands: %v", operands)
+	}
+	for _, expr := range []string{"", "1 +", "(1", "1)", "a b", "1 # 2", "1e", "a = b", "*1"} {
+		if _, _, err := parseExpr(expr); err == nil {
+			t.Fatalf("parse %q: expected error", expr)
+		}
+	}
+}
+
+func TestParseConfigExpr(t *testing.T) {
+	queries, err := ParseConfig([]byte(exprConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_db"]
+	if !q.Columns["hit_ratio"].IsComputed() || q.Columns["blks_hit"].IsComputed() || q.ResultColumnCount() != 5 {
+		t.Fatalf("computed columns should be parsed: %+v", q.Columns["hit_ratio"])
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "Expr blks_hit / (blks_hit + blks_read)") {
+		t.Fatalf("explain should render expr:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"label usage":      `{ usage: LABEL, expr: "v" }`,
+		"stateset usage":   `{ usage: STATESET, states: [ a ], expr: "v" }`,
+		"with mapping":     `{ usage: GAUGE, mapping: { a: 1 }, expr: "v" }`,
+		"unknown operand":  `{ usage: GAUGE, expr: "v + w" }`,
+		"computed operand": `{ usage: GAUGE, expr: "e" }`,
+		"syntax error":     `{ usage: GAUGE, expr: "v +" }`,
+	}
+	for name, column := range tests {
+		content := "q:\n  query: SELECT 1\n  metrics:\n    - v: { usage: GAUGE }\n    - e: { usage: GAUGE, expr: v }\n    - c: " + column + "\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCollectorExpr(t *testing.T) {
+	queries, err := ParseConfig([]byte(exprConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"postgres", int64(90), int64(10), int64(1e9), false},
+		{"template1", int64(0), int64(0), int64(1.6e9), true},
+		{"meta", int64(5), nil, int64(1.8e9), false},
+	}
+	collector := newFakeCollector(t, queries["pg_db"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "blks_hit", "blks_read", "age", "is_template"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute computed query: %v", err)
+	}
+	samples, _ := gatherSamples(t, collector)
+	lbl := func(datname string) map[string]string {
+		return map[string]string{"cluster": "c1", "datname": datname}
+	}
+	requireSample(t, samples, "pg_db_hit_ratio", lbl("postgres"), 0.9)
+	requireSample(t, samples, "pg_db_hit_ratio", lbl("template1"), -1) // division by zero falls back to default
+	requireSample(t, samples, "pg_db_hit_ratio", lbl("meta"), -1)      // NULL operand falls back to default
+	requireSample(t, samples, "pg_db_age_pct", lbl("postgres"), 50)
+	requireSample(t, samples, "pg_db_template", lbl("template1"), 1)
+	requireSample(t, samples, "pg_db_template", lbl("postgres"), 0)
+	requireSample(t, samples, "pg_db_stale", lbl("meta"), 1)
+	requireSample(t, samples, "pg_db_stale", lbl("template1"), 0)
+	requireSample(t, samples, "pg_db_stale", lbl("postgres"), 0)
+}
+
+func TestCollectorExprMissingOperand(t *testing.T) {
+	queries, err := ParseConfig([]byte(exprConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	originalLogger := Logger
+	Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	defer func() { Logger = originalLogger }()
+
+	values := [][]driver.Value{{"postgres", int64(90), int64(1e9), false}, {"meta", int64(5), int64(1.8e9), false}}
+	collector := newFakeCollector(t, queries["pg_db"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "blks_hit", "age", "is_template"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute computed query: %v", err)
+	}
+	if n := strings.Count(buf.String(), "missing expr operand column pg_db.blks_read of hit_ratio"); n != 1 {
+		t.Fatalf("missing operand should be warned once per execution, got %d:\n%s", n, buf.String())
+	}
+	samples, _ := gatherSamples(t, collector)
+	if _, found := samples[sampleKey("pg_db_hit_ratio", map[string]string{"cluster": "c1", "datname": "postgres"})]; found {
+		t.Fatal("computed column with missing operand should be skipped")
+	}
+	requireSample(t, samples, "pg_db_age_pct", map[string]string{"cluster": "c1", "datname": "meta"}, 90)
+}
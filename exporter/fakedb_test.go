@@ -0,0 +1,172 @@
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeConnector is a scripted database/sql connector shared by collector tests.
+// Each query fails with queryErr if set, is answered by respond if set, and
+// by rows of rowsFactory otherwise. queryCount counts executed queries.
+type fakeConnector struct {
+	rowsFactory func() driver.Rows
+	respond     func(query string, args []driver.NamedValue) (driver.Rows, error)
+	queryCount  *int
+	queryErr    error
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{c: c}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake test driver must be opened through its connector")
+}
+
+type fakeConn struct {
+	c fakeConnector
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.c.queryCount != nil {
+		*c.c.queryCount++
+	}
+	if c.c.queryErr != nil {
+		return nil, c.c.queryErr
+	}
+	if c.c.respond != nil {
+		return c.c.respond(query, args)
+	}
+	return c.c.rowsFactory(), nil
+}
+
+// fakeRows returns values as rows of columns
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	index   int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.index])
+	r.index++
+	return nil
+}
+
+// openFakeDB opens a database on connector closed at the end of test
+func openFakeDB(t *testing.T, connector fakeConnector) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// newFakeServer returns a server of database postgres with label cluster=c1 on connector
+func newFakeServer(t *testing.T, connector fakeConnector) *Server {
+	t.Helper()
+	return &Server{
+		DB:       openFakeDB(t, connector),
+		Database: "postgres",
+		labels:   prometheus.Labels{"cluster": "c1"},
+	}
+}
+
+func newFakeCollector(t *testing.T, query *Query, factory func() driver.Rows, queryCount *int) *Collector {
+	t.Helper()
+	return NewCollector(query, newFakeServer(t, fakeConnector{rowsFactory: factory, queryCount: queryCount}))
+}
+
+func newFakeQueryErrorCollector(t *testing.T, query *Query, queryErr error) *Collector {
+	t.Helper()
+	return NewCollector(query, newFakeServer(t, fakeConnector{queryErr: queryErr}))
+}
+
+// gatherSamples collects gauge samples of collector keyed by sampleKey
+func gatherSamples(t *testing.T, collector *Collector) (map[string]float64, map[string]*dto.MetricFamily) {
+	t.Helper()
+	collector.TTL = 3600
+	collector.Server.scrapeBegin = time.Now()
+	collector.lastScrape = collector.Server.scrapeBegin
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("register collector: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather collector: %v", err)
+	}
+
+	samples := make(map[string]float64)
+	familyMap := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		familyMap[family.GetName()] = family
+		if family.GetType() != dto.MetricType_GAUGE {
+			t.Fatalf("family %s type = %s, want GAUGE", family.GetName(), family.GetType())
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string, len(metric.GetLabel()))
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			samples[sampleKey(family.GetName(), labels)] = metric.GetGauge().GetValue()
+		}
+	}
+	return samples, familyMap
+}
+
+func sampleKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, key := range keys {
+		b.WriteByte('|')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(labels[key])
+	}
+	return b.String()
+}
+
+func requireSample(t *testing.T, samples map[string]float64, name string, labels map[string]string, want float64) {
+	t.Helper()
+	key := sampleKey(name, labels)
+	got, found := samples[key]
+	if !found {
+		t.Fatalf("missing sample %s; got %v", key, samples)
+	}
+	if math.Abs(got-want) > 1e-12 {
+		t.Fatalf("sample %s = %v, want %v", key, got, want)
+	}
+}
@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+/* ================ Foreach ================ */
+
+// A query with `foreach` runs its driver SQL first, then runs the main SQL
+// once per driver row with the driver row bound as $1..$n, e.g. one query per
+// partitioned parent, schema or replication slot. All executions share the
+// collector timeout. Driver values are appended to every row of their main
+// result as extra columns named after driver columns, so a driver column
+// declared as LABEL becomes a label, and one declared as DISCARD is only used
+// as a bind parameter. Any other driver column fails the query, since series of
+// different driver rows would collide without it as a label. Driver and main
+// result columns must not share a name.
+
+// foreachRows holds the result of a foreach driver query
+type foreachRows struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// executeForeach runs the driver query and reads all its rows, so they are
+// released before the main SQL runs on the same connection pool
+func (q *Collector) executeForeach(ctx context.Context) (*foreachRows, error) {
+	driverSQL, args, err := q.bind(q.boundForeach, 0)
+	if err != nil {
+		return nil, fmt.Errorf("foreach driver query: %w", err)
+	}
+	rows, err := q.Server.QueryContext(ctx, driverSQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("foreach driver query: %w", err)
+	}
+	defer func(rows *sql.Rows) { _ = rows.Close() }(rows)
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("foreach driver query: %w", err)
+	}
+	for _, name := range columns {
+		if column := q.Columns[name]; column == nil || (column.Usage != LABEL && column.Usage != DISCARD) {
+			return nil, fmt.Errorf("foreach driver column %s.%s must be declared as LABEL or DISCARD", q.Name, name)
+		}
+	}
+	driver := &foreachRows{columns: columns}
+	for rows.Next() {
+		data := make([]interface{}, len(columns))
+		args := make([]interface{}, len(columns))
+		for i := range data {
+			args[i] = &data[i]
+		}
+		if err = rows.Scan(args...); err != nil {
+			return nil, fmt.Errorf("foreach driver query: %w", err)
+		}
+		driver.rows = append(driver.rows, data)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("foreach driver query: %w", err)
+	}
+	return driver, nil
+}
+
+// foreachScanner scans rows of the main SQL for each driver row in turn, the
+// main result of the first driver row is opened by the caller
+type foreachScanner struct {
+	q          *Collector
+	ctx        context.Context
+	driver     *foreachRows
+	current    int       // index of driver row bound to rows
+	rows       *sql.Rows // main result of current driver row
+	nColumn    int       // number of main result columns
+	sql        string    // main SQL with named parameters numbered after driver columns
+	named      []interface{}
+	colArgs    []interface{}
+	colData    []interface{}
+	converters []*columnConverter
+	columns    []string
+}
+
+// scan scans the next main result row and appends values of its driver row,
+// moving on to the next driver row when a main result is exhausted
+func (s *foreachScanner) scan() (bool, error) {
+	for !s.rows.Next() {
+		if err := s.rows.Err(); err != nil {
+			return false, fmt.Errorf("query [%s] failed while iterating rows of foreach row %d: %w", s.q.Name, s.current, err)
+		}
+		_ = s.rows.Close()
+		if s.current++; s.current >= len(s.driver.rows) {
+			return false, nil
+		}
+		args := append(append([]interface{}{}, s.driver.rows[s.current]...), s.named...)
+		rows, err := s.q.Server.QueryContext(s.ctx, s.sql, args...)
+		if err != nil {
+			return false, fmt.Errorf("query [%s] failed for foreach row %d: %w", s.q.Name, s.current, err)
+		}
+		s.rows = rows
+		columns, err := rows.Columns()
+		if err != nil {
+			return false, fmt.Errorf("query [%s] fail retriving rows meta: %w", s.q.Name, err)
+		}
+		if len(columns) != s.nColumn {
+			return false, fmt.Errorf("query [%s] foreach row %d returned %d columns, expect %d", s.q.Name, s.current, len(columns), s.nColumn)
+		}
+	}
+	if err := s.rows.Scan(s.colArgs...); err != nil {
+		return false, fmt.Errorf("fail scanning rows: %w", err)
+	}
+	copy(s.colData[s.nColumn:], s.driver.rows[s.current])
+	if err := s.q.convertValues(s.converters, s.columns, s.colData); err != nil {
+		return false, fmt.Errorf("query [%s] %w", s.q.Name, err)
+	}
+	return true, nil
+}
+
+// close closes the main result that is still open
+func (s *foreachScanner) close() {
+	_ = s.rows.Close()
+}
@@ -0,0 +1,123 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const foreachConfig = `
+pg_part:
+  foreach: SELECT nspname, relname FROM parents
+  query: SELECT count(*) AS parts, sum(size) AS size FROM children($1, $2)
+  metrics:
+    - nspname: { usage: LABEL }
+    - relname: { usage: LABEL }
+    - parts:   { usage: GAUGE }
+    - size:    { usage: GAUGE }
+`
+
+func TestParseConfigForeach(t *testing.T) {
+	queries, err := ParseConfig([]byte(foreachConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain := queries["pg_part"].Explain(); !strings.Contains(explain, "Foreach    SELECT nspname, relname FROM parents") {
+		t.Fatalf("explain should render foreach:\n%s", explain)
+	}
+	if _, err := ParseConfig([]byte("q:\n  foreach: ' '\n  query: SELECT 1\n  metrics:\n    - v: { usage: GAUGE }\n")); err == nil {
+		t.Fatal("blank foreach should be rejected")
+	}
+}
+
+func TestCollectorForeach(t *testing.T) {
+	queries, err := ParseConfig([]byte(foreachConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents := [][]driver.Value{{"public", "events"}, {"app", "logs"}, {"app", "empty"}}
+	var bound []string
+	queried := func(args []driver.NamedValue) driver.Rows {
+		bound = append(bound, fmt.Sprintf("%v.%v", args[0].Value, args[1].Value))
+		rows := &fakeRows{columns: []string{"parts", "size"}}
+		if args[1].Value != "empty" {
+			rows.values = [][]driver.Value{{int64(len(bound)), int64(100 * len(bound))}}
+		}
+		return rows
+	}
+	// driver SQL is answered with parents, main SQL with rows of queried
+	connector := fakeConnector{respond: func(query string, args []driver.NamedValue) (driver.Rows, error) {
+		if query == queries["pg_part"].Foreach {
+			return &fakeRows{columns: []string{"nspname", "relname"}, values: parents}, nil
+		}
+		return queried(args), nil
+	}}
+	collector := NewCollector(queries["pg_part"], newFakeServer(t, connector))
+	collector.Timeout = 1
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute foreach query: %v", err)
+	}
+	if strings.Join(bound, ",") != "public.events,app.logs,app.empty" {
+		t.Fatalf("main SQL should run once per driver row, got %v", bound)
+	}
+	samples, _ := gatherSamples(t, collector)
+	lbl := func(nspname, relname string) map[string]string {
+		return map[string]string{"cluster": "c1", "nspname": nspname, "relname": relname}
+	}
+	requireSample(t, samples, "pg_part_parts", lbl("public", "events"), 1)
+	requireSample(t, samples, "pg_part_size", lbl("app", "logs"), 200)
+	if len(samples) != 4 {
+		t.Fatalf("driver rows without main rows should yield nothing, got %v", samples)
+	}
+
+	// no driver rows yields an empty result
+	parents = nil
+	collector.execute()
+	if err := collector.Error(); err != nil || collector.ResultSize() != 0 {
+		t.Fatalf("empty foreach should succeed with no metrics, got %v %d", err, collector.ResultSize())
+	}
+
+	// driver columns must not shadow result columns
+	parents = [][]driver.Value{{"public", "events"}}
+	queried = func([]driver.NamedValue) driver.Rows {
+		return &fakeRows{columns: []string{"relname", "parts", "size"}, values: [][]driver.Value{{"x", int64(1), int64(1)}}}
+	}
+	collector.execute()
+	if err := collector.Error(); err == nil || !strings.Contains(err.Error(), "foreach column pg_part.relname conflicts") {
+		t.Fatalf("conflicting foreach column should fail the query, got %v", err)
+	}
+}
+
+func TestCollectorForeachUndeclaredDriverColumn(t *testing.T) {
+	queries, err := ParseConfig([]byte(`
+pg_part:
+  foreach: SELECT relname FROM parents
+  query: SELECT count(*) AS parts FROM children($1)
+  metrics:
+    - parts: { usage: GAUGE }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queried := 0
+	connector := fakeConnector{respond: func(query string, _ []driver.NamedValue) (driver.Rows, error) {
+		if query == queries["pg_part"].Foreach {
+			return &fakeRows{columns: []string{"relname"}, values: [][]driver.Value{{"events"}, {"logs"}}}, nil
+		}
+		queried++
+		return &fakeRows{columns: []string{"parts"}, values: [][]driver.Value{{int64(queried)}}}, nil
+	}}
+	collector := NewCollector(queries["pg_part"], newFakeServer(t, connector))
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err == nil || !strings.Contains(err.Error(), "foreach driver column pg_part.relname must be declared as LABEL or DISCARD") {
+		t.Fatalf("undeclared driver column should fail the query, got %v", err)
+	}
+	if queried != 0 || collector.ResultSize() != 0 {
+		t.Fatalf("main SQL should not run with undeclared driver columns, ran %d times with %d metrics", queried, collector.ResultSize())
+	}
+}
@@ -0,0 +1,156 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+/* ================ Pre-aggregated Histogram ================ */
+
+// A HISTOGRAM_BUCKET column holds the cumulative count of one bucket per row,
+// so SQL can aggregate observations server-side (e.g. with width_bucket):
+//
+//	SELECT datname, le, cumulative_count, sum, count FROM ...
+//
+// Rows sharing a label tuple form one histogram. The upper bound is read from
+// the `le` column and must be a configured bucket or +Inf. Buckets missing
+// from the result inherit the cumulative count of the previous bucket. The
+// sum (and optional count) columns must hold the same value on every row of a
+// histogram. The result is emitted as the same families as HISTOGRAM.
+
+// observeHistogramBucket adds one result row to a pre-aggregated histogram
+func (q *Collector) observeHistogramBucket(a *histogramAccumulator, colData []interface{}, columnIndexes map[string]int, dataIndex int) error {
+	column := a.column
+	cumulative, present, err := castHistogramCount(colData[dataIndex], column)
+	if err != nil {
+		return err
+	}
+	if present {
+		le, err := castHistogramBound(colData[columnIndexes[column.LeColumn()]], q.Columns[column.LeColumn()])
+		if err != nil {
+			return fmt.Errorf("le: %w", err)
+		}
+		if err = a.observeBucket(le, cumulative); err != nil {
+			return err
+		}
+	}
+
+	sum, present, err := castHistogramFloat64(colData[columnIndexes[column.Sum]], q.Columns[column.Sum])
+	if err != nil {
+		return fmt.Errorf("sum: %w", err)
+	}
+	if present {
+		if a.hasSum && a.sum != sum {
+			return fmt.Errorf("inconsistent sum %v and %v in one histogram", a.sum, sum)
+		}
+		a.sum, a.hasSum = sum, true
+	}
+
+	if column.Count != "" {
+		count, present, err := castHistogramCount(colData[columnIndexes[column.Count]], q.Columns[column.Count])
+		if err != nil {
+			return fmt.Errorf("count: %w", err)
+		}
+		if present {
+			if a.hasCount && a.count != count {
+				return fmt.Errorf("inconsistent count %d and %d in one histogram", a.count, count)
+			}
+			a.count, a.hasCount = count, true
+		}
+	}
+	return nil
+}
+
+// observeBucket records the cumulative count of bucket le
+func (a *histogramAccumulator) observeBucket(le float64, cumulative uint64) error {
+	if math.IsInf(le, 1) {
+		if a.hasInf && a.inf != cumulative {
+			return fmt.Errorf("duplicate bucket le=+Inf")
+		}
+		a.inf, a.hasInf = cumulative, true
+		return nil
+	}
+	bucket := a.column.Bucket
+	i := sort.SearchFloat64s(bucket, le)
+	if i == len(bucket) || bucket[i] != le {
+		return fmt.Errorf("le=%v is not a configured bucket", le)
+	}
+	if a.cumulative == nil {
+		a.cumulative = make([]uint64, len(bucket))
+		a.seen = make([]bool, len(bucket))
+	}
+	if a.seen[i] && a.cumulative[i] != cumulative {
+		return fmt.Errorf("duplicate bucket le=%v", le)
+	}
+	a.cumulative[i], a.seen[i] = cumulative, true
+	return nil
+}
+
+// resolveBuckets validates monotonicity of cumulative counts and converts them
+// into per-interval counts, so pre-aggregated histograms share the emit path
+func (a *histogramAccumulator) resolveBuckets() error {
+	var previous uint64
+	for i, upperBound := range a.column.Bucket {
+		current := previous
+		if a.seen != nil && a.seen[i] {
+			current = a.cumulative[i]
+		}
+		if current < previous {
+			return fmt.Errorf("cumulative count of bucket le=%v decreases from %d to %d", upperBound, previous, current)
+		}
+		a.counts[i] = current - previous
+		previous = current
+	}
+
+	total := previous
+	switch {
+	case a.hasCount:
+		total = a.count
+	case a.hasInf:
+		total = a.inf
+	}
+	if a.hasInf && a.inf != total {
+		return fmt.Errorf("cumulative count of bucket le=+Inf %d does not match count %d", a.inf, total)
+	}
+	if total < previous {
+		return fmt.Errorf("count %d is less than cumulative count %d of last bucket", total, previous)
+	}
+	if total > 0 && !a.hasSum {
+		return fmt.Errorf("missing sum of %d observations", total)
+	}
+	a.counts[len(a.column.Bucket)] = total - previous
+	a.count = total
+	return nil
+}
+
+// castHistogramBound converts a bucket upper bound, which may be +Inf
+func castHistogramBound(t interface{}, c *Column) (float64, error) {
+	switch v := t.(type) {
+	case nil:
+		return 0, fmt.Errorf("NULL bucket upper bound")
+	case float64:
+		if math.IsInf(v, 1) {
+			return v, nil
+		}
+	case string, []byte:
+		if f, err := strconv.ParseFloat(castString(v), 64); err == nil && math.IsInf(f, 1) {
+			return f, nil
+		}
+	}
+	value, _, err := castHistogramFloat64(t, c)
+	return value, err
+}
+
+// castHistogramCount converts a cumulative or total count, which must be a non-negative integer
+func castHistogramCount(t interface{}, c *Column) (count uint64, present bool, err error) {
+	value, present, err := castHistogramFloat64(t, c)
+	if err != nil || !present {
+		return 0, present, err
+	}
+	if value < 0 || value != math.Trunc(value) || value >= math.MaxUint64 {
+		return 0, false, fmt.Errorf("count must be a non-negative integer, got %v", value)
+	}
+	return uint64(value), true, nil
+}
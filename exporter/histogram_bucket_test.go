@@ -0,0 +1,140 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const histogramBucketConfig = `
+pg_xact_age:
+  query: SELECT datname, le, cnt, total, n FROM test
+  metrics:
+    - datname: { usage: LABEL }
+    - le:      { usage: DISCARD }
+    - cnt:     { usage: HISTOGRAM_BUCKET, rename: seconds, bucket: [ 1, 10, 100 ], sum: total, count: n, description: xact age }
+    - total:   { usage: DISCARD, scale: 1e-3 }
+    - n:       { usage: DISCARD }
+`
+
+func TestParseConfigHistogramBucket(t *testing.T) {
+	queries, err := ParseConfig([]byte(histogramBucketConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_xact_age"]
+	c := q.Columns["cnt"]
+	if !c.IsHistogram() || !c.IsHistogramBucket() || c.LeColumn() != "le" || !q.HasHistogram() {
+		t.Fatalf("unexpected histogram bucket column: %+v", c)
+	}
+	if got := strings.Join(c.sourceColumns(), ","); got != "le,total,n" {
+		t.Fatalf("bucket sources = %s", got)
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "Source le=le sum=total count=n") {
+		t.Fatalf("explain should render bucket sources:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"no bucket":        `{ usage: HISTOGRAM_BUCKET, sum: s }`,
+		"no sum":           `{ usage: HISTOGRAM_BUCKET, bucket: [ 1 ] }`,
+		"undefined le":     `{ usage: HISTOGRAM_BUCKET, bucket: [ 1 ], sum: s, le: bound }`,
+		"label source":     `{ usage: HISTOGRAM_BUCKET, bucket: [ 1 ], sum: datname }`,
+		"shared source":    `{ usage: HISTOGRAM_BUCKET, bucket: [ 1 ], sum: s, count: s }`,
+		"native bucket":    `{ usage: HISTOGRAM_BUCKET, bucket: [ 1 ], sum: s, native: true }`,
+		"sum on gauge":     `{ usage: GAUGE, sum: s }`,
+		"sum on histogram": `{ usage: HISTOGRAM, bucket: [ 1 ], sum: s }`,
+	}
+	for name, column := range tests {
+		content := "q:\n  query: SELECT 1\n  metrics:\n    - datname: { usage: LABEL }\n    - le: { usage: DISCARD }\n    - s: { usage: DISCARD }\n    - v: " + column + "\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCollectorHistogramBucket(t *testing.T) {
+	queries, err := ParseConfig([]byte(histogramBucketConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"app", 1.0, int64(2), int64(95000), int64(6)},
+		{"app", "100", int64(5), int64(95000), int64(6)},
+		{"app", "Infinity", int64(6), int64(95000), int64(6)},
+		{"meta", []byte("10"), int64(1), int64(4000), nil},
+		{"meta", math.Inf(1), int64(3), int64(4000), nil},
+	}
+	collector := newFakeCollector(t, queries["pg_xact_age"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "le", "cnt", "total", "n"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute histogram bucket query: %v", err)
+	}
+
+	samples, families := gatherSamples(t, collector)
+	if len(families) != 3 || families["pg_xact_age_seconds_bucket"].GetHelp() != "xact age (cumulative bucket)" {
+		t.Fatalf("unexpected families: %v", families)
+	}
+	bucket := func(datname, le string) map[string]string {
+		return map[string]string{"cluster": "c1", "datname": datname, "le": le}
+	}
+	group := func(datname string) map[string]string {
+		return map[string]string{"cluster": "c1", "datname": datname}
+	}
+	// missing le=10 of app inherits cumulative count of le=1
+	requireSample(t, samples, "pg_xact_age_seconds_bucket", bucket("app", "1"), 2)
+	requireSample(t, samples, "pg_xact_age_seconds_bucket", bucket("app", "10"), 2)
+	requireSample(t, samples, "pg_xact_age_seconds_bucket", bucket("app", "100"), 5)
+	requireSample(t, samples, "pg_xact_age_seconds_bucket", bucket("app", "+Inf"), 6)
+	requireSample(t, samples, "pg_xact_age_seconds_count", group("app"), 6)
+	requireSample(t, samples, "pg_xact_age_seconds_sum", group("app"), 95)
+	// without count column, count falls back to +Inf bucket
+	requireSample(t, samples, "pg_xact_age_seconds_bucket", bucket("meta", "1"), 0)
+	requireSample(t, samples, "pg_xact_age_seconds_bucket", bucket("meta", "100"), 1)
+	requireSample(t, samples, "pg_xact_age_seconds_count", group("meta"), 3)
+	requireSample(t, samples, "pg_xact_age_seconds_sum", group("meta"), 4)
+}
+
+func TestCollectorHistogramBucketInvalid(t *testing.T) {
+	queries, err := ParseConfig([]byte(histogramBucketConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][][]driver.Value{
+		"decreasing":       {{"a", 1.0, int64(3), 1.0, nil}, {"a", 10.0, int64(2), 1.0, nil}},
+		"unknown le":       {{"a", 5.0, int64(1), 1.0, nil}},
+		"null le":          {{"a", nil, int64(1), 1.0, nil}},
+		"duplicate le":     {{"a", 1.0, int64(1), 1.0, nil}, {"a", 1.0, int64(2), 1.0, nil}},
+		"fractional count": {{"a", 1.0, 1.5, 1.0, nil}},
+		"inconsistent sum": {{"a", 1.0, int64(1), 1.0, nil}, {"a", 10.0, int64(1), 2.0, nil}},
+		"count below last": {{"a", 100.0, int64(5), 1.0, int64(4)}},
+		"inf mismatch":     {{"a", 1.0, int64(1), 1.0, int64(3)}, {"a", "+Inf", int64(2), 1.0, int64(3)}},
+		"missing sum":      {{"a", 1.0, int64(1), nil, nil}},
+	}
+	for name, values := range tests {
+		collector := newFakeCollector(t, queries["pg_xact_age"], func() driver.Rows {
+			return &fakeRows{columns: []string{"datname", "le", "cnt", "total", "n"}, values: values}
+		}, nil)
+		collector.scrapeBegin = time.Now()
+		collector.execute()
+		if collector.Error() == nil {
+			t.Fatalf("%s: expected execution error", name)
+		}
+		if collector.ResultSize() != 0 {
+			t.Fatalf("%s: failed execution should publish no metrics", name)
+		}
+	}
+
+	collector := newFakeCollector(t, queries["pg_xact_age"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "le", "cnt", "n"}, values: [][]driver.Value{{"a", 1.0, int64(1), nil}}}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err == nil || !strings.Contains(err.Error(), "missing histogram source column pg_xact_age.total") {
+		t.Fatalf("missing sum column should fail execution, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+const infoQueryConfig = `
+pg_meta:
+  desc: server meta info
+  query: SELECT '16.4' AS version, 'pg-test' AS cluster_name
+  info: true
+  metrics:
+    - version:      { usage: LABEL }
+    - cluster_name: { usage: LABEL, rename: cls }
+`
+
+func TestParseConfigInfoQuery(t *testing.T) {
+	queries, err := ParseConfig([]byte(infoQueryConfig))
+	if err != nil {
+		t.Fatalf("info query without value columns should be valid: %v", err)
+	}
+	q := queries["pg_meta"]
+	if !q.Info || len(q.MetricNames) != 0 || q.InfoName() != "pg_meta_info" {
+		t.Fatalf("unexpected info query: info=%v metrics=%v name=%s", q.Info, q.MetricNames, q.InfoName())
+	}
+	metrics := q.MetricList()
+	if len(metrics) != 1 || metrics[0].Name != "pg_meta_info{version,cls}" || metrics[0].Column.Usage != INFO {
+		t.Fatalf("unexpected metric list: %v", metrics)
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "pg_meta_info (INFO)") || !strings.Contains(explain, "Info       true") {
+		t.Fatalf("explain should render info metric:\n%s", explain)
+	}
+	if html := q.HTML(); !strings.Contains(html, "pg_meta_info{version,cls}") || !strings.Contains(html, "INFO") {
+		t.Fatalf("html should render info metric:\n%s", html)
+	}
+
+	tests := map[string]string{
+		"no label": `
+q:
+  query: SELECT 1 AS v
+  info: true
+  metrics:
+    - v: { usage: DISCARD }
+`,
+		"conflict": `
+q:
+  query: SELECT 'a' AS l, 1 AS info
+  info: true
+  metrics:
+    - l:    { usage: LABEL }
+    - info: { usage: GAUGE }
+`,
+		"no value without info": `
+q:
+  query: SELECT 'a' AS l
+  metrics:
+    - l: { usage: LABEL }
+`,
+	}
+	for name, content := range tests {
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCollectorInfoQuery(t *testing.T) {
+	queries, err := ParseConfig([]byte(infoQueryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := func() driver.Rows {
+		return &fakeRows{
+			columns: []string{"version", "cluster_name"},
+			values:  [][]driver.Value{{"16.4", "pg-test"}},
+		}
+	}
+	collector := newFakeCollector(t, queries["pg_meta"], factory, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute info query: %v", err)
+	}
+	samples, families := gatherSamples(t, collector)
+	if len(families) != 1 || families["pg_meta_info"] == nil {
+		t.Fatalf("expected only pg_meta_info family, got %v", families)
+	}
+	if families["pg_meta_info"].GetHelp() != "server meta info" {
+		t.Fatalf("unexpected help: %s", families["pg_meta_info"].GetHelp())
+	}
+	if len(samples) != 1 {
+		t.Fatalf("expected 1 sample, got %v", samples)
+	}
+	requireSample(t, samples, "pg_meta_info", map[string]string{"cluster": "c1", "version": "16.4", "cls": "pg-test"}, 1)
+}
@@ -0,0 +1,184 @@
+package exporter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/* ================ Lookup ================ */
+
+// lookupConfigKey is the reserved top-level config key of lookup definitions
+const lookupConfigKey = "lookups"
+
+const (
+	defaultLookupTTL     = 300.0 // default lookup cache ttl in seconds
+	defaultLookupTimeout = 1.0   // default lookup query timeout in seconds
+)
+
+// Lookup is a keyed lookup query, e.g. oid -> schema.relname, whose result is
+// cached per server with its own TTL. A LABEL column with `lookup: <name>`
+// has its value replaced by the looked up value, so queries returning OIDs need
+// no catalog join on every scrape. Values without a match keep the raw value.
+// The lookup query must return two columns: key and value.
+type Lookup struct {
+	Name    string  `yaml:"-"`                 // lookup name, key of lookups section
+	SQL     string  `yaml:"query"`             // SQL returning key and value columns
+	TTL     float64 `yaml:"ttl,omitempty"`     // cache ttl in seconds, 300 by default
+	Timeout float64 `yaml:"timeout,omitempty"` // query timeout in seconds, 1 by default
+}
+
+// lookupResult is a cached lookup query result of a server
+type lookupResult struct {
+	values map[string]string
+	at     time.Time
+}
+
+// validate checks lookup query and fills defaults
+func (l *Lookup) validate() error {
+	if strings.TrimSpace(l.SQL) == "" {
+		return fmt.Errorf("empty query")
+	}
+	if l.TTL < 0 || l.Timeout < 0 {
+		return fmt.Errorf("ttl and timeout must be non-negative")
+	}
+	if l.TTL == 0 {
+		l.TTL = defaultLookupTTL
+	}
+	if l.Timeout == 0 {
+		l.Timeout = defaultLookupTimeout
+	}
+	return nil
+}
+
+// ParseLookups parses and validates the top-level lookups section of config content
+func ParseLookups(content []byte) (map[string]*Lookup, error) {
+	var config struct {
+		Lookups map[string]*Lookup `yaml:"lookups"`
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("malformed config: %w", err)
+	}
+	for _, name := range sortedKeys(config.Lookups) {
+		lookup := config.Lookups[name]
+		if lookup == nil {
+			return nil, fmt.Errorf("lookup %q is null", name)
+		}
+		lookup.Name = name
+		if err := lookup.validate(); err != nil {
+			return nil, fmt.Errorf("lookup %q: %w", name, err)
+		}
+	}
+	return config.Lookups, nil
+}
+
+// LoadLookups reads lookups of a config file, or of every config file in a
+// dir in alphabetic order, where later definitions override former ones
+func LoadLookups(configPath string) (map[string]*Lookup, error) {
+	lookups := make(map[string]*Lookup)
+	_, _, err := walkConfigFiles(configPath, "lookups", func(_ string, content []byte) error {
+		fileLookups, err := ParseLookups(content)
+		if err != nil {
+			return err
+		}
+		for name, lookup := range fileLookups {
+			lookups[name] = lookup
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lookups, nil
+}
+
+// validateLookupRefs checks every LABEL column lookup refers to a defined lookup
+func validateLookupRefs(queries map[string]*Query, lookups map[string]*Lookup) error {
+	for _, branch := range sortedKeys(queries) {
+		q := queries[branch]
+		for _, name := range q.LabelNames {
+			if ref := q.Columns[name].Lookup; ref != "" && lookups[ref] == nil {
+				return fmt.Errorf("query %q column %q refers to undefined lookup %q", branch, name, ref)
+			}
+		}
+	}
+	return nil
+}
+
+// validateLookup rejects lookup on columns other than LABEL
+func validateLookup(column *Column) error {
+	if column.Lookup != "" && column.Usage != LABEL {
+		return fmt.Errorf("lookup is only supported by LABEL, got %s", column.Usage)
+	}
+	return nil
+}
+
+// HasLookup reports whether any LABEL column of this query uses a lookup
+func (q *Query) HasLookup() bool {
+	for _, name := range q.LabelNames {
+		if q.Columns[name].Lookup != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupValues returns cached values of a lookup, refreshing them once the
+// cache expires. A failed refresh keeps serving the stale cache, and with no
+// cache at all nil is returned, so label values stay raw. It is called under
+// the server lock during collection.
+func (s *Server) lookupValues(name string) map[string]string {
+	lookup := s.lookups[name]
+	if lookup == nil {
+		return nil
+	}
+	cached := s.lookupCache[name]
+	if cached != nil && time.Since(cached.at) < time.Duration(lookup.TTL*float64(time.Second)) {
+		return cached.values
+	}
+	values, err := s.queryLookup(lookup)
+	if err != nil {
+		logWarnf("lookup [%s] @ server [%s] refresh failed: %s", name, s.Name(), err.Error())
+		if cached != nil {
+			return cached.values
+		}
+		return nil
+	}
+	if s.lookupCache == nil {
+		s.lookupCache = make(map[string]*lookupResult)
+	}
+	s.lookupCache[name] = &lookupResult{values: values, at: time.Now()}
+	logDebugf("lookup [%s] @ server [%s] refreshed with %d keys", name, s.Name(), len(values))
+	return values
+}
+
+// queryLookup runs a lookup query and reads its key value pairs
+func (s *Server) queryLookup(lookup *Lookup) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(lookup.Timeout*float64(time.Second)))
+	defer cancel()
+	rows, err := s.QueryContext(ctx, lookup.SQL)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) { _ = rows.Close() }(rows)
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) != 2 {
+		return nil, fmt.Errorf("lookup query returned %d columns, expect key and value", len(columns))
+	}
+	values := make(map[string]string)
+	var key, value interface{}
+	for rows.Next() {
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		values[castString(key)] = castString(value)
+	}
+	return values, rows.Err()
+}
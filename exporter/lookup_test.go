@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+const lookupConfig = `
+lookups:
+  relname:
+    query: SELECT c.oid, n.nspname || '.' || c.relname FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+    ttl: 60
+pg_table:
+  query: SELECT relid, seq_scan FROM pg_stat_user_tables
+  metrics:
+    - relid:    { usage: LABEL, lookup: relname, rename: relname }
+    - seq_scan: { usage: GAUGE }
+`
+
+func TestParseLookups(t *testing.T) {
+	lookups, err := ParseLookups([]byte(strings.Replace(lookupConfig, "pg_table:", "  other: { query: SELECT 1, 2 }\npg_table:", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := lookups["relname"]; l == nil || l.Name != "relname" || l.TTL != 60 || l.Timeout != defaultLookupTimeout {
+		t.Fatalf("lookup should be parsed with defaults: %+v", l)
+	}
+	if lookups["other"].TTL != defaultLookupTTL {
+		t.Fatalf("lookup ttl should default to %v", defaultLookupTTL)
+	}
+	queries, err := ParseConfig([]byte(lookupConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := queries[lookupConfigKey]; found || len(queries) != 1 {
+		t.Fatalf("lookups section should not be parsed as a query: %v", queries)
+	}
+	if explain := queries["pg_table"].Explain(); !strings.Contains(explain, "Lookup relname") {
+		t.Fatalf("explain should render lookup:\n%s", explain)
+	}
+	if err := validateLookupRefs(queries, lookups); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateLookupRefs(queries, nil); err == nil || !strings.Contains(err.Error(), `undefined lookup "relname"`) {
+		t.Fatalf("undefined lookup should be rejected, got %v", err)
+	}
+
+	for name, lookups := range map[string]string{
+		"null lookup":  `{ a: ~ }`,
+		"empty query":  `{ a: { query: " " } }`,
+		"negative ttl": `{ a: { query: SELECT 1, ttl: -1 } }`,
+	} {
+		if _, err := ParseLookups([]byte("lookups: " + lookups + "\n")); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+	if _, err := ParseConfig([]byte("q:\n  query: SELECT 1\n  metrics:\n    - v: { usage: GAUGE, lookup: a }\n")); err == nil {
+		t.Fatal("lookup on GAUGE should be rejected")
+	}
+}
+
+func TestCollectorLookup(t *testing.T) {
+	queries, err := ParseConfig([]byte(lookupConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups, err := ParseLookups([]byte(lookupConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookupCount := 0
+	columns := []string{"oid", "relname"}
+	relnames := [][]driver.Value{{int64(16384), "public.events"}, {int64(16390), "app.logs"}}
+	connector := fakeConnector{respond: func(query string, _ []driver.NamedValue) (driver.Rows, error) {
+		if query == lookups["relname"].SQL {
+			lookupCount++
+			return &fakeRows{columns: columns, values: relnames}, nil
+		}
+		return &fakeRows{columns: []string{"relid", "seq_scan"}, values: [][]driver.Value{
+			{int64(16384), int64(3)}, {int64(16400), int64(5)},
+		}}, nil
+	}}
+	server := newFakeServer(t, connector)
+	WithLookups(lookups)(server)
+	collector := NewCollector(queries["pg_table"], server)
+
+	for i := 0; i < 2; i++ {
+		collector.scrapeBegin = time.Now()
+		collector.execute()
+		if err := collector.Error(); err != nil {
+			t.Fatalf("execute lookup query: %v", err)
+		}
+	}
+	if lookupCount != 1 {
+		t.Fatalf("lookup should be cached within ttl, queried %d times", lookupCount)
+	}
+	samples, _ := gatherSamples(t, collector)
+	requireSample(t, samples, "pg_table_seq_scan", map[string]string{"cluster": "c1", "relname": "public.events"}, 3)
+	requireSample(t, samples, "pg_table_seq_scan", map[string]string{"cluster": "c1", "relname": "16400"}, 5) // no match keeps raw value
+
+	// an expired cache is refreshed, and a failed refresh keeps the stale cache
+	server.lookupCache["relname"].at = time.Now().Add(-time.Hour)
+	columns, relnames = []string{"oid", "relname", "extra"}, nil
+	if values := server.lookupValues("relname"); lookupCount != 2 || values["16384"] != "public.events" {
+		t.Fatalf("stale lookup should be served after failed refresh, got %v after %d queries", values, lookupCount)
+	}
+}
@@ -119,6 +119,26 @@ func DryRun() {
 
 }
 
+// Simulate will plan all queries against synthetic server facts and print the result
+func Simulate() {
+	configs, err := LoadConfig(*configPath)
+	if err != nil {
+		logErrorf("fail loading config %s, %v", *configPath, err)
+		os.Exit(1)
+	}
+	for _, spec := range *simulate {
+		s, err := NewSimulatedServer(spec, configs)
+		if err != nil {
+			logErrorf("invalid simulation spec %q: %v", spec, err)
+			os.Exit(1)
+		}
+		s.Plan()
+		fmt.Printf("##\n# SIMULATE %s\n", spec)
+		fmt.Println(s.ExplainPlan())
+	}
+	os.Exit(0)
+}
+
 // Reload will launch a new pg exporter instance
 func Reload() error {
 	ReloadLock.Lock()
@@ -132,6 +152,17 @@ func Reload() error {
 	if err != nil {
 		return fmt.Errorf("fail loading config %s: %w", *configPath, err)
 	}
+	relabel, err := LoadRelabel(*configPath)
+	if err != nil {
+		return fmt.Errorf("fail loading relabel rules %s: %w", *configPath, err)
+	}
+	lookups, err := LoadLookups(*configPath)
+	if err != nil {
+		return fmt.Errorf("fail loading lookups %s: %w", *configPath, err)
+	}
+	if err := validateLookupRefs(queries, lookups); err != nil {
+		return fmt.Errorf("invalid lookups: %w", err)
+	}
 
 	target := PgExporter
 	if target == nil {
@@ -147,6 +178,8 @@ func Reload() error {
 	defer target.lock.Unlock()
 
 	target.queries = queries
+	target.relabel = relabel
+	target.lookups = lookups
 
 	// Update queries for primary + discovered servers, and force re-plan on next scrape.
 	servers := target.IterateServer()
@@ -159,6 +192,9 @@ func Reload() error {
 		}
 		s.lock.Lock()
 		s.queries = queries
+		s.relabel = relabel
+		s.lookups, s.lookupCache = lookups, nil
+		s.retired = append(s.retired, s.Collectors...)
 		s.Collectors = nil
 		s.Planned = false
 		s.ResetStats()
@@ -180,6 +216,11 @@ func Run() {
 		DryRun()
 	}
 
+	// explain planning against synthetic server facts only
+	if len(*simulate) > 0 {
+		Simulate()
+	}
+
 	if *configPath == "" {
 		Logger.Error("no valid config path, exit")
 		os.Exit(1)
@@ -210,6 +251,9 @@ func Run() {
 		WithIncludeDatabase(*includeDatabase),
 		WithTags(*serverTags),
 		WithConnectTimeout(*connectTimeout),
+		WithPrecheckSettings(*precheckSettings),
+		WithPlanCheck(*planCheck),
+		WithCacheDir(*cacheDir),
 	)
 	if err != nil {
 		logErrorf("fail creating pg_exporter: %s", err.Error())
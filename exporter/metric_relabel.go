@@ -0,0 +1,370 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+/* ================ Metric Relabel ================ */
+
+// relabelConfigKey is the reserved top-level config key of metric relabel rules
+const relabelConfigKey = "relabel"
+
+// Metric relabel actions, the same as Prometheus metric_relabel_configs
+const (
+	RelabelReplace   = "replace"   // set target_label to expanded replacement if regex matches
+	RelabelKeep      = "keep"      // drop series whose source_labels do not match regex
+	RelabelDrop      = "drop"      // drop series whose source_labels match regex
+	RelabelLabelMap  = "labelmap"  // copy labels whose name match regex to names given by replacement
+	RelabelLabelDrop = "labeldrop" // remove labels whose name match regex
+	RelabelLabelKeep = "labelkeep" // remove labels whose name do not match regex
+)
+
+// RelabelRule is one exporter-wide metric relabel rule. Rules are applied in
+// order to every series before it is built, with the metric name available
+// as `__name__` and constant labels included, so a series could be dropped,
+// renamed or relabeled at the source instead of by Prometheus after ingestion.
+// Empty labels and temporary labels prefixed with `__` are removed afterwards.
+type RelabelRule struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"` // labels whose values are joined and matched
+	Separator    string   `yaml:"separator,omitempty"`     // separator of joined values, `;` by default
+	Regex        string   `yaml:"regex,omitempty"`         // anchored regexp, `(.*)` by default
+	TargetLabel  string   `yaml:"target_label,omitempty"`  // label written by replace
+	Replacement  string   `yaml:"replacement,omitempty"`   // replace/labelmap template, `$1` by default
+	Action       string   `yaml:"action,omitempty"`        // replace by default
+
+	regex *regexp.Regexp
+}
+
+// RelabelRules is an ordered list of metric relabel rules
+type RelabelRules []*RelabelRule
+
+// UnmarshalYAML fills Prometheus defaults before decoding a rule
+func (r *RelabelRule) UnmarshalYAML(value *yaml.Node) error {
+	type plain RelabelRule
+	*r = RelabelRule{Separator: ";", Regex: "(.*)", Replacement: "$1", Action: RelabelReplace}
+	return value.Decode((*plain)(r))
+}
+
+// validate checks action and target of a rule and compiles its regexp
+func (r *RelabelRule) validate() error {
+	r.Action = strings.ToLower(r.Action)
+	switch r.Action {
+	case RelabelReplace:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel action replace requires target_label")
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return fmt.Errorf("unsupported relabel action %q", r.Action)
+	}
+	regex, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid relabel regex %q: %w", r.Regex, err)
+	}
+	r.regex = regex
+	return nil
+}
+
+// process applies rules to a label set in place, false means the series is dropped
+func (rules RelabelRules) process(labels map[string]string) bool {
+	for _, r := range rules {
+		switch r.Action {
+		case RelabelReplace, RelabelKeep, RelabelDrop:
+			values := make([]string, len(r.SourceLabels))
+			for i, name := range r.SourceLabels {
+				values[i] = labels[name]
+			}
+			value := strings.Join(values, r.Separator)
+			match := r.regex.FindStringSubmatchIndex(value)
+			switch {
+			case r.Action == RelabelKeep && match == nil, r.Action == RelabelDrop && match != nil:
+				return false
+			case r.Action == RelabelReplace && match != nil:
+				target := string(r.regex.ExpandString(nil, r.TargetLabel, value, match))
+				if !model.LegacyValidation.IsValidLabelName(target) {
+					continue
+				}
+				if result := string(r.regex.ExpandString(nil, r.Replacement, value, match)); result != "" {
+					labels[target] = result
+				} else {
+					delete(labels, target)
+				}
+			}
+		case RelabelLabelMap:
+			mapped := make(map[string]string)
+			for name, value := range labels {
+				if r.regex.MatchString(name) {
+					if target := r.regex.ReplaceAllString(name, r.Replacement); model.LegacyValidation.IsValidLabelName(target) {
+						mapped[target] = value
+					}
+				}
+			}
+			for name, value := range mapped {
+				labels[name] = value
+			}
+		case RelabelLabelDrop, RelabelLabelKeep:
+			for name := range labels {
+				if name != model.MetricNameLabel && r.regex.MatchString(name) == (r.Action == RelabelLabelDrop) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+	return true
+}
+
+// ParseRelabel parses and validates the top-level relabel section of config content
+func ParseRelabel(content []byte) (RelabelRules, error) {
+	var config struct {
+		Relabel RelabelRules `yaml:"relabel"`
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("malformed config: %w", err)
+	}
+	for i, rule := range config.Relabel {
+		if rule == nil {
+			return nil, fmt.Errorf("relabel[%d] is null", i)
+		}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("relabel[%d]: %w", i, err)
+		}
+	}
+	return config.Relabel, nil
+}
+
+// LoadRelabel reads relabel rules of a config file, or of every config file in
+// a dir in alphabetic order, where rules of later files are appended to former ones
+func LoadRelabel(configPath string) (RelabelRules, error) {
+	var rules RelabelRules
+	_, _, err := walkConfigFiles(configPath, "relabel rules", func(_ string, content []byte) error {
+		fileRules, err := ParseRelabel(content)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, fileRules...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// descMeta holds the parts of a descriptor that relabel rules read
+type descMeta struct {
+	name        string
+	help        string
+	labelNames  []string
+	constLabels prometheus.Labels
+}
+
+// relabelDescs records descriptors created by a Collector or the Exporter,
+// and caches descriptors of relabeled series. It is used under the lock of
+// its owner, so it needs no lock of its own.
+type relabelDescs struct {
+	meta     map[*prometheus.Desc]*descMeta
+	descs    map[string]*prometheus.Desc
+	families *relabelFamilies // metric families of the server, nil if not planned with relabel rules
+}
+
+// relabelFamily is a metric family that series of a server are built into
+type relabelFamily struct {
+	help       string
+	kind       dto.MetricType
+	labelNames []string // label names of series after relabeling, nil until a series is built
+}
+
+// relabelFamilies records metric families of a server. A series renamed by
+// relabel rules onto an existing family takes the help of that family, and is
+// dropped if its type or label names differ, as such an inconsistent family
+// would fail the whole scrape. Families of installed collectors are recorded
+// when planning, other families are defined by the first series renamed onto them.
+type relabelFamilies struct {
+	lock     sync.Mutex // shared by all collectors of a server
+	families map[string]*relabelFamily
+	warned   map[string]bool // source and target names of conflicts already logged
+}
+
+// newRelabelFamilies records families of descriptors of collectors and links
+// collectors to them, earlier collectors win if two define a family
+func newRelabelFamilies(collectors []*Collector) *relabelFamilies {
+	f := &relabelFamilies{families: make(map[string]*relabelFamily), warned: make(map[string]bool)}
+	for _, q := range collectors {
+		for desc, kind := range q.familyKinds() {
+			meta := q.relabelDescs.meta[desc]
+			if meta != nil && f.families[meta.name] == nil {
+				f.families[meta.name] = &relabelFamily{help: meta.help, kind: kind}
+			}
+		}
+		q.relabelDescs.families = f
+	}
+	return f
+}
+
+// admit checks a series of family source relabeled into family name, and
+// returns the help to build it with, false if it conflicts with the family
+func (f *relabelFamilies) admit(source, name, help string, kind dto.MetricType, labelNames []string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	family := f.families[name]
+	if family == nil {
+		f.families[name] = &relabelFamily{help: help, kind: kind, labelNames: labelNames}
+		return help, true
+	}
+	if source != name && (family.kind != kind || family.labelNames != nil && !slices.Equal(family.labelNames, labelNames)) {
+		if key := source + "\xff" + name; !f.warned[key] {
+			f.warned[key] = true
+			logWarnf("drop series of %s relabeled into %s: %s series with labels %v conflict with %s series with labels %v",
+				source, name, kind, labelNames, family.kind, family.labelNames)
+		}
+		return "", false
+	}
+	if family.labelNames == nil || source == name { // series of its own define label names of a family
+		family.labelNames = labelNames
+	}
+	return family.help, true
+}
+
+func newRelabelDescs() *relabelDescs {
+	return &relabelDescs{
+		meta:  make(map[*prometheus.Desc]*descMeta),
+		descs: make(map[string]*prometheus.Desc),
+	}
+}
+
+// newDesc creates a descriptor and records it for relabeling
+func (d *relabelDescs) newDesc(name, help string, labelNames []string, constLabels prometheus.Labels) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, labelNames, constLabels)
+	d.register(desc, name, help, labelNames, constLabels)
+	return desc
+}
+
+// register records a descriptor created elsewhere, e.g. by prometheus.NewGauge
+func (d *relabelDescs) register(desc *prometheus.Desc, name, help string, labelNames []string, constLabels prometheus.Labels) {
+	d.meta[desc] = &descMeta{name: name, help: help, labelNames: labelNames, constLabels: constLabels}
+}
+
+// forget removes a descriptor that is no longer used
+func (d *relabelDescs) forget(desc *prometheus.Desc) {
+	delete(d.meta, desc)
+}
+
+// apply relabels one series of desc and metric type kind with label values.
+// It returns the descriptor and label values to build the series with, or a
+// nil descriptor if the series is dropped. Without rules the series is
+// returned as is.
+func (d *relabelDescs) apply(rules RelabelRules, desc *prometheus.Desc, kind dto.MetricType, values []string) (*prometheus.Desc, []string) {
+	if len(rules) == 0 {
+		return desc, values
+	}
+	meta := d.meta[desc]
+	if meta == nil {
+		return desc, values
+	}
+	labels := make(map[string]string, len(meta.constLabels)+len(values)+1)
+	for name, value := range meta.constLabels {
+		labels[name] = value
+	}
+	for i, name := range meta.labelNames {
+		labels[name] = values[i]
+	}
+	labels[model.MetricNameLabel] = meta.name
+	if !rules.process(labels) {
+		return nil, nil
+	}
+
+	name := labels[model.MetricNameLabel]
+	if err := validatePromMetricName(name); err != nil {
+		logDebugf("drop series of %s relabeled to %q: %v", meta.name, name, err)
+		return nil, nil
+	}
+	labelNames := make([]string, 0, len(labels))
+	for labelName, value := range labels {
+		if value != "" && !strings.HasPrefix(labelName, model.ReservedLabelPrefix) {
+			labelNames = append(labelNames, labelName)
+		}
+	}
+	sort.Strings(labelNames)
+	help := meta.help
+	if d.families != nil {
+		var admitted bool
+		if help, admitted = d.families.admit(meta.name, name, help, kind, labelNames); !admitted {
+			return nil, nil
+		}
+	}
+	key := name + "\xff" + help + "\xff" + strings.Join(labelNames, "\xff")
+	relabeled := d.descs[key]
+	if relabeled == nil {
+		relabeled = prometheus.NewDesc(name, help, labelNames, nil)
+		d.descs[key] = relabeled
+	}
+	labelValues := make([]string, len(labelNames))
+	for i, labelName := range labelNames {
+		labelValues[i] = labels[labelName]
+	}
+	return relabeled, labelValues
+}
+
+// relabelSeries applies exporter-wide relabel rules of the server to one
+// series of metric type kind, a nil descriptor is returned if the series is dropped
+func (q *Collector) relabelSeries(desc *prometheus.Desc, kind dto.MetricType, labels []string) (*prometheus.Desc, []string) {
+	if q.Server == nil {
+		return desc, labels
+	}
+	return q.relabelDescs.apply(q.Server.relabel, desc, kind, labels)
+}
+
+// familyKinds returns the metric type of series built with each descriptor of this collector
+func (q *Collector) familyKinds() map[*prometheus.Desc]dto.MetricType {
+	kinds := make(map[*prometheus.Desc]dto.MetricType, len(q.descriptors)+len(q.histogramDesc)+1)
+	for metricName, desc := range q.descriptors {
+		if column := q.Columns[metricName]; column.IsSummary() {
+			kinds[desc] = dto.MetricType_SUMMARY
+		} else {
+			kinds[desc] = valueTypeKind(column.PrometheusValueType())
+		}
+	}
+	for _, descriptors := range q.histogramDesc {
+		if descriptors.native != nil {
+			kinds[descriptors.native] = dto.MetricType_HISTOGRAM
+			continue
+		}
+		kinds[descriptors.bucket] = dto.MetricType_GAUGE
+		kinds[descriptors.count] = dto.MetricType_GAUGE
+		kinds[descriptors.sum] = dto.MetricType_GAUGE
+	}
+	if q.infoDesc != nil {
+		kinds[q.infoDesc] = dto.MetricType_GAUGE
+	}
+	return kinds
+}
+
+// valueTypeKind returns the metric type of const metrics of a value type
+func valueTypeKind(valueType prometheus.ValueType) dto.MetricType {
+	switch valueType {
+	case prometheus.CounterValue:
+		return dto.MetricType_COUNTER
+	case prometheus.GaugeValue:
+		return dto.MetricType_GAUGE
+	default:
+		return dto.MetricType_UNTYPED
+	}
+}
+
+// newConstMetric builds a relabeled const metric, nil if the series is dropped
+func (q *Collector) newConstMetric(desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labels ...string) (prometheus.Metric, error) {
+	if desc, labels = q.relabelSeries(desc, valueTypeKind(valueType), labels); desc == nil {
+		return nil, nil
+	}
+	return prometheus.NewConstMetric(desc, valueType, value, labels...)
+}
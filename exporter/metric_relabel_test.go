@@ -0,0 +1,320 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const metricRelabelConfig = `
+relabel:
+  - source_labels: [ __name__ ]
+    regex: pg_db_temp_.*
+    action: drop
+  - source_labels: [ datname ]
+    regex: template.*
+    action: drop
+  - source_labels: [ datname ]
+    target_label: db
+  - regex: datname
+    action: labeldrop
+  - target_label: env
+    replacement: prod
+  - source_labels: [ __name__ ]
+    regex: pg_db_(.*)_total
+    target_label: __name__
+    replacement: pg_database_${1}
+pg_db:
+  query: SELECT datname, xact_total, temp_bytes FROM test
+  metrics:
+    - datname:    { usage: LABEL }
+    - xact_total: { usage: COUNTER }
+    - temp_bytes: { usage: GAUGE }
+`
+
+func TestParseRelabel(t *testing.T) {
+	queries, err := ParseConfig([]byte(metricRelabelConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := queries[relabelConfigKey]; found || len(queries) != 1 {
+		t.Fatalf("relabel section should not be parsed as a query: %v", queries)
+	}
+	for _, key := range []string{relabelConfigKey, lookupConfigKey} {
+		config := key + ":\n  query: SELECT 1 AS v\n  metrics:\n    - v: { usage: GAUGE }\n"
+		if _, err := ParseConfig([]byte(config)); err == nil || !strings.Contains(err.Error(), "reserved config key") {
+			t.Fatalf("query named %s should be rejected, got %v", key, err)
+		}
+	}
+	rules, err := ParseRelabel([]byte(metricRelabelConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 6 || rules[2].Action != RelabelReplace || rules[2].Replacement != "$1" || rules[2].Separator != ";" || rules[4].Regex != "(.*)" {
+		t.Fatalf("relabel rules should be filled with defaults: %+v", rules[2])
+	}
+
+	tests := map[string]string{
+		"unknown action":   `[ { action: hashmod } ]`,
+		"replace target":   `[ { source_labels: [ a ] } ]`,
+		"bad regex":        `[ { regex: "(", action: drop } ]`,
+		"null rule":        `[ ~ ]`,
+		"malformed config": `{ a: b }`,
+	}
+	for name, relabel := range tests {
+		if _, err := ParseRelabel([]byte("relabel: " + relabel + "\n")); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestLoadRelabelDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"1-drop.yml":  "relabel: [ { regex: a, action: labeldrop } ]\n",
+		"2-keep.yaml": "relabel: [ { regex: b, action: labelkeep } ]\n",
+		"3-bad.yml":   "relabel: [ { action: bad } ]\n",
+		"notes.txt":   "relabel: [ { action: bad } ]\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules, err := LoadRelabel(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Action != RelabelLabelDrop || rules[1].Action != RelabelLabelKeep {
+		t.Fatalf("rules should be appended in file order skipping broken files: %+v", rules)
+	}
+}
+
+func TestRelabelRulesProcess(t *testing.T) {
+	parse := func(relabel string) RelabelRules {
+		t.Helper()
+		rules, err := ParseRelabel([]byte("relabel: " + relabel + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rules
+	}
+	tests := []struct {
+		name  string
+		rules string
+		input map[string]string
+		want  map[string]string
+		kept  bool
+	}{
+		{"keep match", `[ { source_labels: [ a, b ], regex: "x;y", action: keep } ]`,
+			map[string]string{"a": "x", "b": "y"}, map[string]string{"a": "x", "b": "y"}, true},
+		{"keep miss", `[ { source_labels: [ a ], regex: "x", action: keep } ]`,
+			map[string]string{"a": "xx"}, nil, false},
+		{"drop match", `[ { source_labels: [ a ], regex: "x.*", action: drop } ]`,
+			map[string]string{"a": "xx"}, nil, false},
+		{"replace", `[ { source_labels: [ a ], regex: "(.)(.)", target_label: "c_$2", replacement: "$1-$2" } ]`,
+			map[string]string{"a": "xy"}, map[string]string{"a": "xy", "c_y": "x-y"}, true},
+		{"replace empty", `[ { source_labels: [ b ], target_label: a } ]`,
+			map[string]string{"a": "x"}, map[string]string{}, true},
+		{"labelmap", `[ { regex: "tmp_(.*)", action: labelmap } ]`,
+			map[string]string{"tmp_a": "x"}, map[string]string{"tmp_a": "x", "a": "x"}, true},
+		{"labelkeep", `[ { regex: "a|__name__", action: labelkeep } ]`,
+			map[string]string{"a": "x", "b": "y", "__name__": "m"}, map[string]string{"a": "x", "__name__": "m"}, true},
+	}
+	for _, tt := range tests {
+		labels := make(map[string]string, len(tt.input))
+		for k, v := range tt.input {
+			labels[k] = v
+		}
+		if got := parse(tt.rules).process(labels); got != tt.kept {
+			t.Fatalf("%s: process = %v, want %v", tt.name, got, tt.kept)
+		}
+		if !tt.kept {
+			continue
+		}
+		if len(labels) != len(tt.want) {
+			t.Fatalf("%s: labels = %v, want %v", tt.name, labels, tt.want)
+		}
+		for k, v := range tt.want {
+			if labels[k] != v {
+				t.Fatalf("%s: labels = %v, want %v", tt.name, labels, tt.want)
+			}
+		}
+	}
+}
+
+func TestCollectorMetricRelabel(t *testing.T) {
+	queries, err := ParseConfig([]byte(metricRelabelConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseRelabel([]byte(metricRelabelConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"postgres", int64(10), int64(1)},
+		{"template1", int64(20), int64(2)},
+	}
+	collector := newFakeCollector(t, queries["pg_db"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "xact_total", "temp_bytes"}, values: values}
+	}, nil)
+	collector.Server.relabel = rules
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute relabeled query: %v", err)
+	}
+	if got := collector.ResultSize(); got != 1 {
+		t.Fatalf("result size = %d, want 1", got)
+	}
+
+	registry := prometheus.NewRegistry()
+	collector.TTL = 3600
+	collector.Server.scrapeBegin = time.Now()
+	collector.lastScrape = collector.Server.scrapeBegin
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || families[0].GetName() != "pg_database_xact" || families[0].GetType() != dto.MetricType_COUNTER {
+		t.Fatalf("unexpected relabeled families: %v", families)
+	}
+	metric := families[0].GetMetric()[0]
+	want := map[string]string{"cluster": "c1", "db": "postgres", "env": "prod"}
+	if len(metric.GetLabel()) != len(want) || labelValue(metric, "datname") != "" {
+		t.Fatalf("unexpected relabeled labels: %v", metric.GetLabel())
+	}
+	for name, value := range want {
+		if labelValue(metric, name) != value {
+			t.Fatalf("label %s = %q, want %q", name, labelValue(metric, name), value)
+		}
+	}
+	if metric.GetCounter().GetValue() != 10 {
+		t.Fatalf("relabeled value = %v, want 10", metric.GetCounter().GetValue())
+	}
+}
+
+func TestExporterInternalMetricRelabel(t *testing.T) {
+	rules, err := ParseRelabel([]byte(`
+relabel:
+  - source_labels: [ __name__ ]
+    regex: pg_exporter_.*
+    action: drop
+  - target_label: job
+    replacement: pg
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Exporter{constLabels: prometheus.Labels{"cls": "c1"}, relabel: rules}
+	e.setupInternalMetrics()
+	e.up.Set(1)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(internalMetricsCollector{e})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		names[family.GetName()] = family
+	}
+	if len(names) != 3 || names["pg_up"] == nil || names["pg_version"] == nil || names["pg_in_recovery"] == nil {
+		t.Fatalf("exporter metrics should be dropped, got %v", names)
+	}
+	up := names["pg_up"].GetMetric()[0]
+	if up.GetGauge().GetValue() != 1 || labelValue(up, "job") != "pg" || labelValue(up, "cls") != "c1" {
+		t.Fatalf("unexpected relabeled pg_up: %v", up)
+	}
+}
+
+// internalMetricsCollector exposes internal metrics of an exporter as an unchecked collector
+type internalMetricsCollector struct{ e *Exporter }
+
+func (c internalMetricsCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c internalMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.e.collectInternalMetrics(ch)
+}
+
+func TestRelabelRenameOntoExistingFamily(t *testing.T) {
+	config := `
+relabel:
+  - source_labels: [ __name__ ]
+    regex: pg_(legacy|extra)_(bytes|xacts)
+    target_label: __name__
+    replacement: pg_size_bytes
+pg_size:
+  query: SELECT datname, bytes FROM size
+  priority: 1
+  metrics:
+    - datname: { usage: LABEL }
+    - bytes:   { usage: GAUGE, description: database size }
+pg_legacy:
+  query: SELECT datname, bytes, xacts FROM legacy
+  priority: 2
+  metrics:
+    - datname: { usage: LABEL }
+    - bytes:   { usage: GAUGE, description: legacy database size }
+    - xacts:   { usage: COUNTER }
+pg_extra:
+  query: SELECT datname, nspname, bytes FROM extra
+  priority: 3
+  metrics:
+    - datname: { usage: LABEL }
+    - nspname: { usage: LABEL }
+    - bytes:   { usage: GAUGE }
+`
+	queries, err := ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseRelabel([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newFakeServer(t, fakeConnector{respond: func(query string, _ []driver.NamedValue) (driver.Rows, error) {
+		switch {
+		case strings.Contains(query, "legacy"):
+			return &fakeRows{columns: []string{"datname", "bytes", "xacts"}, values: [][]driver.Value{{"old", int64(2), int64(7)}}}, nil
+		case strings.Contains(query, "extra"):
+			return &fakeRows{columns: []string{"datname", "nspname", "bytes"}, values: [][]driver.Value{{"app", "public", int64(3)}}}, nil
+		}
+		return &fakeRows{columns: []string{"datname", "bytes"}, values: [][]driver.Value{{"postgres", int64(1)}}}, nil
+	}})
+	s.relabel, s.queries = rules, queries
+	s.beforeScrape = func(*Server) error { return nil }
+	s.Plan()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("series renamed onto an existing family should not fail the scrape: %v", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "pg_size_bytes" {
+		t.Fatalf("unexpected families: %v", families)
+	}
+	family := families[0]
+	if family.GetHelp() != "database size" || family.GetType() != dto.MetricType_GAUGE {
+		t.Fatalf("renamed series should take help and type of the family, got %q %s", family.GetHelp(), family.GetType())
+	}
+	got := make(map[string]float64)
+	for _, metric := range family.GetMetric() {
+		got[labelValue(metric, "datname")] = metric.GetGauge().GetValue()
+	}
+	if len(got) != 2 || got["postgres"] != 1 || got["old"] != 2 { // counter and extra labels conflict
+		t.Fatalf("conflicting series should be dropped, got %v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+/* ================ Native Histogram ================ */
+
+// A native HISTOGRAM column places each observation into exponential buckets:
+// bucket i of schema s covers (2^((i-1)/2^s), 2^(i/2^s)], negative values use
+// the mirrored negative buckets, and values within the zero threshold are
+// counted in the zero bucket. The resulting family is exposed as a Prometheus
+// histogram with both native buckets (protobuf) and classic buckets (text).
+
+const (
+	defaultNativeHistogramSchema = 3 // growth factor 2^(1/8) ≈ 1.09 per bucket
+	nativeHistogramSchemaMin     = -4
+	nativeHistogramSchemaMax     = 8
+	nativeHistogramZeroThreshold = prometheus.DefNativeHistogramZeroThreshold
+)
+
+// nativeBucketKey returns the index of the native bucket holding |value| > 0
+func nativeBucketKey(value float64, schema int32) int {
+	factor := math.Ldexp(1, int(schema))
+	key := int(math.Ceil(math.Log2(value) * factor))
+	// correct floating point error on bucket boundaries
+	if value > nativeBucketBound(key, schema) {
+		key++
+	} else if value <= nativeBucketBound(key-1, schema) {
+		key--
+	}
+	return key
+}
+
+// nativeBucketBound returns the inclusive upper bound of positive bucket key
+func nativeBucketBound(key int, schema int32) float64 {
+	return math.Exp2(float64(key) / math.Ldexp(1, int(schema)))
+}
+
+// observeNative adds an observation to native buckets of the accumulator
+func (a *histogramAccumulator) observeNative(value float64) {
+	switch {
+	case math.Abs(value) <= nativeHistogramZeroThreshold:
+		a.zero++
+	case value > 0:
+		if a.positive == nil {
+			a.positive = make(map[int]int64)
+		}
+		a.positive[nativeBucketKey(value, a.column.schema)]++
+	default:
+		if a.negative == nil {
+			a.negative = make(map[int]int64)
+		}
+		a.negative[nativeBucketKey(-value, a.column.schema)]++
+	}
+}
+
+// classicBuckets returns cumulative classic buckets for text exposition.
+// Configured buckets are used when present, otherwise the bounds of populated
+// native buckets are used, which are stable across snapshots for a schema.
+// The +Inf bucket is implied by the sample count.
+func (a *histogramAccumulator) classicBuckets() []*dto.Bucket {
+	var cumulative uint64
+	if len(a.column.Bucket) > 0 {
+		buckets := make([]*dto.Bucket, 0, len(a.column.Bucket))
+		for i, upperBound := range a.column.Bucket {
+			cumulative += a.counts[i]
+			buckets = append(buckets, &dto.Bucket{CumulativeCount: proto.Uint64(cumulative), UpperBound: proto.Float64(upperBound)})
+		}
+		return buckets
+	}
+
+	buckets := make([]*dto.Bucket, 0, len(a.negative)+len(a.positive)+1)
+	add := func(count uint64, upperBound float64) {
+		cumulative += count
+		buckets = append(buckets, &dto.Bucket{CumulativeCount: proto.Uint64(cumulative), UpperBound: proto.Float64(upperBound)})
+	}
+	negatives := slices.Sorted(maps.Keys(a.negative))
+	for i := len(negatives) - 1; i >= 0; i-- { // larger key is further from zero
+		add(uint64(a.negative[negatives[i]]), -nativeBucketBound(negatives[i]-1, a.column.schema))
+	}
+	if a.zero > 0 {
+		add(a.zero, nativeHistogramZeroThreshold)
+	}
+	for _, key := range slices.Sorted(maps.Keys(a.positive)) {
+		add(uint64(a.positive[key]), nativeBucketBound(key, a.column.schema))
+	}
+	return buckets
+}
+
+// nativeHistogramMetric builds a native histogram with classic buckets attached,
+// nil if dropped by relabel rules
+func (q *Collector) nativeHistogramMetric(desc *prometheus.Desc, a *histogramAccumulator) (prometheus.Metric, error) {
+	desc, labels := q.relabelSeries(desc, dto.MetricType_HISTOGRAM, a.labels)
+	if desc == nil {
+		return nil, nil
+	}
+	metric, err := prometheus.NewConstNativeHistogram(
+		desc, a.count, a.sum, a.positive, a.negative, a.zero,
+		a.column.schema, nativeHistogramZeroThreshold, q.scrapeBegin, labels...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// classic buckets are attached to an own copy of the written dto, which
+	// may share state with the const histogram
+	var written dto.Metric
+	if err = metric.Write(&written); err != nil {
+		return nil, err
+	}
+	m := proto.Clone(&written).(*dto.Metric)
+	m.Histogram.Bucket = a.classicBuckets()
+	return &dtoMetric{desc: metric.Desc(), metric: m}, nil
+}
@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const nativeHistogramConfig = `
+pg_query:
+  query: SELECT datname, duration, wait FROM test
+  metrics:
+    - datname:  { usage: LABEL }
+    - duration: { usage: HISTOGRAM, native: true, description: query duration }
+    - wait:     { usage: HISTOGRAM, native: true, schema: 0, bucket: [ 1, 10 ] }
+`
+
+func TestParseConfigNativeHistogram(t *testing.T) {
+	queries, err := ParseConfig([]byte(nativeHistogramConfig))
+	if err != nil {
+		t.Fatalf("native histogram without bucket should be valid: %v", err)
+	}
+	q := queries["pg_query"]
+	if !q.Columns["duration"].IsNativeHistogram() || q.Columns["duration"].NativeSchema() != defaultNativeHistogramSchema {
+		t.Fatalf("unexpected native column: %+v", q.Columns["duration"])
+	}
+	if q.Columns["wait"].NativeSchema() != 0 {
+		t.Fatalf("schema = %d, want 0", q.Columns["wait"].NativeSchema())
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "Native schema=3") {
+		t.Fatalf("explain should render native schema:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"classic without bucket": `{ usage: HISTOGRAM }`,
+		"native on gauge":        `{ usage: GAUGE, native: true }`,
+		"schema without native":  `{ usage: HISTOGRAM, schema: 2, bucket: [ 1 ] }`,
+		"schema too large":       `{ usage: HISTOGRAM, native: true, schema: 9 }`,
+		"schema too small":       `{ usage: HISTOGRAM, native: true, schema: -5 }`,
+		"invalid schema":         `{ usage: HISTOGRAM, native: true, schema: x }`,
+		"invalid native bucket":  `{ usage: HISTOGRAM, native: true, bucket: [ 2, 1 ] }`,
+	}
+	for name, column := range tests {
+		content := "q:\n  query: SELECT 1\n  metrics:\n    - datname: { usage: LABEL }\n    - v: " + column + "\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestNativeBucketKey(t *testing.T) {
+	tests := []struct {
+		value  float64
+		schema int32
+		key    int
+	}{
+		{1, 0, 0}, {1.5, 0, 1}, {2, 0, 1}, {2.1, 0, 2}, {0.5, 0, -1},
+		{1, 3, 0}, {2, 3, 8}, {math.Exp2(0.125), 3, 1}, {math.Exp2(0.125) * 1.0001, 3, 2},
+		{4, -1, 1}, {5, -1, 2}, {16, -2, 1}, {17, -2, 2},
+	}
+	for _, tt := range tests {
+		if got := nativeBucketKey(tt.value, tt.schema); got != tt.key {
+			t.Fatalf("nativeBucketKey(%v, %d) = %d, want %d", tt.value, tt.schema, got, tt.key)
+		}
+	}
+}
+
+func TestCollectorNativeHistogram(t *testing.T) {
+	queries, err := ParseConfig([]byte(nativeHistogramConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"app", 0.5, 0.5},
+		{"app", 3.0, 3.0},
+		{"app", 3.5, 30.0},
+		{"app", 0.0, nil},
+		{"app", -2.0, nil},
+		{"meta", 1.0, 1.0},
+	}
+	collector := newFakeCollector(t, queries["pg_query"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "duration", "wait"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute native histogram query: %v", err)
+	}
+	if got := collector.ResultSize(); got != 4 {
+		t.Fatalf("result size = %d, want 4 native histograms", got)
+	}
+
+	collector.TTL = 3600
+	collector.Server.scrapeBegin = time.Now()
+	collector.lastScrape = collector.Server.scrapeBegin
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("register collector: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather collector: %v", err)
+	}
+	familyMap := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		familyMap[family.GetName()] = family
+		if family.GetType() != dto.MetricType_HISTOGRAM {
+			t.Fatalf("family %s type = %s, want HISTOGRAM", family.GetName(), family.GetType())
+		}
+	}
+	if len(familyMap) != 2 || familyMap["pg_query_duration"].GetHelp() != "query duration" {
+		t.Fatalf("unexpected families: %v", familyMap)
+	}
+
+	var app *dto.Histogram
+	for _, metric := range familyMap["pg_query_duration"].GetMetric() {
+		if labelValue(metric, "datname") == "app" && labelValue(metric, "cluster") == "c1" {
+			app = metric.GetHistogram()
+		}
+	}
+	if app == nil {
+		t.Fatal("missing native histogram for datname=app")
+	}
+	if app.GetSampleCount() != 5 || app.GetSampleSum() != 5 || app.GetSchema() != 3 || app.GetZeroCount() != 1 {
+		t.Fatalf("unexpected native histogram: count=%d sum=%v schema=%d zero=%d",
+			app.GetSampleCount(), app.GetSampleSum(), app.GetSchema(), app.GetZeroCount())
+	}
+	if len(app.GetPositiveSpan()) == 0 || len(app.GetNegativeSpan()) == 0 {
+		t.Fatalf("expected positive and negative spans: %v", app)
+	}
+	// classic fallback: one bucket per populated native bucket, cumulative
+	classic := app.GetBucket()
+	if len(classic) != 5 || classic[0].GetUpperBound() >= -1 || classic[len(classic)-1].GetCumulativeCount() != 5 {
+		t.Fatalf("unexpected classic buckets: %v", classic)
+	}
+	for i := 1; i < len(classic); i++ {
+		if classic[i].GetUpperBound() <= classic[i-1].GetUpperBound() || classic[i].GetCumulativeCount() < classic[i-1].GetCumulativeCount() {
+			t.Fatalf("classic buckets are not cumulative and increasing: %v", classic)
+		}
+	}
+
+	// configured buckets are used for classic exposition, +Inf is implied
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE pg_query_wait histogram",
+		`pg_query_wait_bucket{cluster="c1",datname="app",le="1"} 1`,
+		`pg_query_wait_bucket{cluster="c1",datname="app",le="10"} 2`,
+		`pg_query_wait_bucket{cluster="c1",datname="app",le="+Inf"} 3`,
+		`pg_query_wait_count{cluster="c1",datname="app"} 3`,
+		`pg_query_duration_bucket{cluster="c1",datname="meta",le="1"} 1`,
+	} {
+		if !strings.Contains(text, line) {
+			t.Fatalf("text exposition missing %q:\n%s", line, text)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* ================ Named Parameters ================ */
+
+// SQL of queries, predicate queries and foreach drivers may reference server
+// facts as named parameters, which are sent as bind parameters instead of
+// being templated into SQL text:
+//
+//	:datname       database name of the server
+//	:username      current user name
+//	:version       server version number, e.g. 170002
+//	:tag_<name>    true if the server has tag <name> set by --tag
+//	:label_<name>  value of constant label <name>, including query labels
+//
+// Other `:name` tokens, `::` casts and text inside strings, quoted
+// identifiers, dollar quotes and comments are left untouched. Parameters are
+// numbered after foreach driver columns, so foreach SQL keeps $1..$n for them.
+
+const (
+	paramTagPrefix   = "tag_"
+	paramLabelPrefix = "label_"
+)
+
+// isNamedParam reports whether name is a recognized named parameter
+func isNamedParam(name string) bool {
+	switch name {
+	case "datname", "username", "version":
+		return true
+	}
+	return (strings.HasPrefix(name, paramTagPrefix) && len(name) > len(paramTagPrefix)) ||
+		(strings.HasPrefix(name, paramLabelPrefix) && len(name) > len(paramLabelPrefix))
+}
+
+// boundSQL is SQL whose named parameters are replaced by positional ones
+type boundSQL struct {
+	parts []string // SQL text around named parameter references
+	refs  []int    // index into names of each reference, len(parts)-1
+	names []string // distinct named parameters in order of first reference
+}
+
+// compileNamedParams finds named parameter references of SQL
+func compileNamedParams(sql string) *boundSQL {
+	b := &boundSQL{}
+	index := make(map[string]int)
+	last := 0
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case isExprIdentStart(rune(c)): // identifiers may contain `$`, e.g. a$b$c
+			for i++; i < len(sql) && (isExprIdentStart(rune(sql[i])) || sql[i] == '$' || (sql[i] >= '0' && sql[i] <= '9')); i++ {
+			}
+		case c == '\'' || c == '"':
+			i = skipQuoted(sql, i)
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(sql)
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case c == '$':
+			i = skipDollarQuoted(sql, i)
+		case c == ':' && strings.HasPrefix(sql[i:], "::"):
+			i += 2
+		case c == ':':
+			j := skipIdent(sql, i+1)
+			name := sql[i+1 : j]
+			if !isNamedParam(name) {
+				i = j
+				if name == "" {
+					i++
+				}
+				continue
+			}
+			n, found := index[name]
+			if !found {
+				n = len(b.names)
+				index[name] = n
+				b.names = append(b.names, name)
+			}
+			b.parts = append(b.parts, sql[last:i])
+			b.refs = append(b.refs, n)
+			last, i = j, j
+		default:
+			i++
+		}
+	}
+	b.parts = append(b.parts, sql[last:])
+	return b
+}
+
+// skipIdent returns the position after an identifier that starts at i, or i
+// if there is none
+func skipIdent(sql string, i int) int {
+	j := i
+	for j < len(sql) && (isExprIdentStart(rune(sql[j])) || (j > i && sql[j] >= '0' && sql[j] <= '9')) {
+		j++
+	}
+	return j
+}
+
+// skipQuoted returns the position after a quoted string or identifier that
+// starts at i, doubled quotes are escapes, so are backslashes in E-prefixed strings
+func skipQuoted(sql string, i int) int {
+	quote := sql[i]
+	escape := quote == '\'' && i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e')
+	for j := i + 1; j < len(sql); j++ {
+		switch {
+		case escape && sql[j] == '\\':
+			j++
+		case sql[j] == quote && j+1 < len(sql) && sql[j+1] == quote:
+			j++
+		case sql[j] == quote:
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+// skipBlockComment returns the position after a possibly nested block comment
+func skipBlockComment(sql string, i int) int {
+	depth := 0
+	for j := i; j < len(sql)-1; j++ {
+		switch sql[j : j+2] {
+		case "/*":
+			depth++
+			j++
+		case "*/":
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(sql)
+}
+
+// skipDollarQuoted returns the position after a dollar quoted string that
+// starts at i, or the position after `$` of a positional parameter
+func skipDollarQuoted(sql string, i int) int {
+	j := skipIdent(sql, i+1)
+	if j >= len(sql) || sql[j] != '$' {
+		return i + 1
+	}
+	tag := sql[i : j+1]
+	if end := strings.Index(sql[j+1:], tag); end >= 0 {
+		return j + 1 + end + len(tag)
+	}
+	return len(sql)
+}
+
+// render builds SQL with named parameters numbered from offset+1
+func (b *boundSQL) render(offset int) string {
+	if len(b.refs) == 0 {
+		return b.parts[0]
+	}
+	var sb strings.Builder
+	for i, part := range b.parts {
+		sb.WriteString(part)
+		if i < len(b.refs) {
+			sb.WriteString("$" + strconv.Itoa(offset+b.refs[i]+1))
+		}
+	}
+	return sb.String()
+}
+
+// NamedParams returns named parameters referenced by SQL of this query
+func (q *Query) NamedParams() []string {
+	return compileNamedParams(q.SQL).names
+}
+
+// bind renders SQL with named parameters numbered after offset positional
+// ones, and returns values of named parameters from server facts
+func (q *Collector) bind(b *boundSQL, offset int) (string, []interface{}, error) {
+	if len(b.names) == 0 {
+		return b.parts[0], nil, nil
+	}
+	values := make([]interface{}, len(b.names))
+	labels := q.constLabels()
+	for i, name := range b.names {
+		switch {
+		case name == "datname":
+			values[i] = q.Server.Database
+		case name == "username":
+			values[i] = q.Server.Username
+		case name == "version":
+			values[i] = int64(q.Server.Version)
+		case strings.HasPrefix(name, paramTagPrefix):
+			values[i] = q.Server.HasTag(strings.TrimPrefix(name, paramTagPrefix))
+		default:
+			value, found := labels[strings.TrimPrefix(name, paramLabelPrefix)]
+			if !found {
+				return "", nil, fmt.Errorf("parameter :%s refers to undefined constant label", name)
+			}
+			values[i] = value
+		}
+	}
+	return b.render(offset), values, nil
+}
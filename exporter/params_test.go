@@ -0,0 +1,129 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCompileNamedParams(t *testing.T) {
+	cases := []struct {
+		sql   string
+		want  string
+		names []string
+	}{
+		{"SELECT 1", "SELECT 1", nil},
+		{"SELECT * FROM pg_database WHERE datname = :datname", "SELECT * FROM pg_database WHERE datname = $1", []string{"datname"}},
+		{"SELECT :version, :datname, :version", "SELECT $1, $2, $1", []string{"version", "datname"}},
+		{"SELECT :tag_primary AND :label_cluster = 'x'", "SELECT $1 AND $2 = 'x'", []string{"tag_primary", "label_cluster"}},
+		{"SELECT 1::int, now()::date", "SELECT 1::int, now()::date", nil},
+		{"SELECT ':datname', \":datname\", E'\\':datname'", "SELECT ':datname', \":datname\", E'\\':datname'", nil},
+		{"SELECT 1 -- :datname\n, :username", "SELECT 1 -- :datname\n, $1", []string{"username"}},
+		{"SELECT /* :datname /* nested */ :datname */ :username", "SELECT /* :datname /* nested */ :datname */ $1", []string{"username"}},
+		{"SELECT $q$ :datname $q$, $$ :datname $$, $1, :datname", "SELECT $q$ :datname $q$, $$ :datname $$, $1, $1", []string{"datname"}},
+		{"SELECT a$b$c, :datname", "SELECT a$b$c, $1", []string{"datname"}},
+		{"SELECT :unknown, :tag_, arr[1:2]", "SELECT :unknown, :tag_, arr[1:2]", nil},
+	}
+	for _, c := range cases {
+		b := compileNamedParams(c.sql)
+		if got := b.render(0); got != c.want {
+			t.Errorf("render %q: got %q, want %q", c.sql, got, c.want)
+		}
+		if !reflect.DeepEqual(b.names, c.names) {
+			t.Errorf("names of %q: got %v, want %v", c.sql, b.names, c.names)
+		}
+	}
+	if got := compileNamedParams("SELECT $1, :datname, :username").render(2); got != "SELECT $1, $3, $4" {
+		t.Fatalf("named parameters should be numbered after offset, got %q", got)
+	}
+}
+
+func TestCollectorNamedParams(t *testing.T) {
+	queries, err := ParseConfig([]byte(`
+pg_param:
+  query: "SELECT 1 AS v WHERE :datname = current_database() AND :tag_primary AND :version >= 100000 AND :label_team = 'storage' AND :datname <> ''"
+  labels: { team: storage }
+  metrics:
+    - v: { usage: GAUGE }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain := queries["pg_param"].Explain(); !strings.Contains(explain, "Params     :datname, :tag_primary, :version, :label_team") {
+		t.Fatalf("explain should render named parameters:\n%s", explain)
+	}
+	var query string
+	var args []interface{}
+	connector := fakeConnector{respond: func(q string, named []driver.NamedValue) (driver.Rows, error) {
+		query, args = q, nil
+		for _, arg := range named {
+			args = append(args, arg.Value)
+		}
+		return &fakeRows{columns: []string{"v"}, values: [][]driver.Value{{int64(1)}}}, nil
+	}}
+	server := &Server{DB: openFakeDB(t, connector), Database: "meta", Version: 170002, Tags: []string{"primary"}, labels: prometheus.Labels{"cluster": "c1"}}
+	collector := NewCollector(queries["pg_param"], server)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute query with named parameters: %v", err)
+	}
+	if want := "SELECT 1 AS v WHERE $1 = current_database() AND $2 AND $3 >= 100000 AND $4 = 'storage' AND $1 <> ''"; query != want {
+		t.Fatalf("named parameters should be rewritten, got %q", query)
+	}
+	if want := []interface{}{"meta", true, int64(170002), "storage"}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("named parameters should be bound from server facts, got %v", args)
+	}
+
+	// undefined constant label fails the query
+	queries["pg_param"].SQL = "SELECT 1 AS v WHERE :label_missing = ''"
+	collector = NewCollector(queries["pg_param"], server)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err == nil || !strings.Contains(err.Error(), ":label_missing") {
+		t.Fatalf("undefined constant label should fail the query, got %v", err)
+	}
+}
+
+func TestForeachNamedParams(t *testing.T) {
+	queries, err := ParseConfig([]byte(`
+pg_part:
+  foreach: SELECT relname FROM parents WHERE current_user = :username
+  query: SELECT count(*) AS parts FROM children($1) WHERE :datname <> ''
+  metrics:
+    - relname: { usage: LABEL }
+    - parts:   { usage: GAUGE }
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var executed []string
+	connector := fakeConnector{respond: func(q string, named []driver.NamedValue) (driver.Rows, error) {
+		values := make([]string, len(named))
+		for i, arg := range named {
+			values[i] = castString(arg.Value)
+		}
+		executed = append(executed, q+" "+strings.Join(values, ","))
+		if strings.HasPrefix(q, "SELECT relname") {
+			return &fakeRows{columns: []string{"relname"}, values: [][]driver.Value{{"events"}}}, nil
+		}
+		return &fakeRows{columns: []string{"parts"}, values: [][]driver.Value{{int64(3)}}}, nil
+	}}
+	collector := NewCollector(queries["pg_part"], &Server{DB: openFakeDB(t, connector), Database: "meta", Username: "monitor"})
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute foreach query with named parameters: %v", err)
+	}
+	want := []string{
+		"SELECT relname FROM parents WHERE current_user = $1 monitor",
+		"SELECT count(*) AS parts FROM children($1) WHERE $2 <> '' events,meta",
+	}
+	if !reflect.DeepEqual(executed, want) {
+		t.Fatalf("named parameters should follow driver columns, got %q", executed)
+	}
+}
@@ -0,0 +1,288 @@
+package exporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+/* ================ Persistent Cache ================ */
+
+// With --cache.dir, the last successful result of each long TTL collector is
+// persisted on disk, and restored when the collector is created again after a
+// restart, reload or replanning, if it is still within TTL. Restored results
+// are served until the cache expires as usual, so restarts do not stampede the
+// database with expensive queries. Results of scheduled collectors are always
+// restored, as their schedule decides when to execute again.
+//
+// A cache file is named after a hash of the server url, followed by a hash of
+// the query branch and definition (including SQL), database name, server
+// identity (url without password and version), constant labels, relabel rules
+// and lookups. Any change of them leaves the old file unused, so stale results
+// are never restored. After planning a live server, files of the server that no
+// installed collector uses are removed once they are older than their TTL, so
+// explain runs and reloads with fewer queries never remove fresh results of
+// another exporter sharing the cache dir. A result is written at most once per
+// TTL, and at least persistMinTTL apart, so short TTL schedules do not rewrite
+// it on each execution. Files are written after the collector lock is released,
+// so slow disks do not block scrapes.
+
+// persistMinTTL is the minimal TTL in seconds of persisted collectors, shorter
+// cache expires before a restart completes anyway
+const persistMinTTL = 60
+
+// persistedResult is the on-disk form of a collector result
+type persistedResult struct {
+	Key     string    `json:"key"`
+	Branch  string    `json:"branch"`
+	Datname string    `json:"datname"`
+	At      time.Time `json:"at"`      // execution time of the result
+	TTL     float64   `json:"ttl"`     // seconds the result stays valid, unused files are removed after it
+	Metrics []byte    `json:"metrics"` // delimited protobuf metric families
+}
+
+// persisted reports whether results of this collector are persisted
+func (q *Collector) persisted() bool {
+	return q.Server.cacheDir != "" && !q.Server.DisableCache && (q.TTL >= persistMinTTL || q.schedule != nil)
+}
+
+// persistInterval returns the minimal interval between two writes of a result
+func (q *Collector) persistInterval() time.Duration {
+	return time.Duration(max(q.TTL, persistMinTTL) * float64(time.Second))
+}
+
+// persistKey identifies the query definition and server a result belongs to
+func (q *Collector) persistKey() string {
+	h := sha256.New()
+	write := func(parts ...string) {
+		for _, part := range parts {
+			h.Write([]byte(part))
+			h.Write([]byte{0})
+		}
+	}
+	write(q.Branch, q.MarshalYAML(), q.Server.Database, ShadowPGURL(q.Server.dsn), strconv.Itoa(q.Server.Version))
+	labels := q.constLabels()
+	for _, name := range sortedKeys(labels) {
+		write(name, labels[name])
+	}
+	relabel, _ := yaml.Marshal(q.Server.relabel)
+	lookups, _ := yaml.Marshal(q.Server.lookups)
+	write(string(relabel), string(lookups))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// persistPath returns path of the cache file of a key
+func (q *Collector) persistPath(key string) string {
+	return filepath.Join(q.Server.cacheDir, q.Server.cachePrefix()+key+".cache")
+}
+
+// cachePrefix prefixes names of cache files of this server, so servers sharing
+// a cache dir only remove their own stale files
+func (s *Server) cachePrefix() string {
+	sum := sha256.Sum256([]byte(ShadowPGURL(s.dsn)))
+	return hex.EncodeToString(sum[:8]) + "-"
+}
+
+// removeStaleResults removes cache files of this server that no installed
+// collector uses and that are older than their TTL, it is called after
+// planning a server that passed precheck
+func (s *Server) removeStaleResults() {
+	if s.cacheDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(s.cacheDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logWarnf("server [%s] fail listing cache dir %s: %s", s.Name(), s.cacheDir, err.Error())
+		}
+		return
+	}
+	used := make(map[string]bool, len(s.Collectors))
+	for _, q := range s.Collectors {
+		if q.persisted() {
+			used[filepath.Base(q.persistPath(q.key))] = true
+		}
+	}
+	prefix := s.cachePrefix()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".cache") || used[name] {
+			continue
+		}
+		if !expiredResult(filepath.Join(s.cacheDir, name)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.cacheDir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logWarnf("server [%s] fail removing stale cache file %s: %s", s.Name(), name, err.Error())
+			continue
+		}
+		logDebugf("server [%s] removed stale cache file %s", s.Name(), name)
+	}
+}
+
+// expiredResult reports whether a cache file is older than its TTL, malformed
+// files are never restored and count as expired
+func expiredResult(path string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var persisted persistedResult
+	if err = json.Unmarshal(content, &persisted); err != nil {
+		return true
+	}
+	return time.Since(persisted.At) > time.Duration(max(persisted.TTL, persistMinTTL)*float64(time.Second))
+}
+
+// persistResult snapshots the current result after a successful execution,
+// and returns the write of it to the cache dir, which the caller runs after
+// releasing the collector lock. Within persistInterval of last write, the
+// returned write does nothing. A failed write is retried after persistInterval.
+func (q *Collector) persistResult() (write func()) {
+	if !q.persisted() || (!q.persistedAt.IsZero() && q.lastScrape.Sub(q.persistedAt) < q.persistInterval()) {
+		return func() {}
+	}
+	result, at := slices.Clone(q.result), q.lastScrape // result is reused by next execution
+	q.persistedAt = at
+	return func() {
+		if err := q.writeResult(result, at); err != nil {
+			logWarnf("query [%s] @ server [%s] fail persisting result: %s", q.Name, q.Server.Name(), err.Error())
+		}
+	}
+}
+
+// writeResult encodes a result executed at given time and replaces the cache file atomically
+func (q *Collector) writeResult(result []prometheus.Metric, at time.Time) error {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(resultCollector(result)); err != nil {
+		return err
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	for _, family := range families {
+		if err = encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	content, err := json.Marshal(&persistedResult{Key: q.key, Branch: q.Branch, Datname: q.Server.Database, At: at, TTL: q.TTL, Metrics: buf.Bytes()})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(q.Server.cacheDir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(q.Server.cacheDir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.persistPath(q.key))
+}
+
+// restoreResult loads the persisted result of this collector if it is still
+// valid, it is called when the collector is created
+func (q *Collector) restoreResult() {
+	if !q.persisted() {
+		return
+	}
+	result, at, err := q.readResult()
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logWarnf("query [%s] @ server [%s] fail restoring result: %s", q.Name, q.Server.Name(), err.Error())
+		}
+		return
+	}
+	age := time.Since(at)
+	if q.schedule == nil && age > time.Duration(q.TTL*float64(time.Second))+q.jitter {
+		logDebugf("query [%s] @ server [%s] persisted result expired %v ago", q.Name, q.Server.Name(), age)
+		return
+	}
+	q.result, q.lastScrape, q.persistedAt = result, at, at
+	logDebugf("query [%s] @ server [%s] restored %d metrics persisted %v ago", q.Name, q.Server.Name(), len(result), age)
+}
+
+// readResult reads and decodes the cache file of this collector
+func (q *Collector) readResult() ([]prometheus.Metric, time.Time, error) {
+	content, err := os.ReadFile(q.persistPath(q.key))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var persisted persistedResult
+	if err = json.Unmarshal(content, &persisted); err != nil {
+		return nil, time.Time{}, fmt.Errorf("malformed cache file: %w", err)
+	}
+	if persisted.Key != q.key {
+		return nil, time.Time{}, fmt.Errorf("cache file key mismatch")
+	}
+	var result []prometheus.Metric
+	decoder := expfmt.NewDecoder(bytes.NewReader(persisted.Metrics), expfmt.NewFormat(expfmt.TypeProtoDelim))
+	for {
+		var family dto.MetricFamily
+		if err = decoder.Decode(&family); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, time.Time{}, fmt.Errorf("malformed cached metrics: %w", err)
+		}
+		for _, metric := range family.Metric {
+			labelNames := make([]string, len(metric.Label))
+			for i, pair := range metric.Label {
+				labelNames[i] = pair.GetName()
+			}
+			desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), labelNames, nil)
+			result = append(result, &dtoMetric{desc: desc, metric: metric})
+		}
+	}
+	return result, persisted.At, nil
+}
+
+// resultCollector collects a result as an unchecked collector
+type resultCollector []prometheus.Metric
+
+func (r resultCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (r resultCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range r {
+		ch <- metric
+	}
+}
+
+// dtoMetric is a metric written from a fixed dto, such as a metric decoded
+// from the cache dir or a native histogram with classic buckets attached
+type dtoMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (m *dtoMetric) Desc() *prometheus.Desc { return m.desc }
+
+func (m *dtoMetric) Write(out *dto.Metric) error {
+	proto.Merge(out, m.metric)
+	return nil
+}
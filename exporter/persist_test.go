@@ -0,0 +1,208 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const persistConfig = `
+pg_table_size:
+  query: SELECT relname, size FROM tables
+  ttl: 600
+  metrics:
+    - relname: { usage: LABEL }
+    - size:    { usage: GAUGE, description: table size in bytes }
+`
+
+// persistTestCollector executes and persists the result of pg_table_size into dir
+func persistTestCollector(t *testing.T, dir string) (*Query, *Server) {
+	t.Helper()
+	query, err := ParseQuery(persistConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newFakeServer(t, fakeConnector{rowsFactory: func() driver.Rows {
+		return &fakeRows{columns: []string{"relname", "size"}, values: [][]driver.Value{{"events", int64(8192)}, {"logs", int64(4096)}}}
+	}})
+	server.Version = 170002
+	server.cacheDir = dir
+	collector := NewCollector(query, server)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute query: %v", err)
+	}
+	collector.lastScrape = time.Now()
+	collector.persistResult()()
+	return query, server
+}
+
+func TestPersistedResultRestored(t *testing.T) {
+	dir := t.TempDir()
+	query, server := persistTestCollector(t, dir)
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.cache")); len(files) != 1 {
+		t.Fatalf("expected one cache file, got %v", files)
+	}
+
+	restored := NewCollector(query, server)
+	if restored.ResultSize() != 2 || restored.lastScrape.IsZero() {
+		t.Fatalf("result should be restored, got %d metrics", restored.ResultSize())
+	}
+	samples, families := gatherSamples(t, restored)
+	requireSample(t, samples, "pg_table_size_size", map[string]string{"cluster": "c1", "relname": "events"}, 8192)
+	requireSample(t, samples, "pg_table_size_size", map[string]string{"cluster": "c1", "relname": "logs"}, 4096)
+	if help := families["pg_table_size_size"].GetHelp(); help != "table size in bytes" {
+		t.Fatalf("restored metric should keep help, got %q", help)
+	}
+}
+
+func TestPersistedResultInvalidated(t *testing.T) {
+	dir := t.TempDir()
+	query, server := persistTestCollector(t, dir)
+
+	server.Version = 180000 // server identity changed
+	if NewCollector(query, server).ResultSize() != 0 {
+		t.Fatal("result of another server version should not be restored")
+	}
+	server.Version = 170002
+
+	changed := *query // query definition changed
+	changed.SQL = "SELECT relname, size FROM tables WHERE size > 0"
+	if NewCollector(&changed, server).ResultSize() != 0 {
+		t.Fatal("result of another query definition should not be restored")
+	}
+
+	collector := NewCollector(query, server) // result older than ttl
+	collector.lastScrape, collector.persistedAt = time.Now().Add(-time.Hour), time.Time{}
+	collector.persistResult()()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if NewCollector(query, server).ResultSize() != 0 {
+		t.Fatal("result older than ttl should not be restored")
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one cache file, got %v", files)
+	}
+
+	// corrupted files are ignored
+	if err := os.WriteFile(files[0], []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if NewCollector(query, server).ResultSize() != 0 {
+		t.Fatal("corrupted cache file should be ignored")
+	}
+}
+
+func TestPersistSkipsShortTTL(t *testing.T) {
+	dir := t.TempDir()
+	query, err := ParseQuery(persistConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query.TTL = 10
+	collector := NewCollector(query, &Server{cacheDir: dir})
+	collector.persistResult()()
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("short ttl result should not be persisted, got %v", files)
+	}
+}
+
+func TestPersistWritesOncePerTTL(t *testing.T) {
+	dir := t.TempDir()
+	query, server := persistTestCollector(t, dir)
+	collector := NewCollector(query, server)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if len(files) != 1 {
+		t.Fatalf("expected one cache file, got %v", files)
+	}
+	written := collector.persistedAt
+
+	collector.lastScrape = written.Add(time.Minute) // within ttl of last write
+	collector.persistResult()()
+	if collector.persistedAt != written {
+		t.Fatal("result should not be rewritten within ttl of last write")
+	}
+	collector.lastScrape = written.Add(10 * time.Minute)
+	collector.persistResult()()
+	if !collector.persistedAt.Equal(collector.lastScrape) {
+		t.Fatal("result should be rewritten once ttl of last write elapses")
+	}
+}
+
+func TestPersistWritesWithoutResultAliasing(t *testing.T) {
+	dir := t.TempDir()
+	query, server := persistTestCollector(t, dir)
+	collector := NewCollector(query, server)
+	collector.persistedAt = time.Time{}
+	write := collector.persistResult()
+	collector.result[0] = collector.result[1] // next execution reuses the result slice
+	write()
+	samples, _ := gatherSamples(t, NewCollector(query, server))
+	requireSample(t, samples, "pg_table_size_size", map[string]string{"cluster": "c1", "relname": "events"}, 8192)
+}
+
+// agePersistedResults moves execution time of all cache files in dir back by d
+func agePersistedResults(t *testing.T, dir string, d time.Duration) {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var persisted persistedResult
+		if err = json.Unmarshal(content, &persisted); err != nil {
+			continue
+		}
+		persisted.At = persisted.At.Add(-d)
+		if content, err = json.Marshal(&persisted); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(file, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRemoveStaleResults(t *testing.T) {
+	dir := t.TempDir()
+	query, server := persistTestCollector(t, dir)
+	other := filepath.Join(dir, "0123456789abcdef-"+strings.Repeat("0", 64)+".cache") // another server
+	if err := os.WriteFile(other, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	server.queries = map[string]*Query{query.Branch: query}
+	server.Plan()
+	server.removeStaleResults()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if len(files) != 2 || server.Collectors[0].ResultSize() != 2 {
+		t.Fatalf("installed collector should keep its cache file, got %v", files)
+	}
+
+	server.Version = 0 // plans without precheck (e.g. --explain) have other keys and never prune
+	server.Plan()
+	server.Version = 170002
+	if files, _ = filepath.Glob(filepath.Join(dir, "*.cache")); len(files) != 2 {
+		t.Fatalf("planning alone should not remove cache files, got %v", files)
+	}
+
+	renamed := *query // renamed query leaves the old file unused
+	renamed.Branch, renamed.Name = "pg_table_bytes", "pg_table_bytes"
+	server.queries = map[string]*Query{renamed.Branch: &renamed}
+	server.Plan()
+	server.removeStaleResults()
+	if files, _ = filepath.Glob(filepath.Join(dir, "*.cache")); len(files) != 2 {
+		t.Fatalf("unused file within ttl should be kept, got %v", files)
+	}
+
+	agePersistedResults(t, dir, time.Hour)
+	server.removeStaleResults()
+	files, _ = filepath.Glob(filepath.Join(dir, "*.cache"))
+	if len(files) != 1 || files[0] != other {
+		t.Fatalf("expired unused file of this server should be removed, others kept, got %v", files)
+	}
+}
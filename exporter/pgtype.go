@@ -0,0 +1,447 @@
+package exporter
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* ================ Type Conversion ================ */
+
+// Values of some postgres types arrive as text that strconv cannot parse.
+// They are converted natively by result column type, so SQL no longer needs
+// `extract(epoch FROM ...)` or `- '0/0'` casts:
+//
+//	pg_lsn            byte position, e.g. 16/B374D848 -> 97500059720
+//	interval          seconds, with 30 days a month and 365.25 days a year
+//	money             number without currency symbol and group separators
+//	numeric           float, including NaN and ±Infinity
+//	arrays            number of top level elements
+//
+// timestamp, timestamptz and date are already decoded by the driver and cast
+// to epoch seconds. Values of numeric columns are converted once after scan,
+// an unconvertible value fails the query with the column, type and value.
+
+// secondsPerDay, secondsPerMonth and secondsPerYear follow extract(epoch FROM interval)
+const (
+	secondsPerDay   = 86400
+	secondsPerMonth = 30 * secondsPerDay
+	secondsPerYear  = 365.25 * secondsPerDay
+)
+
+// valueConverter converts a text value of a postgres type into float64
+type valueConverter func(text string) (float64, error)
+
+// typeConverter returns the converter of a database type name reported by
+// the driver, or nil if the value is cast by castFloat64 as usual
+func typeConverter(typeName string) valueConverter {
+	typeName = strings.ToUpper(typeName)
+	switch {
+	case typeName == "PG_LSN":
+		return parseLSN
+	case typeName == "INTERVAL":
+		return parseInterval
+	case typeName == "MONEY":
+		return parseMoney
+	case typeName == "NUMERIC":
+		return parseNumeric
+	case strings.HasPrefix(typeName, "_"):
+		return parseArrayLength
+	}
+	return nil
+}
+
+// parseLSN converts a pg_lsn `XXXXXXXX/XXXXXXXX` into byte position
+func parseLSN(text string) (float64, error) {
+	hi, lo, found := strings.Cut(text, "/")
+	if !found {
+		return 0, fmt.Errorf("missing '/' separator")
+	}
+	high, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid high part %q", hi)
+	}
+	low, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid low part %q", lo)
+	}
+	return float64(high<<32 | low), nil
+}
+
+// intervalUnits maps postgres and postgres_verbose interval units to seconds
+var intervalUnits = map[string]float64{
+	"year": secondsPerYear, "years": secondsPerYear,
+	"mon": secondsPerMonth, "mons": secondsPerMonth,
+	"day": secondsPerDay, "days": secondsPerDay,
+	"hour": 3600, "hours": 3600,
+	"min": 60, "mins": 60,
+	"sec": 1, "secs": 1,
+}
+
+// parseInterval converts an interval of any IntervalStyle into seconds:
+//
+//	postgres          1 year 2 mons -3 days +04:05:06.5
+//	postgres_verbose  @ 1 year 2 mons -3 days 4 hours 5 mins 6.5 secs ago
+//	sql_standard      1-2, -3 4:05:06.5 or +1-2 -3 +4:05:06.5 with mixed signs
+//	iso_8601          P1Y2M-3DT4H5M6.5S
+func parseInterval(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		return 0, fmt.Errorf("empty interval")
+	case strings.HasPrefix(text, "P"):
+		return parseISOInterval(text)
+	case strings.HasPrefix(text, "@"):
+		return parseVerboseInterval(text[1:])
+	case !strings.ContainsFunc(text, unicode.IsLetter):
+		return parseSQLInterval(text)
+	}
+	return parseUnitInterval(strings.Fields(text))
+}
+
+// parseUnitInterval converts fields of `number unit` pairs and clock times into seconds
+func parseUnitInterval(fields []string) (float64, error) {
+	var seconds float64
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if strings.Contains(field, ":") {
+			clock, err := parseClock(field)
+			if err != nil {
+				return 0, err
+			}
+			seconds += clock
+			continue
+		}
+		n, err := strconv.ParseFloat(field, 64)
+		if err != nil || i+1 >= len(fields) {
+			return 0, fmt.Errorf("invalid interval field %q", field)
+		}
+		unit, found := intervalUnits[fields[i+1]]
+		if !found {
+			return 0, fmt.Errorf("unknown interval unit %q", fields[i+1])
+		}
+		seconds += n * unit
+		i++
+	}
+	return seconds, nil
+}
+
+// parseVerboseInterval converts a postgres_verbose interval without its `@`
+// into seconds, a trailing `ago` negates the whole interval
+func parseVerboseInterval(text string) (float64, error) {
+	fields := strings.Fields(text)
+	sign := 1.0
+	if len(fields) > 0 && fields[len(fields)-1] == "ago" {
+		sign, fields = -1, fields[:len(fields)-1]
+	}
+	if len(fields) == 1 && fields[0] == "0" {
+		return 0, nil
+	}
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty interval")
+	}
+	seconds, err := parseUnitInterval(fields)
+	return sign * seconds, err
+}
+
+// parseSQLInterval converts a sql_standard interval into seconds. Fields of
+// mixed signs are all signed, e.g. `+1-2 -3 +4:05:06`, otherwise a leading
+// `-` negates the whole interval, e.g. `-3 4:05:06` or `-1-2`
+func parseSQLInterval(text string) (float64, error) {
+	if text == "0" {
+		return 0, nil
+	}
+	fields := strings.Fields(text)
+	sign := 1.0
+	if len(fields) < 3 && strings.HasPrefix(fields[0], "-") {
+		sign, fields[0] = -1, fields[0][1:]
+	}
+	var seconds float64
+	for i, field := range fields {
+		switch {
+		case strings.Contains(field, ":"):
+			clock, err := parseClock(field)
+			if err != nil {
+				return 0, err
+			}
+			seconds += clock
+		case strings.Contains(strings.TrimLeft(field, "+-"), "-"):
+			yearMonth, err := parseYearMonth(field)
+			if err != nil {
+				return 0, err
+			}
+			seconds += yearMonth
+		case i+1 < len(fields) && strings.Contains(fields[i+1], ":"): // days precede time
+			days, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid interval days %q", field)
+			}
+			seconds += days * secondsPerDay
+		default:
+			return 0, fmt.Errorf("invalid interval field %q", field)
+		}
+	}
+	return sign * seconds, nil
+}
+
+// parseYearMonth converts a signed sql_standard `[-+]years-months` into seconds
+func parseYearMonth(text string) (float64, error) {
+	sign := 1.0
+	field := text
+	switch {
+	case strings.HasPrefix(field, "-"):
+		sign, field = -1, field[1:]
+	case strings.HasPrefix(field, "+"):
+		field = field[1:]
+	}
+	y, m, _ := strings.Cut(field, "-")
+	years, err := strconv.ParseUint(y, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval years %q", text)
+	}
+	months, err := strconv.ParseUint(m, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval months %q", text)
+	}
+	return sign * (float64(years)*secondsPerYear + float64(months)*secondsPerMonth), nil
+}
+
+// parseClock converts a signed `[-+]hh:mm:ss[.ffffff]` into seconds
+func parseClock(text string) (float64, error) {
+	sign := 1.0
+	switch {
+	case strings.HasPrefix(text, "-"):
+		sign, text = -1, text[1:]
+	case strings.HasPrefix(text, "+"):
+		text = text[1:]
+	}
+	parts := strings.Split(text, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid interval time %q", text)
+	}
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid interval time %q", text)
+		}
+		seconds += n * math.Pow(60, float64(2-i))
+	}
+	return sign * seconds, nil
+}
+
+// parseISOInterval converts an ISO 8601 interval with designators into seconds
+func parseISOInterval(text string) (float64, error) {
+	units := map[byte]float64{'Y': secondsPerYear, 'M': secondsPerMonth, 'W': 7 * secondsPerDay, 'D': secondsPerDay}
+	timeUnits := map[byte]float64{'H': 3600, 'M': 60, 'S': 1}
+	var seconds float64
+	inTime := false
+	number := ""
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == 'T':
+			inTime = true
+		case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+			number += string(c)
+		default:
+			unit, found := units[c]
+			if inTime {
+				unit, found = timeUnits[c]
+			}
+			n, err := strconv.ParseFloat(number, 64)
+			if !found || err != nil {
+				return 0, fmt.Errorf("invalid ISO 8601 interval component %q", number+string(c))
+			}
+			seconds += n * unit
+			number = ""
+		}
+	}
+	if number != "" {
+		return 0, fmt.Errorf("ISO 8601 interval component %q has no designator", number)
+	}
+	return seconds, nil
+}
+
+// parseMoney converts money output such as `-$1,234.56` into number. It
+// requires `.` as decimal point and `,` as group separator, which is the case
+// of lc_monetary C and en_US, other formats such as `1.234,56 €` are rejected
+// instead of being misread. A value is negative with a `-` before its digits,
+// e.g. `$-1.00`, or inside parentheses, e.g. `($1.00)`
+func parseMoney(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	negative := false
+	if strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		negative, text = true, text[1:len(text)-1]
+	}
+	isNumber := func(r rune) bool { return unicode.IsDigit(r) || r == '.' || r == ',' }
+	start := strings.IndexFunc(text, isNumber)
+	if start < 0 {
+		return 0, fmt.Errorf("no digits in money value")
+	}
+	end := len(text)
+	if i := strings.IndexFunc(text[start:], func(r rune) bool { return !isNumber(r) }); i >= 0 {
+		end = start + i
+	}
+	number, rest := text[start:end], text[end:]
+	if strings.Contains(text[:start], "-") {
+		negative = true
+	}
+	if strings.Contains(rest, "-") || strings.ContainsAny(text, "()") {
+		return 0, fmt.Errorf("misplaced sign in money value")
+	}
+	if strings.ContainsFunc(rest, unicode.IsDigit) {
+		return 0, fmt.Errorf("unsupported money format, lc_monetary should group digits with ','")
+	}
+	integer, fraction, _ := strings.Cut(number, ".")
+	if strings.ContainsAny(fraction, ".,") {
+		return 0, fmt.Errorf("unsupported money format, lc_monetary should use '.' as decimal point")
+	}
+	groups := strings.Split(integer, ",")
+	for i, group := range groups {
+		if i > 0 && len(group) != 3 || i == 0 && len(groups) > 1 && group == "" {
+			return 0, fmt.Errorf("unsupported money format, lc_monetary should use '.' as decimal point")
+		}
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(number, ",", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid money value")
+	}
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+// parseNumeric converts numeric text, strconv accepts NaN and ±Infinity as well
+func parseNumeric(text string) (float64, error) {
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid numeric value")
+	}
+	return n, nil
+}
+
+// parseArrayLength counts top level elements of an array literal such as
+// `{1,2,NULL}` or `{"a,b",c}`, nested arrays count as one element each
+func parseArrayLength(text string) (float64, error) {
+	if i := strings.Index(text, "="); strings.HasPrefix(text, "[") && i > 0 { // skip explicit dimensions, e.g. [0:2]={1,2,3}
+		text = text[i+1:]
+	}
+	if len(text) < 2 || text[0] != '{' || text[len(text)-1] != '}' {
+		return 0, fmt.Errorf("not an array literal")
+	}
+	body := text[1 : len(text)-1]
+	if strings.TrimSpace(body) == "" {
+		return 0, nil
+	}
+	count, depth, quoted, escaped := 1, 0, false, false
+	for _, r := range body {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+		case r == ',' && depth == 0:
+			count++
+		}
+	}
+	if quoted || depth != 0 {
+		return 0, fmt.Errorf("unbalanced array literal")
+	}
+	return float64(count), nil
+}
+
+// readsNumber reports whether values of this column are read as numbers,
+// labels, states, mapped texts and pivot names keep their text as is, and
+// DISCARD columns are only read when other columns are computed from them
+func (q *Query) readsNumber(column *Column) bool {
+	if column == nil || column.Usage == LABEL || column.Usage == STATESET || len(column.Mapping) > 0 {
+		return false
+	}
+	if q.Pivot != nil && (column.Name == q.Pivot.Name || column.Name == q.Pivot.Help) {
+		return false
+	}
+	if column.Usage == DISCARD {
+		return q.readsDiscard(column.Name)
+	}
+	return true
+}
+
+// readsDiscard reports whether a DISCARD column is an expr operand, a source
+// column of a histogram or summary, or the top_n.by column
+func (q *Query) readsDiscard(name string) bool {
+	if q.TopN != nil && q.TopN.By == name {
+		return true
+	}
+	for _, column := range q.Columns {
+		if slices.Contains(column.exprOperands, name) || slices.Contains(column.sourceColumns(), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// columnConverter converts values of one result column
+type columnConverter struct {
+	typeName string
+	convert  valueConverter
+}
+
+// valueConverters returns converters of result columns read as numbers,
+// indexed by result position, nil if no column needs conversion
+func (q *Collector) valueConverters(rows *sql.Rows, columnNames []string) ([]*columnConverter, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	var converters []*columnConverter
+	for i, columnType := range types {
+		if !q.readsNumber(q.Columns[columnNames[i]]) {
+			continue
+		}
+		typeName := columnType.DatabaseTypeName()
+		if convert := typeConverter(typeName); convert != nil {
+			if converters == nil {
+				converters = make([]*columnConverter, len(types))
+			}
+			converters[i] = &columnConverter{typeName: typeName, convert: convert}
+		}
+	}
+	return converters, nil
+}
+
+// convertValues replaces text values of typed columns in a scanned row with float64
+func (q *Collector) convertValues(converters []*columnConverter, columnNames []string, colData []interface{}) error {
+	for i, converter := range converters {
+		if converter == nil {
+			continue
+		}
+		var text string
+		switch v := colData[i].(type) {
+		case []byte:
+			text = string(v)
+		case string:
+			text = v
+		default: // NULL or already decoded by driver
+			continue
+		}
+		value, err := converter.convert(text)
+		if err != nil {
+			return fmt.Errorf("column %s.%s cannot convert %s value %q: %w", q.Name, columnNames[i], converter.typeName, text, err)
+		}
+		colData[i] = value
+	}
+	return nil
+}
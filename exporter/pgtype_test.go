@@ -0,0 +1,150 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTypeConverter(t *testing.T) {
+	tests := []struct {
+		typeName string
+		text     string
+		want     float64
+	}{
+		{"PG_LSN", "16/B374D848", 97500059720},
+		{"PG_LSN", "0/0", 0},
+		{"INTERVAL", "00:00:01.5", 1.5},
+		{"INTERVAL", "-00:05:00", -300},
+		{"INTERVAL", "1 day 02:00:00", 93600},
+		{"INTERVAL", "-1 days +02:03:00", -86400 + 7380},
+		{"INTERVAL", "1 year 2 mons 3 days", 365.25*86400 + 2*30*86400 + 3*86400},
+		{"INTERVAL", "P1DT2H30M0.5S", 86400 + 9000.5},
+		{"INTERVAL", "PT-1M", -60},
+		{"INTERVAL", "@ 1 year 2 mons -3 days 4 hours 5 mins 6.5 secs", secondsPerYear + 2*secondsPerMonth - 3*86400 + 14706.5},
+		{"INTERVAL", "@ 1 day 2 hours ago", -93600},
+		{"INTERVAL", "@ 0", 0},
+		{"INTERVAL", "1-2 ", secondsPerYear + 2*secondsPerMonth},
+		{"INTERVAL", "-1-2", -secondsPerYear - 2*secondsPerMonth},
+		{"INTERVAL", "3 4:05:06", 3*86400 + 14706},
+		{"INTERVAL", "-3 4:05:06", -3*86400 - 14706},
+		{"INTERVAL", "+1-2 -3 +4:05:06.5", secondsPerYear + 2*secondsPerMonth - 3*86400 + 14706.5},
+		{"INTERVAL", "-0:05:00", -300},
+		{"INTERVAL", "0", 0},
+		{"MONEY", "$1,234.56", 1234.56},
+		{"MONEY", "-$0.50", -0.5},
+		{"MONEY", "$-0.50", -0.5},
+		{"MONEY", "($1,234.56)", -1234.56},
+		{"MONEY", " 12.00 ", 12},
+		{"MONEY", "$1,234,567.00", 1234567},
+		{"MONEY", "￥1,234", 1234},
+		{"NUMERIC", "3.14", 3.14},
+		{"NUMERIC", "Infinity", math.Inf(1)},
+		{"_INT4", "{1,2,NULL}", 3},
+		{"_TEXT", `{"a,b","c\"}",{x}}`, 3},
+		{"_INT4", "{}", 0},
+		{"_INT4", "[0:1]={7,8}", 2},
+	}
+	for _, tt := range tests {
+		got, err := typeConverter(tt.typeName)(tt.text)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tt.typeName, tt.text, err)
+		}
+		if math.Abs(got-tt.want) > 1e-9 && got != tt.want {
+			t.Fatalf("%s %q = %v, want %v", tt.typeName, tt.text, got, tt.want)
+		}
+	}
+	if f, _ := parseNumeric("NaN"); !math.IsNaN(f) {
+		t.Fatalf("numeric NaN should convert to NaN, got %v", f)
+	}
+	for _, typeName := range []string{"INT8", "TEXT", "TIMESTAMPTZ", ""} {
+		if typeConverter(typeName) != nil {
+			t.Fatalf("%s should be cast as usual", typeName)
+		}
+	}
+
+	invalid := map[string]string{
+		"PG_LSN":   "16-B374D848",
+		"INTERVAL": "@ 1 day later",
+		"MONEY":    "free",
+		"NUMERIC":  "1,5",
+		"_INT4":    "{1,{2}",
+	}
+	for typeName, text := range invalid {
+		if _, err := typeConverter(typeName)(text); err == nil {
+			t.Fatalf("%s %q: expected conversion error", typeName, text)
+		}
+	}
+	for _, text := range []string{"$1-2", "$1.00-", "1,234.56 -", "($1.00",
+		"1.234,56 €", "€ 1.234,56", "1 234,56 €", "1,5 €", "1,2345.00", ",123.00"} { // non-C lc_monetary formats are rejected
+		if _, err := parseMoney(text); err == nil {
+			t.Fatalf("money %q: expected conversion error", text)
+		}
+	}
+	for _, text := range []string{"1 fortnight", "12:xx:00", "P1X", "P1D2", "3", "@", "@ 1 day ago ago", "1-x", "3 4", "+1-2 x +4:05:06"} {
+		if _, err := parseInterval(text); err == nil {
+			t.Fatalf("interval %q: expected conversion error", text)
+		}
+	}
+}
+
+// typedTestRows reports database type names of result columns like lib/pq
+type typedTestRows struct {
+	fakeRows
+	types []string
+}
+
+func (r *typedTestRows) ColumnTypeDatabaseTypeName(index int) string { return r.types[index] }
+
+const typedConfig = `
+pg_repl:
+  query: SELECT name, lsn, lag, slots, total, uptime FROM test
+  metrics:
+    - name:   { usage: LABEL }
+    - lsn:    { usage: GAUGE }
+    - lag:    { usage: GAUGE, scale: 1000 }
+    - slots:  { usage: GAUGE }
+    - total:  { usage: GAUGE }
+    - uptime: { usage: DISCARD }
+`
+
+func TestCollectorTypeConversion(t *testing.T) {
+	queries, err := ParseConfig([]byte(typedConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{[]byte("16/B374D848"), []byte("16/B374D848"), []byte("00:00:01.5"), []byte("{a,b}"), []byte("$1,000.25"), []byte("@ 1 day")},
+		{[]byte("standby"), nil, nil, []byte("{}"), []byte("$0.00"), nil},
+	}
+	types := []string{"PG_LSN", "PG_LSN", "INTERVAL", "_TEXT", "MONEY", "INTERVAL"}
+	if queries["pg_repl"].readsNumber(queries["pg_repl"].Columns["uptime"]) {
+		t.Fatal("DISCARD column should not be read as number")
+	}
+	collector := newFakeCollector(t, queries["pg_repl"], func() driver.Rows { // DISCARD uptime is never converted
+		return &typedTestRows{fakeRows: fakeRows{columns: []string{"name", "lsn", "lag", "slots", "total", "uptime"}, values: values}, types: types}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute typed query: %v", err)
+	}
+	samples, _ := gatherSamples(t, collector)
+	lbl := map[string]string{"cluster": "c1", "name": "16/B374D848"} // LABEL keeps its text
+	requireSample(t, samples, "pg_repl_lsn", lbl, 97500059720)
+	requireSample(t, samples, "pg_repl_lag", lbl, 1500)
+	requireSample(t, samples, "pg_repl_slots", lbl, 2)
+	requireSample(t, samples, "pg_repl_total", lbl, 1000.25)
+	requireSample(t, samples, "pg_repl_slots", map[string]string{"cluster": "c1", "name": "standby"}, 0)
+
+	values[1][2] = []byte("forever")
+	collector.execute()
+	if err := collector.Error(); err == nil || !strings.Contains(err.Error(), `column pg_repl.lag cannot convert INTERVAL value "forever"`) {
+		t.Fatalf("unconvertible value should fail the query precisely, got %v", err)
+	}
+	if collector.ResultSize() != 0 {
+		t.Fatalf("failed execution should not publish result, got %d metrics", collector.ResultSize())
+	}
+}
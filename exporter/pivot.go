@@ -0,0 +1,114 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/* ================ Pivot ================ */
+
+// Pivot turns key/value rows into metric families: the name column supplies
+// the metric name suffix, and the only GAUGE/COUNTER column supplies the value,
+// e.g. `SELECT name, setting, short_desc FROM pg_settings` yields
+// <query.name>_<name>{labels} <setting> with short_desc as HELP text.
+type Pivot struct {
+	Name  string   `yaml:"name"`            // column that holds metric name suffix
+	Help  string   `yaml:"help,omitempty"`  // column that holds per-name HELP text, optional
+	Allow []string `yaml:"allow,omitempty"` // names allowed to be exported
+	Match string   `yaml:"match,omitempty"` // regexp that names must match to be exported
+
+	regex *regexp.Regexp
+}
+
+// validatePivot validates pivot columns and name filter of a query, which
+// must be called after query columns are parsed
+func validatePivot(q *Query) error {
+	p := q.Pivot
+	if p.Name == "" {
+		return fmt.Errorf("pivot requires a name column")
+	}
+	for _, name := range []string{p.Name, p.Help} {
+		if name == "" {
+			continue
+		}
+		column := q.Columns[name]
+		if column == nil {
+			return fmt.Errorf("pivot column %q is not defined", name)
+		}
+		if column.Usage != DISCARD {
+			return fmt.Errorf("pivot column %q must be DISCARD, got %s", name, column.Usage)
+		}
+	}
+	if p.Help == p.Name {
+		return fmt.Errorf("pivot help column %q is also the name column", p.Help)
+	}
+	if len(q.MetricNames) != 1 {
+		return fmt.Errorf("pivot requires exactly one value column, got %d", len(q.MetricNames))
+	}
+	if usage := q.Columns[q.MetricNames[0]].Usage; usage != GAUGE && usage != COUNTER {
+		return fmt.Errorf("pivot value column must be GAUGE or COUNTER, got %s", usage)
+	}
+	if q.Info {
+		return fmt.Errorf("pivot cannot be used with info")
+	}
+	if p.Match != "" {
+		regex, err := regexp.Compile(p.Match)
+		if err != nil {
+			return fmt.Errorf("invalid pivot match %q: %w", p.Match, err)
+		}
+		p.regex = regex
+	}
+	return nil
+}
+
+// Allowed reports whether a name passes the allowlist or regexp, a pivot
+// without both allows every name
+func (p *Pivot) Allowed(name string) bool {
+	if len(p.Allow) == 0 && p.regex == nil {
+		return true
+	}
+	return slices.Contains(p.Allow, name) || (p.regex != nil && p.regex.MatchString(name))
+}
+
+// sanitizeMetricName replaces characters that are invalid in metric names with underscore
+func sanitizeMetricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// pivotDesc returns the descriptor named by the pivot name column of a row.
+// Nil is returned for names filtered out or still invalid after sanitization.
+// Descriptors are shared within one execution, so the first HELP text wins.
+func (q *Collector) pivotDesc(descs map[string]*prometheus.Desc, colData []interface{}, columnIndexes map[string]int) *prometheus.Desc {
+	raw := colData[columnIndexes[q.Pivot.Name]]
+	if raw == nil {
+		return nil
+	}
+	name := castString(raw)
+	if !q.Pivot.Allowed(name) {
+		return nil
+	}
+	metricName := q.Name + "_" + sanitizeMetricName(name)
+	if desc, found := descs[metricName]; found {
+		return desc
+	}
+	if err := validatePromMetricName(metricName); err != nil {
+		logDebugf("query [%s] skip pivot name %q: %v", q.Name, name, err)
+		return nil
+	}
+	help := q.Columns[q.MetricNames[0]].Desc
+	if index, found := columnIndexes[q.Pivot.Help]; found && colData[index] != nil {
+		help = castString(colData[index])
+	}
+	desc := q.relabelDescs.newDesc(metricName, help, q.LabelList(), q.constLabels())
+	descs[metricName] = desc
+	return desc
+}
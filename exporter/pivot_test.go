@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+const pivotConfig = `
+pg_setting:
+  query: SELECT datname, name, setting, short_desc FROM test
+  pivot:
+    name: name
+    help: short_desc
+    allow: [ max_connections ]
+    match: '^(work_mem|auto_explain\.)'
+  metrics:
+    - datname:    { usage: LABEL }
+    - name:       { usage: DISCARD }
+    - setting:    { usage: GAUGE, description: setting value }
+    - short_desc: { usage: DISCARD }
+`
+
+func TestParseConfigPivot(t *testing.T) {
+	queries, err := ParseConfig([]byte(pivotConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_setting"]
+	if q.Pivot == nil || q.Pivot.regex == nil {
+		t.Fatalf("pivot should be parsed with compiled regexp: %+v", q.Pivot)
+	}
+	for name, want := range map[string]bool{"max_connections": true, "work_mem": true, "auto_explain.log_min_duration": true, "shared_buffers": false} {
+		if got := q.Pivot.Allowed(name); got != want {
+			t.Fatalf("Allowed(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if metrics := q.MetricList(); metrics[0].Name != "pg_setting_<name>{datname}" {
+		t.Fatalf("unexpected pivot metric list: %s", metrics[0].Name)
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "Pivot      name=name help=short_desc allow=[max_connections]") {
+		t.Fatalf("explain should render pivot:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"no name":        `{ help: d }`,
+		"undefined name": `{ name: x }`,
+		"label name":     `{ name: l }`,
+		"same help":      `{ name: n, help: n }`,
+		"bad regexp":     `{ name: n, match: "(" }`,
+	}
+	for name, pivot := range tests {
+		content := "q:\n  query: SELECT 1\n  pivot: " + pivot + "\n  metrics:\n    - l: { usage: LABEL }\n    - n: { usage: DISCARD }\n    - d: { usage: DISCARD }\n    - v: { usage: GAUGE }\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+	for name, metrics := range map[string]string{
+		"two values": "    - v: { usage: GAUGE }\n    - w: { usage: GAUGE }\n",
+		"histogram":  "    - v: { usage: HISTOGRAM, bucket: [ 1 ] }\n",
+	} {
+		content := "q:\n  query: SELECT 1\n  pivot: { name: n }\n  metrics:\n    - n: { usage: DISCARD }\n" + metrics
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := map[string]string{
+		"work_mem":                      "work_mem",
+		"auto_explain.log_min_duration": "auto_explain_log_min_duration",
+		"total xact count":              "total_xact_count",
+		"avg-wait:us":                   "avg_wait_us",
+	}
+	for input, want := range tests {
+		if got := sanitizeMetricName(input); got != want {
+			t.Fatalf("sanitizeMetricName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestCollectorPivot(t *testing.T) {
+	queries, err := ParseConfig([]byte(pivotConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"postgres", "max_connections", int64(100), "Sets the maximum number of concurrent connections."},
+		{"postgres", "work_mem", int64(4096), nil},
+		{"postgres", "auto_explain.log_min_duration", int64(-1), "Sets the minimum execution time."},
+		{"postgres", "shared_buffers", int64(16384), "Sets the number of shared memory buffers."},
+		{"postgres", nil, int64(1), "null name"},
+	}
+	collector := newFakeCollector(t, queries["pg_setting"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "name", "setting", "short_desc"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute pivot query: %v", err)
+	}
+	if got := collector.ResultSize(); got != 3 {
+		t.Fatalf("result size = %d, want 3", got)
+	}
+
+	samples, families := gatherSamples(t, collector)
+	lbl := map[string]string{"cluster": "c1", "datname": "postgres"}
+	requireSample(t, samples, "pg_setting_max_connections", lbl, 100)
+	requireSample(t, samples, "pg_setting_work_mem", lbl, 4096)
+	requireSample(t, samples, "pg_setting_auto_explain_log_min_duration", lbl, -1)
+	if _, found := families["pg_setting_shared_buffers"]; found {
+		t.Fatal("names outside allowlist and regexp should be skipped")
+	}
+	if help := families["pg_setting_max_connections"].GetHelp(); help != "Sets the maximum number of concurrent connections." {
+		t.Fatalf("help should come from help column, got %q", help)
+	}
+	if help := families["pg_setting_work_mem"].GetHelp(); help != "setting value" {
+		t.Fatalf("NULL help should fall back to value column description, got %q", help)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	htmltmpl "html/template"
 	"slices"
+	"strings"
 	texttmpl "text/template"
 	"time"
 
@@ -17,7 +18,9 @@ import (
 type Query struct {
 	Name             string           `yaml:"name,omitempty"`              // actual query name, used as metric prefix
 	Desc             string           `yaml:"desc,omitempty"`              // description of this metric query
-	SQL              string           `yaml:"query"`                       // SQL command to fetch metrics
+	SQL              string           `yaml:"query,omitempty"`             // SQL command to fetch metrics
+	SQLFile          string           `yaml:"query_file,omitempty"`        // external .sql file that holds SQL, relative to config file
+	Foreach          string           `yaml:"foreach,omitempty"`           // driver SQL whose rows are bound as $1..$n of SQL, one execution per row
 	PredicateQueries []PredicateQuery `yaml:"predicate_queries,omitempty"` // SQL command to filter metrics
 	Branch           string           `yaml:"-"`                           // branch name, top layer key of config file
 
@@ -30,23 +33,37 @@ type Query struct {
 	MaxVersion int      `yaml:"max_version,omitempty"` // maximal supported version, not include
 	Fatal      bool     `yaml:"fatal,omitempty"`       // if query marked fatal fail, entire scrape will fail
 	Skip       bool     `yaml:"skip,omitempty"`        // if query marked skip, it will be omit while loading
+	Info       bool     `yaml:"info,omitempty"`        // emit <name>_info{labels} 1 for each row
+	Schedule   string   `yaml:"schedule,omitempty"`    // time windows or cron expression allowing execution, cached result is served otherwise
+	Jitter     float64  `yaml:"jitter,omitempty"`      // random delay of ttl expiry and schedule in seconds, drawn per collector
+
+	KeepTimestamp bool   `yaml:"keep_timestamp,omitempty"` // stamp metrics with execution time, so cached results keep it
+	Pivot         *Pivot `yaml:"pivot,omitempty"`          // name metrics by a column instead of value column name
+	MaxSeries     int    `yaml:"max_series,omitempty"`     // drop rows with new label tuples beyond this many series, 0 is unlimited
+	TopN          *TopN  `yaml:"top_n,omitempty"`          // keep rows with the N largest values of a column
+
+	Labels map[string]string `yaml:"labels,omitempty"` // static labels appended to all series of this query
 
 	Metrics []map[string]*Column `yaml:"metrics"` // metric definition list
 
 	// metrics parsing auxiliaries
-	Path        string             `yaml:"-"` // where am I from ?
-	Columns     map[string]*Column `yaml:"-"` // column map
-	ColumnNames []string           `yaml:"-"` // column names in origin orders
-	LabelNames  []string           `yaml:"-"` // column (name) that used as label, sequences matters
-	MetricNames []string           `yaml:"-"` // column (name) that used as metric
+	Path          string             `yaml:"-"` // where am I from ?
+	Columns       map[string]*Column `yaml:"-"` // column map
+	ColumnNames   []string           `yaml:"-"` // column names in origin orders
+	LabelNames    []string           `yaml:"-"` // column (name) that used as label, sequences matters
+	MetricNames   []string           `yaml:"-"` // column (name) that used as metric
+	TimestampName string             `yaml:"-"` // column (name) that used as sample timestamp, optional
+	schedule      *schedule          // parsed Schedule, nil if always allowed
 }
 
 // A PredicateQuery is a query that returns a 1-column resultset that's used to decide whether
 // to run the main query.
 type PredicateQuery struct {
-	Name string  `yaml:"name,omitempty"`  // predicate query name, only used for logging
-	SQL  string  `yaml:"predicate_query"` // SQL command to return a predicate
-	TTL  float64 `yaml:"ttl,omitempty"`   // How long to cache results for
+	Name    string  `yaml:"name,omitempty"`                 // predicate query name, only used for logging
+	SQL     string  `yaml:"predicate_query,omitempty"`      // SQL command to return a predicate
+	SQLFile string  `yaml:"predicate_query_file,omitempty"` // external .sql file that holds SQL, relative to config file
+	TTL     float64 `yaml:"ttl,omitempty"`                  // How long to cache results for
+	Path    string  `yaml:"-"`                              // resolved path of SQLFile, empty if SQL is inline
 }
 
 var queryTemplate, _ = texttmpl.New("Query").Parse(`##
@@ -62,6 +79,24 @@ var queryTemplate, _ = texttmpl.New("Query").Parse(`##
 #       Priority   {{ .Priority }}
 #       Timeout    {{ .TimeoutDuration }}
 #       Fatal      {{ .Fatal }}
+#       Info       {{ .Info }}
+{{- with .Schedule }}
+#       Schedule   {{ . }}
+{{- end }}
+{{- with .Jitter }}
+#       Jitter     {{ . }}s
+{{- end }}
+{{- with .Foreach }}
+#       Foreach    {{ . }}
+{{- end }}
+{{- with .NamedParams }}
+#       Params     {{ range $i, $e := . }}{{ if $i }}, {{ end }}:{{ $e }}{{ end }}
+{{- end }}
+#       Timestamp  {{ with .TimestampName }}column {{ . }}{{ else }}none{{ end }}{{ if .KeepTimestamp }}, keep{{ end }}{{ with .Pivot }}
+#       Pivot      name={{ .Name }}{{ with .Help }} help={{ . }}{{ end }}{{ with .Allow }} allow={{ . }}{{ end }}{{ with .Match }} match={{ . }}{{ end }}{{ end }}{{ with .MaxSeries }}
+#       MaxSeries  {{ . }}{{ end }}{{ with .TopN }}
+#       TopN       n={{ .N }} by={{ .By }} hysteresis={{ .HysteresisRatio }}{{ if .Others }} others{{ end }}{{ end }}{{ with .Labels }}
+#       Labels     {{ . }}{{ end }}
 #       Version    {{ if ne .MinVersion 0 }}{{ .MinVersion }}{{ else }}lower{{ end }} ~ {{ if ne .MaxVersion 0 }}{{ .MaxVersion }}{{ else }}higher{{ end }}
 #       Source     {{ .Path }}
 #
@@ -69,7 +104,18 @@ var queryTemplate, _ = texttmpl.New("Query").Parse(`##
 {{- range .ColumnList }}
 #       {{ .Name }} ({{ .Usage }})
 #           {{ with .Desc }}{{ . }}{{ else }}N/A{{ end }}{{ if .Bucket }}
-#           Bucket {{ .Bucket }}{{ end }}{{ end }}
+#           Bucket {{ .Bucket }}{{ end }}{{ if .Native }}
+#           Native schema={{ .NativeSchema }}{{ end }}{{ if .IsHistogramBucket }}
+#           Source le={{ .LeColumn }} sum={{ .Sum }}{{ with .Count }} count={{ . }}{{ end }}{{ end }}{{ if .Quantiles }}
+#           Quantiles {{ .Quantiles }}{{ with .Sum }} sum={{ . }}{{ end }}{{ with .QuantileColumns }} columns={{ . }}{{ end }}{{ end }}{{ if .States }}
+#           States {{ .States }}{{ end }}{{ if .Mapping }}
+#           Mapping {{ .Mapping }}{{ end }}{{ with .Lookup }}
+#           Lookup {{ . }}{{ end }}{{ with .Expr }}
+#           Expr {{ . }}{{ end }}{{ with .DeltaMode }}
+#           Emit {{ . }} since last execution{{ end }}{{ with .ResetColumn }}, reset on {{ . }} change{{ end }}{{ if .HasRelabel }}
+#           Relabel{{ with .Regex }} regex={{ . }}{{ end }}{{ with .Replacement }} replacement={{ . }}{{ end }}{{ if .Lower }} lower{{ end }}{{ with .Truncate }} truncate={{ . }}{{ end }}{{ with .Values }} values={{ . }}{{ end }}{{ end }}{{ end }}{{ if .Info }}
+#       {{ .InfoName }} (INFO)
+#           constant 1 with labels {{ .LabelList }}{{ end }}
 #
 {{.MarshalYAML -}}
 `)
@@ -82,16 +128,19 @@ var htmlTemplate, _ = htmltmpl.New("Query").Parse(`
 {{ if len .PredicateQueries }}
 <h4>Predicate queries</h4>
 <table style="border-style: dotted;">
-<thead><tr><th>Name</th> <th>SQL</th> <th>Cache TTL</th></tr></thead>
+<thead><tr><th>Name</th> <th>SQL</th> <th>Cache TTL</th> <th>Source</th></tr></thead>
 <tbody>
 {{ range .PredicateQueries }}
-<tr><td>{{ .Name }}</td><td><code>{{ .SQL }}</code></td><td>{{if ne .TTL 0}}{{ .TTL }}s{{else}}<i>not cached</i>{{end}}</td></tr>
+<tr><td>{{ .Name }}</td><td><code>{{ .SQL }}</code></td><td>{{if ne .TTL 0}}{{ .TTL }}s{{else}}<i>not cached</i>{{end}}</td><td>{{with .Path}}{{ . }}{{else}}<i>inline</i>{{end}}</td></tr>
 {{ end }}
 </tbody></table>
 {{ end }}
 <h4>Query</h4>
 <code><pre>{{ .SQL }}</pre></code>
-
+{{ with .Foreach }}
+<h4>Foreach</h4>
+<code><pre>{{ . }}</pre></code>
+{{ end }}
 <h4>Attribution</h4>
 <code><table style="border-style: dotted;"><tbody>
 <tr><td>Branch   </td> <td> {{ .Branch }} </td></tr>
@@ -99,14 +148,15 @@ var htmlTemplate, _ = htmltmpl.New("Query").Parse(`
 <tr><td>Priority </td> <td> {{ .Priority }} </td></tr>
 <tr><td>Timeout  </td> <td> {{ .TimeoutDuration }} </td></tr>
 <tr><td>Fatal    </td> <td> {{ .Fatal }} </td></tr>
+<tr><td>Info     </td> <td> {{ .Info }} </td></tr>
 <tr><td>Version  </td> <td> {{if ne .MinVersion 0}}{{ .MinVersion }}{{else}}lower{{end}} ~ {{if ne .MaxVersion 0}}{{ .MaxVersion }}{{else}}higher{{end}} </td></tr>
 <tr><td>Tags     </td> <td> {{ .Tags }} </td></tr>
 <tr><td>Source   </td> <td> {{ .Path }} </td></tr>
 </tbody></table></code>
 
 <h4>Columns</h4>
-<code><table align="left" style="border-style: dotted;"><thead><tr><th>Name</th> <th>Usage</th> <th>Rename</th> <th>Bucket</th> <th>Scale</th> <th>Default</th> <th>Description</th></tr></thead>
-<tbody>{{ range .ColumnList }}<tr><td>{{ .Name }}</td><td>{{ .Usage }}</td><td>{{ .Rename }}</td><td>{{ .Bucket }}</td><td>{{ .Scale }}</td><td>{{ .Default }}</td><td>{{ .Desc }}</td></tr>{{ end }}
+<code><table align="left" style="border-style: dotted;"><thead><tr><th>Name</th> <th>Usage</th> <th>Rename</th> <th>Bucket</th> <th>States</th> <th>Mapping</th> <th>Scale</th> <th>Default</th> <th>Description</th></tr></thead>
+<tbody>{{ range .ColumnList }}<tr><td>{{ .Name }}</td><td>{{ .Usage }}</td><td>{{ .Rename }}</td><td>{{ .Bucket }}{{ if .Native }} native schema={{ .NativeSchema }}{{ end }}</td><td>{{ .States }}</td><td>{{ with .Mapping }}{{ . }}{{ end }}</td><td>{{ .Scale }}</td><td>{{ .Default }}</td><td>{{ .Desc }}</td></tr>{{ end }}
 </tbody></table></code>
 
 <h4>Metrics</h4>
@@ -184,14 +234,48 @@ func (q *Query) LabelList() []string {
 
 // MetricList returns a list of MetricDesc generated by this query
 func (q *Query) MetricList() (res []*MetricDesc) {
-	res = make([]*MetricDesc, len(q.MetricNames))
+	res = make([]*MetricDesc, len(q.MetricNames), len(q.MetricNames)+1)
 	for i, metricName := range q.MetricNames {
 		column := q.Columns[metricName]
-		res[i] = column.MetricDesc(q.Name, q.LabelList())
+		labels := q.LabelList()
+		if column.IsStateSet() {
+			labels = append(labels, column.StateLabel())
+		}
+		res[i] = column.MetricDesc(q.Name, labels)
+		if q.Pivot != nil {
+			res[i].Name = fmt.Sprintf("%s_<%s>{%s}", q.Name, q.Pivot.Name, strings.Join(labels, ","))
+		}
+	}
+	if q.Info {
+		res = append(res, q.infoColumn().MetricDesc(q.Name, q.LabelList()))
 	}
 	return
 }
 
+// InfoName returns the metric name of info query: <name>_info
+func (q *Query) InfoName() string {
+	return q.Name + "_info"
+}
+
+// infoColumn is the pseudo column that describes the generated info metric
+func (q *Query) infoColumn() *Column {
+	desc := q.Desc
+	if desc == "" {
+		desc = "constant 1"
+	}
+	return &Column{Name: "info", Usage: INFO, Desc: desc}
+}
+
+// HasSummary reports whether this query defines at least one SUMMARY metric.
+func (q *Query) HasSummary() bool {
+	for _, metricName := range q.MetricNames {
+		if column := q.Columns[metricName]; column != nil && column.IsSummary() {
+			return true
+		}
+	}
+	return false
+}
+
 // HasHistogram reports whether this query defines at least one logical
 // Histogram metric. Histogram components are derived later by the collector.
 func (q *Query) HasHistogram() bool {
@@ -203,6 +287,17 @@ func (q *Query) HasHistogram() bool {
 	return false
 }
 
+// HasValueCheck reports whether this query has STATESET or mapped columns,
+// whose unknown values are counted
+func (q *Query) HasValueCheck() bool {
+	for _, metricName := range q.MetricNames {
+		if column := q.Columns[metricName]; column != nil && (column.IsStateSet() || len(column.Mapping) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
 // TimeoutDuration will turn timeout settings into time.Duration
 func (q *Query) TimeoutDuration() time.Duration {
 	return time.Duration(float64(time.Second) * q.Timeout)
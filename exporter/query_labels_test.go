@@ -0,0 +1,68 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const queryLabelsConfig = `
+pg_db_size:
+  query: SELECT datname, size FROM test
+  labels: { team: storage, tier: critical }
+  metrics:
+    - datname: { usage: LABEL }
+    - size:    { usage: GAUGE }
+`
+
+func TestParseConfigQueryLabels(t *testing.T) {
+	queries, err := ParseConfig([]byte(queryLabelsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain := queries["pg_db_size"].Explain(); !strings.Contains(explain, "Labels     map[team:storage tier:critical]") {
+		t.Fatalf("explain should render static labels:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"invalid name":    "  labels: { 0team: a }\n  metrics:\n    - v: { usage: GAUGE }\n",
+		"reserved prefix": "  labels: { __team: a }\n  metrics:\n    - v: { usage: GAUGE }\n",
+		"label column":    "  labels: { db: a }\n  metrics:\n    - datname: { usage: LABEL, rename: db }\n    - v: { usage: GAUGE }\n",
+		"histogram le":    "  labels: { le: a }\n  metrics:\n    - v: { usage: HISTOGRAM, bucket: [ 1 ] }\n",
+		"summary":         "  labels: { quantile: a }\n  metrics:\n    - v: { usage: SUMMARY, quantiles: [ 0.5 ] }\n",
+		"stateset":        "  labels: { state: a }\n  metrics:\n    - state: { usage: STATESET, states: [ a ] }\n",
+	}
+	for name, body := range tests {
+		if _, err := ParseConfig([]byte("q:\n  query: SELECT 1\n" + body)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+
+	if err := validateConstLabelConflicts(prometheus.Labels{"team": "x"}, queries, false); err == nil || !strings.Contains(err.Error(), "static label") {
+		t.Fatalf("const label should conflict with static label, got %v", err)
+	}
+}
+
+func TestCollectorQueryLabels(t *testing.T) {
+	queries, err := ParseConfig([]byte(queryLabelsConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := newFakeCollector(t, queries["pg_db_size"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "size"}, values: [][]driver.Value{{"postgres", int64(42)}}}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute query with static labels: %v", err)
+	}
+	samples, _ := gatherSamples(t, collector)
+	lbl := map[string]string{"cluster": "c1", "datname": "postgres", "team": "storage", "tier": "critical"}
+	requireSample(t, samples, "pg_db_size_size", lbl, 42)
+	if len(collector.Server.labels) != 1 {
+		t.Fatalf("static labels should not leak into server labels: %v", collector.Server.labels)
+	}
+}
@@ -0,0 +1,230 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/* ================ Relabel ================ */
+
+// otherLabelValue replaces LABEL values outside the `values` allowlist
+const otherLabelValue = "other"
+
+// A LABEL column may rewrite its values before they become label values, in
+// this order: `regex` + `replacement`, `lower`, `truncate` to N characters,
+// then map values outside the `values` allowlist to `other`. Rewritten values
+// may collide, so rows sharing a label tuple after relabeling are merged:
+// GAUGE/COUNTER values are summed, the first row wins for STATESET and info,
+// histogram and summary rows share one group.
+
+// validateRelabel validates and compiles relabel options of a LABEL
+// column, which are rejected on other usages.
+func validateRelabel(column *Column) error {
+	if column.Usage != LABEL {
+		if column.HasRelabel() || column.Replacement != "" {
+			return fmt.Errorf("regex, replacement, lower, truncate and values are only supported by LABEL, got %s", column.Usage)
+		}
+		return nil
+	}
+	if column.Replacement != "" && column.Regex == "" {
+		return fmt.Errorf("LABEL replacement requires regex")
+	}
+	if column.Truncate < 0 {
+		return fmt.Errorf("LABEL truncate must be non-negative, got %d", column.Truncate)
+	}
+	if column.Regex != "" {
+		regex, err := regexp.Compile(column.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid LABEL regex %q: %w", column.Regex, err)
+		}
+		column.regex = regex
+	}
+	if len(column.Values) > 0 {
+		column.valueSet = make(map[string]bool, len(column.Values))
+		for _, value := range column.Values {
+			column.valueSet[value] = true
+		}
+	}
+	return nil
+}
+
+// HasRelabel reports whether values of this column are rewritten before use
+func (c *Column) HasRelabel() bool {
+	return c.Regex != "" || c.Lower || c.Truncate > 0 || len(c.Values) > 0
+}
+
+// HasRelabel reports whether any LABEL column of this query rewrites its values
+func (q *Query) HasRelabel() bool {
+	for _, name := range q.LabelNames {
+		if q.Columns[name].HasRelabel() {
+			return true
+		}
+	}
+	return false
+}
+
+// relabel rewrites one label value with configured relabel options
+func (c *Column) relabel(value string) string {
+	if c.regex != nil {
+		value = c.regex.ReplaceAllString(value, c.Replacement)
+	}
+	if c.Lower {
+		value = strings.ToLower(value)
+	}
+	if c.Truncate > 0 {
+		if runes := []rune(value); len(runes) > c.Truncate {
+			value = string(runes[:c.Truncate])
+		}
+	}
+	if c.valueSet != nil && !c.valueSet[value] {
+		value = otherLabelValue
+	}
+	return value
+}
+
+// seriesMerger merges metrics of one execution whose label tuples collide
+// after relabeling, keyed by descriptor and label tuple
+type seriesMerger map[string]*mergedSeries
+
+// mergedSeries records where a merged metric lives in pending result
+type mergedSeries struct {
+	index int       // position of metric in pending result
+	value float64   // accumulated value of a GAUGE/COUNTER metric
+	at    time.Time // sample time of the first row
+}
+
+func seriesKey(desc *prometheus.Desc, labels []string) string {
+	return desc.String() + "\xff" + encodeLabelTuple(labels)
+}
+
+// first reports whether this is the first metric of desc and labels, later
+// duplicates are discarded by the caller
+func (m seriesMerger) first(desc *prometheus.Desc, labels []string) bool {
+	key := seriesKey(desc, labels)
+	if _, found := m[key]; found {
+		return false
+	}
+	m[key] = &mergedSeries{}
+	return true
+}
+
+// merge adds value into a pending metric of desc and labels and reports true,
+// or records the metric that is about to be appended at index and reports false
+func (m seriesMerger) merge(pending []prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labels []string, index int, at time.Time) (bool, error) {
+	key := seriesKey(desc, labels)
+	series := m[key]
+	if series == nil {
+		m[key] = &mergedSeries{index: index, value: value, at: at}
+		return false, nil
+	}
+	series.value += value
+	metric, err := prometheus.NewConstMetric(desc, valueType, series.value, labels...)
+	if err != nil {
+		return true, err
+	}
+	if !series.at.IsZero() {
+		metric = prometheus.NewMetricWithTimestamp(series.at, metric)
+	}
+	pending[series.index] = metric
+	return true, nil
+}
+
+/* ================ Series Limit ================ */
+
+// SeriesPerRow returns how many series one label tuple of this query yields,
+// which is the cost of admitting a new label tuple under max_series
+func (q *Query) SeriesPerRow() int {
+	n := 0
+	if q.Info {
+		n++
+	}
+	for _, name := range q.MetricNames {
+		column := q.Columns[name]
+		switch {
+		case column.IsStateSet():
+			n += len(column.States)
+		case column.IsHistogram() && column.Native:
+			n++
+		case column.IsHistogram():
+			n += len(column.Bucket) + 3 // buckets, +Inf, _count and _sum
+		case column.IsSummary():
+			n += len(column.Quantiles) + 2 // quantiles, _count and _sum
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// seriesLimiter admits the label tuples whose keys sort first until max_series
+// is reached, so the same series are kept whatever order the result has
+type seriesLimiter struct {
+	limit    int             // max_series of query
+	cost     int             // series per label tuple
+	admitted map[string]bool // admitted label tuples
+	dropped  map[string]bool // dropped label tuples
+}
+
+func newSeriesLimiter(q *Query) *seriesLimiter {
+	return &seriesLimiter{
+		limit:    q.MaxSeries,
+		cost:     q.SeriesPerRow(),
+		admitted: make(map[string]bool),
+		dropped:  make(map[string]bool),
+	}
+}
+
+// buffer reads all remaining rows with scan, which fills colData, and admits
+// label tuples by order of their keys. key returns the key of current row, or
+// false if the row costs nothing. Rows are replayed in result order by the
+// returned scan function.
+func (l *seriesLimiter) buffer(scan func() (bool, error), colData []interface{}, key func() (string, bool)) (func() (bool, error), error) {
+	var rows [][]interface{}
+	var keys []string
+	seen := make(map[string]bool)
+	for {
+		more, err := scan()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		rows = append(rows, append([]interface{}(nil), colData...))
+		if k, counted := key(); counted && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for used := 0; len(l.admitted) < len(keys) && used+l.cost <= l.limit; used += l.cost {
+		l.admitted[keys[len(l.admitted)]] = true
+	}
+	next := 0
+	return func() (bool, error) {
+		if next >= len(rows) {
+			return false, nil
+		}
+		copy(colData, rows[next])
+		next++
+		return true, nil
+	}, nil
+}
+
+// admit reports whether rows of a label tuple are kept. The number of series
+// dropped by a newly rejected tuple is returned as well.
+func (l *seriesLimiter) admit(key string) (bool, int) {
+	if l.admitted[key] {
+		return true, 0
+	}
+	if l.dropped[key] {
+		return false, 0
+	}
+	l.dropped[key] = true
+	return false, l.cost
+}
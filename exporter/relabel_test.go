@@ -0,0 +1,178 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const relabelConfig = `
+pg_conn:
+  query: SELECT app, usename, state, conns FROM test
+  metrics:
+    - app:     { usage: LABEL, regex: '^([A-Za-z]+)-\d+$', replacement: '$1', lower: true, truncate: 8 }
+    - usename: { usage: LABEL, values: [ postgres, dbuser ] }
+    - state:   { usage: STATESET, states: [ active, idle ] }
+    - conns:   { usage: GAUGE }
+`
+
+func TestParseConfigRelabel(t *testing.T) {
+	queries, err := ParseConfig([]byte(relabelConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_conn"]
+	if !q.HasRelabel() || q.Columns["app"].regex == nil || !q.Columns["usename"].valueSet["dbuser"] {
+		t.Fatalf("relabel options should be compiled: %+v", q.Columns["app"])
+	}
+	if q.SeriesPerRow() != 3 {
+		t.Fatalf("series per row = %d, want 3", q.SeriesPerRow())
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "Relabel regex=^([A-Za-z]+)-\\d+$ replacement=$1 lower truncate=8") {
+		t.Fatalf("explain should render relabel options:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"regex on gauge":       `{ usage: GAUGE, regex: a }`,
+		"values on gauge":      `{ usage: GAUGE, values: [ a ] }`,
+		"replacement no regex": `{ usage: LABEL, replacement: a }`,
+		"negative truncate":    `{ usage: LABEL, truncate: -1 }`,
+		"bad regex":            `{ usage: LABEL, regex: "(" }`,
+	}
+	for name, column := range tests {
+		content := "q:\n  query: SELECT 1\n  metrics:\n    - v: { usage: GAUGE }\n    - l: " + column + "\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+	if _, err := ParseConfig([]byte("q:\n  query: SELECT 1\n  max_series: -1\n  metrics:\n    - v: { usage: GAUGE }\n")); err == nil {
+		t.Fatal("negative max_series should be rejected")
+	}
+}
+
+func TestColumnRelabel(t *testing.T) {
+	column := &Column{Name: "l", Usage: LABEL, Regex: `\s+`, Replacement: "_", Lower: true, Truncate: 4}
+	if err := validateRelabel(column); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"Hello World": "hell",
+		"A B":         "a_b",
+		"ÄÖÜßxyz":     "äöüß",
+		"":            "",
+	}
+	for input, want := range tests {
+		if got := column.relabel(input); got != want {
+			t.Fatalf("relabel(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestCollectorRelabelMerge(t *testing.T) {
+	queries, err := ParseConfig([]byte(relabelConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"Web-1", "postgres", "active", int64(3)},
+		{"web-2", "postgres", "idle", int64(4)},
+		{"worker-1", "alice", "idle", int64(1)},
+		{"worker-2", "bob", "active", int64(2)},
+		{"cron", nil, "active", int64(5)},
+	}
+	collector := newFakeCollector(t, queries["pg_conn"], func() driver.Rows {
+		return &fakeRows{columns: []string{"app", "usename", "state", "conns"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute relabel query: %v", err)
+	}
+	// three label tuples after relabeling, each with 2 states and 1 gauge
+	if got := collector.ResultSize(); got != 9 {
+		t.Fatalf("result size = %d, want 9", got)
+	}
+
+	samples, _ := gatherSamples(t, collector)
+	lbl := func(app, usename string) map[string]string {
+		return map[string]string{"cluster": "c1", "app": app, "usename": usename}
+	}
+	requireSample(t, samples, "pg_conn_conns", lbl("web", "postgres"), 7)
+	requireSample(t, samples, "pg_conn_conns", lbl("worker", "other"), 3)
+	requireSample(t, samples, "pg_conn_conns", lbl("cron", "other"), 5)
+	state := func(app, usename, state string) map[string]string {
+		l := lbl(app, usename)
+		l["state"] = state
+		return l
+	}
+	requireSample(t, samples, "pg_conn_state", state("web", "postgres", "active"), 1)
+	requireSample(t, samples, "pg_conn_state", state("worker", "other", "idle"), 1)
+}
+
+func TestCollectorMaxSeries(t *testing.T) {
+	config := strings.Replace(relabelConfig, "  metrics:", "  max_series: 7\n  metrics:", 1)
+	queries, err := ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"a", "postgres", "active", int64(1)},
+		{"b", "postgres", "active", int64(2)},
+		{"c", "postgres", "active", int64(3)},
+		{"a", "postgres", "idle", int64(4)},
+		{"d", "postgres", "active", int64(5)},
+		{"c", "postgres", "active", int64(6)},
+	}
+	collector := newFakeCollector(t, queries["pg_conn"], func() driver.Rows {
+		return &fakeRows{columns: []string{"app", "usename", "state", "conns"}, values: values}
+	}, nil)
+	s := collector.Server
+	s.Collectors = []*Collector{collector}
+	s.ResetStats()
+	if v, found := s.queryScrapeSeriesDropped["pg_conn"]; !found || v != 0 {
+		t.Fatalf("series dropped counter should be initialized, got %v %v", v, found)
+	}
+
+	ch := make(chan prometheus.Metric, 32)
+	for i := 0; i < 2; i++ {
+		s.scrapeBegin = time.Now()
+		collector.lastScrape = time.Time{}
+		if err := s.executeQuery(collector, ch); err != nil {
+			t.Fatal(err)
+		}
+		for len(ch) > 0 {
+			<-ch
+		}
+	}
+	// 3 series per tuple: a and b are admitted, c and d are dropped
+	if got := collector.ResultSize(); got != 6 {
+		t.Fatalf("result size = %d, want 6", got)
+	}
+	if got := collector.DroppedSeries(); got != 6 {
+		t.Fatalf("dropped series = %d, want 6", got)
+	}
+	if got := s.queryScrapeSeriesDropped["pg_conn"]; got != 12 {
+		t.Fatalf("series dropped total = %v, want 12", got)
+	}
+	samples, _ := gatherSamples(t, collector)
+	requireSample(t, samples, "pg_conn_conns", map[string]string{"cluster": "c1", "app": "a", "usename": "postgres"}, 5)
+
+	// the same label tuples are admitted whatever order the result has
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+	s.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatal(err)
+	}
+	samples, _ = gatherSamples(t, collector)
+	if len(samples) != 6 {
+		t.Fatalf("expected series of a and b only, got %v", samples)
+	}
+	requireSample(t, samples, "pg_conn_conns", map[string]string{"cluster": "c1", "app": "a", "usename": "postgres"}, 5)
+	requireSample(t, samples, "pg_conn_conns", map[string]string{"cluster": "c1", "app": "b", "usename": "postgres"}, 2)
+}
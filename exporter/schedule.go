@@ -0,0 +1,269 @@
+package exporter
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ================ Schedule ================ */
+
+// A query with `schedule` only executes inside its schedule and serves the
+// cached result outside it, so expensive queries such as bloat estimation stay
+// out of business hours. Schedules are evaluated in the local time zone of the
+// exporter and take one of two forms:
+//
+//	01:00-05:00, 22:00-23:30   time windows, executed on TTL expiry inside a window
+//	0 3 * * 1-5                5 field cron, executed once at the first scrape after each fire time
+//
+// Windows may wrap midnight, e.g. 22:00-02:00. Cron fields are minute, hour,
+// day of month, month and day of week (0 or 7 is sunday) with `*`, lists,
+// ranges and steps. A failed execution is retried at next scrape instead of
+// waiting for the next fire time or TTL expiry. Replanning and reloading carry
+// the cached result over to the new collector of an unchanged query, so a
+// cron query only emits nothing until it fires once after it is first planned
+// without a result persisted in --cache.dir.
+//
+// `jitter` delays TTL expiry and shifts the schedule by a random duration up to
+// given seconds, drawn once per collector, so exporters of a fleet do not all
+// run the same expensive query at the same moment.
+
+// maxCronScan bounds minutes scanned for cron fire times between two scrapes
+const maxCronScan = 366 * 24 * 60
+
+// schedule is a parsed time window list or cron expression
+type schedule struct {
+	windows []scheduleWindow // time windows, empty for cron
+	cron    *cronSpec        // cron expression, nil for windows
+}
+
+// scheduleWindow is a daily window in minutes since midnight, end excluded
+type scheduleWindow struct {
+	start, end int
+}
+
+// cronSpec holds allowed values of each cron field
+type cronSpec struct {
+	minute, hour, dom, month, dow map[int]bool
+	domAny, dowAny                bool // unrestricted day fields
+}
+
+// parseSchedule parses a time window list or a cron expression
+func parseSchedule(spec string) (*schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	if strings.Contains(spec, ":") {
+		var windows []scheduleWindow
+		for _, item := range strings.Split(spec, ",") {
+			window, err := parseScheduleWindow(strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			windows = append(windows, window)
+		}
+		return &schedule{windows: windows}, nil
+	}
+	cron, err := parseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule{cron: cron}, nil
+}
+
+// parseScheduleWindow parses a `HH:MM-HH:MM` window
+func parseScheduleWindow(item string) (scheduleWindow, error) {
+	from, to, found := strings.Cut(item, "-")
+	if !found {
+		return scheduleWindow{}, fmt.Errorf("invalid time window %q, expect HH:MM-HH:MM", item)
+	}
+	start, err := parseClockMinute(strings.TrimSpace(from))
+	if err != nil {
+		return scheduleWindow{}, fmt.Errorf("invalid time window %q: %w", item, err)
+	}
+	end, err := parseClockMinute(strings.TrimSpace(to))
+	if err != nil {
+		return scheduleWindow{}, fmt.Errorf("invalid time window %q: %w", item, err)
+	}
+	if start == end {
+		return scheduleWindow{}, fmt.Errorf("time window %q is empty", item)
+	}
+	return scheduleWindow{start: start, end: end}, nil
+}
+
+// parseClockMinute parses `HH:MM` into minutes since midnight, 24:00 is allowed as end of day
+func parseClockMinute(text string) (int, error) {
+	h, m, found := strings.Cut(text, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !found || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", text)
+	}
+	return hour*60 + minute, nil
+}
+
+// parseCron parses a 5 field cron expression
+func parseCron(spec string) (*cronSpec, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields: minute hour day-of-month month day-of-week", spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var values [5]map[int]bool
+	for i, field := range fields {
+		allowed, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", spec, err)
+		}
+		values[i] = allowed
+	}
+	if values[4][7] { // 7 is sunday as well as 0
+		values[4][0] = true
+	}
+	return &cronSpec{
+		minute: values[0], hour: values[1], dom: values[2], month: values[3], dow: values[4],
+		domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField parses a comma list of `*`, `a`, `a-b` with optional `/step`
+func parseCronField(field string, lo, hi int) (map[int]bool, error) {
+	allowed := make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", item)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return nil, fmt.Errorf("invalid value in %q", item)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return nil, fmt.Errorf("invalid value in %q", item)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return nil, fmt.Errorf("value %q out of range %d-%d", item, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			allowed[v] = true
+		}
+	}
+	return allowed, nil
+}
+
+// matches reports whether cron fires at the minute of t. As in cron, a day
+// matches either day field when both are restricted
+func (c *cronSpec) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// inWindow reports whether t is inside any time window
+func (s *schedule) inWindow(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range s.windows {
+		if w.start < w.end && minute >= w.start && minute < w.end {
+			return true
+		}
+		if w.start > w.end && (minute >= w.start || minute < w.end) { // wraps midnight
+			return true
+		}
+	}
+	return false
+}
+
+// firedBetween reports whether cron fires at any minute in (from, to]
+func (s *schedule) firedBetween(from, to time.Time) bool {
+	t := from.Truncate(time.Minute).Add(time.Minute)
+	if limit := to.Add(-maxCronScan * time.Minute); t.Before(limit) {
+		t = limit.Truncate(time.Minute)
+	}
+	for ; !t.After(to); t = t.Add(time.Minute) {
+		if s.cron.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshDue reports whether cached result is refreshed by a real execution
+// at current scrape time, which is shifted by jitter for schedules. Windows
+// allow executions on TTL expiry, cron allows one execution per fire time.
+func (q *Collector) refreshDue() bool {
+	if q.schedule == nil {
+		return q.cacheExpired() || q.Server.DisableCache
+	}
+	now := q.Server.scrapeBegin.Add(-q.jitter)
+	if q.schedule.cron == nil {
+		return q.schedule.inWindow(now) && (q.cacheExpired() || q.Server.DisableCache)
+	}
+	since := q.lastScrape
+	if since.IsZero() {
+		since = q.plannedAt
+	}
+	return q.schedule.firedBetween(since.Add(-q.jitter), now)
+}
+
+// carryOver takes the cached result and last execution time of a collector of
+// the same query and server from previous plan, if it is newer than the result
+// restored from disk. Only scheduled collectors carry over, as others execute
+// once their cache expires anyway.
+func (q *Collector) carryOver(previous []*Collector) {
+	if q.schedule == nil {
+		return
+	}
+	for _, old := range previous {
+		if old.Branch != q.Branch || old.key != q.key {
+			continue
+		}
+		old.lock.RLock()
+		if old.lastScrape.After(q.lastScrape) {
+			// executions reuse the backing array of result, so it is copied
+			q.result, q.lastScrape, q.persistedAt = slices.Clone(old.result), old.lastScrape, old.persistedAt
+		}
+		old.lock.RUnlock()
+	}
+}
+
+// JitterDuration returns jitter of this query as time.Duration
+func (q *Query) JitterDuration() time.Duration {
+	return time.Duration(q.Jitter * float64(time.Second))
+}
+
+// drawJitter returns a random duration up to jitter of this query
+func (q *Query) drawJitter() time.Duration {
+	jitter := q.JitterDuration()
+	if jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(jitter)))
+}
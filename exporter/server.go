@@ -6,7 +6,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -21,6 +23,18 @@ import (
 const connMaxLifeTime = 1 * time.Minute // close connection after 1 minute to avoid conn leak
 
 const pgSQLStateCannotConnectNow = "57P03"
+const pgSQLStateInsufficientPrivilege = "42501"
+const pgSQLStateUndefinedTable = "42P01"
+
+// query planning status, exposed as status label of query planned metrics
+const (
+	planStatusInstalled        = "installed"
+	planStatusIncompatible     = "incompatible"
+	planStatusPermissionDenied = "permission_denied"
+	planStatusUndefinedTable   = "undefined_table"
+	planStatusTemplateError    = "template_error"
+	planStatusUnchecked        = "unchecked" // installed, but plan check could not explain its main SQL
+)
 
 /* ================ Server ================ */
 
@@ -54,20 +68,37 @@ type Server struct {
 	Namespaces map[string]bool // all available schema in target cluster
 	Extensions map[string]bool // all available extension in target cluster
 
-	Tags            []string // server tags set by cli arg --tag
-	PgbouncerMode   bool     // indicate it is a pgbouncer server
-	DisableCache    bool     // force executing, ignoring caching policy
-	ExcludeDbnames  []string // if ExcludeDbnames is provided, Auto Database Discovery is enabled
-	Forked          bool     // is this a forked server ? (does not run cluster level query)
-	Planned         bool     // if false, server will trigger a plan before collect
-	MaxConn         int      // max connection for this server
-	ConnectTimeout  int      // connect timeout for this server in ms
-	ConnMaxLifetime int      // connection max lifetime for this server in seconds
+	ExtensionVersions map[string]string // installed extension versions, keyed by extension name
+	Settings          map[string]string // pg_settings values configured or referenced by query tags
+	Superuser         bool              // is current user a superuser
+	Roles             map[string]bool   // monitor roles and roles referenced by query tags that current user is member of
+	Functions         map[string]bool   // functions referenced by query tags, keyed by name and schema.name
+	Relations         map[string]bool   // relations referenced by query tags that exist on target database
+
+	Tags             []string // server tags set by cli arg --tag
+	PgbouncerMode    bool     // indicate it is a pgbouncer server
+	DisableCache     bool     // force executing, ignoring caching policy
+	ExcludeDbnames   []string // if ExcludeDbnames is provided, Auto Database Discovery is enabled
+	Forked           bool     // is this a forked server ? (does not run cluster level query)
+	Planned          bool     // if false, server will trigger a plan before collect
+	MaxConn          int      // max connection for this server
+	ConnectTimeout   int      // connect timeout for this server in ms
+	ConnMaxLifetime  int      // connection max lifetime for this server in seconds
+	PrecheckSettings []string // pg_settings gathered during precheck, set by cli arg --precheck-settings
+	PlanCheck        bool     // validate installed queries with EXPLAIN during planning
+	cacheDir         string   // dir persisting results of long ttl queries, set by cli arg --cache.dir
 
 	// query
 	Collectors []*Collector      // query collector instance (installed query)
+	retired    []*Collector      // collectors cleared by reload, carried over by next plan
 	queries    map[string]*Query // queries map, keys are config file top layer key
+	discarded  map[string]string // discarded query branch to reason, filled during planning
+	planStatus map[string]string // query branch to planning status, filled during planning
 	labels     prometheus.Labels // constant labels
+	relabel    RelabelRules      // exporter-wide metric relabel rules
+
+	lookups     map[string]*Lookup       // lookup definitions used by LABEL columns
+	lookupCache map[string]*lookupResult // cached lookup results, by lookup name
 
 	// internal stats
 	serverInit  time.Time // server init timestamp
@@ -83,6 +114,8 @@ type Server struct {
 	queryScrapeErrorCount         map[string]float64 // internal query metrics: times failed
 	queryScrapePredicateSkipCount map[string]float64 // internal query metrics: times skipped due to predicate
 	queryScrapeMetricCount        map[string]float64 // internal query metrics: number of metrics scraped
+	queryScrapeUnknownValueCount  map[string]float64 // internal query metrics: values outside STATESET states or mapping
+	queryScrapeSeriesDropped      map[string]float64 // internal query metrics: series dropped by max_series
 	queryScrapeDuration           map[string]float64 // internal query metrics: time spend on executing
 }
 
@@ -302,14 +335,15 @@ func PostgresPrecheck(s *Server) (err error) {
 	// get important metadata
 	var recovery bool
 	var datname, username string
-	var databases, namespaces, extensions []string
+	var databases, namespaces, extensions, extversions []string
 	precheckSQL := `SELECT current_catalog, current_user, pg_catalog.pg_is_in_recovery(),
 	(SELECT pg_catalog.array_agg(d.datname)::text[] AS databases FROM pg_catalog.pg_database d WHERE d.datallowconn AND NOT d.datistemplate),
 	(SELECT pg_catalog.array_agg(n.nspname)::text[] AS namespaces FROM pg_catalog.pg_namespace n),
-	(SELECT pg_catalog.array_agg(e.extname)::text[] AS extensions FROM pg_catalog.pg_extension e);`
+	(SELECT pg_catalog.array_agg(e.extname ORDER BY e.extname)::text[] AS extensions FROM pg_catalog.pg_extension e),
+	(SELECT pg_catalog.array_agg(e.extversion ORDER BY e.extname)::text[] AS extversions FROM pg_catalog.pg_extension e);`
 	ctx2, cancel2 := context.WithTimeout(context.Background(), s.GetConnectTimeout())
 	defer cancel2()
-	if err = s.DB.QueryRowContext(ctx2, precheckSQL).Scan(&datname, &username, &recovery, pq.Array(&databases), pq.Array(&namespaces), pq.Array(&extensions)); err != nil {
+	if err = s.DB.QueryRowContext(ctx2, precheckSQL).Scan(&datname, &username, &recovery, pq.Array(&databases), pq.Array(&namespaces), pq.Array(&extensions), pq.Array(&extversions)); err != nil {
 		s.UP = false
 		return fmt.Errorf("fail fetching server version: %w", err)
 	}
@@ -332,9 +366,21 @@ func PostgresPrecheck(s *Server) (err error) {
 		s.Namespaces[nsname] = true
 	}
 	s.Extensions = make(map[string]bool, len(extensions))
-	for _, extname := range extensions {
+	extVersions := make(map[string]string, len(extensions))
+	for i, extname := range extensions {
 		s.Extensions[extname] = true
+		if i < len(extversions) {
+			extVersions[extname] = extversions[i]
+		}
+	}
+	if s.ExtensionVersions != nil && !maps.Equal(s.ExtensionVersions, extVersions) {
+		logInfof("server [%s] extensions changed: from %v to %v", s.Name(), s.ExtensionVersions, extVersions)
+		s.Planned = false
 	}
+	s.ExtensionVersions = extVersions
+
+	// gather planning facts: superuser, roles, settings, and objects referenced by query tags
+	s.refreshPlanFacts()
 
 	// detect db change
 	s.dblistLock.Lock()
@@ -365,6 +411,99 @@ func PostgresPrecheck(s *Server) (err error) {
 	return nil
 }
 
+// monitorRoles are predefined roles whose membership is always gathered during precheck
+var monitorRoles = []string{"pg_monitor", "pg_read_all_stats", "pg_read_all_settings"}
+
+// refreshPlanFacts gathers planning facts, a failure keeps previous facts, as
+// optional planning metadata should never mark a reachable server down
+func (s *Server) refreshPlanFacts() {
+	if err := s.gatherPlanFacts(); err != nil {
+		logWarnf("server [%s] keeps previous planning facts: %s", s.Name(), err.Error())
+	}
+}
+
+// gatherPlanFacts fetches superuser flag, monitor role memberships, configured
+// settings, and the settings, roles, functions and relations referenced by
+// query tags and SQL templates. A change of these facts triggers a new planning.
+func (s *Server) gatherPlanFacts() error {
+	req := collectTagFactRequirements(s.queries)
+	req.Settings = mergeNames(s.PrecheckSettings, req.Settings)
+	req.Roles = mergeNames(monitorRoles, req.Roles)
+
+	var superuser bool
+	settings := make(map[string]string, len(req.Settings))
+	roles := make(map[string]bool, len(req.Roles))
+	functions := make(map[string]bool, len(req.Functions))
+	relations := make(map[string]bool, len(req.Relations))
+	parts := []string{`COALESCE((SELECT r.rolsuper FROM pg_catalog.pg_roles r WHERE r.rolname = current_user), false)`}
+	dest := []interface{}{&superuser}
+	var args []interface{}
+	var settingList, roleList, functionList, relationList []string
+	add := func(names []string, sqlTemplate string, result *[]string) {
+		if len(names) == 0 {
+			return
+		}
+		args = append(args, pq.Array(names))
+		parts = append(parts, fmt.Sprintf(sqlTemplate, len(args)))
+		dest = append(dest, pq.Array(result))
+	}
+	add(req.Settings, `(SELECT pg_catalog.array_agg(s.name || '=' || s.setting)::text[] FROM pg_catalog.pg_settings s WHERE s.name = ANY($%d::text[]))`, &settingList)
+	add(req.Roles, `(SELECT pg_catalog.array_agg(r.rolname)::text[] FROM pg_catalog.pg_roles r WHERE r.rolname = ANY($%d::text[]) AND pg_catalog.pg_has_role(current_user, r.oid, 'MEMBER'))`, &roleList)
+	add(req.Functions, `(SELECT pg_catalog.array_agg(DISTINCT n.nspname || '.' || p.proname)::text[] FROM pg_catalog.pg_proc p JOIN pg_catalog.pg_namespace n ON n.oid = p.pronamespace WHERE p.proname = ANY($%d::text[]))`, &functionList)
+	// relations are looked up in pg_class instead of to_regclass, which takes text since 9.6 only
+	add(req.Relations, `(SELECT pg_catalog.array_agg(r)::text[] FROM pg_catalog.unnest($%d::text[]) r WHERE EXISTS (SELECT 1 FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace WHERE CASE WHEN pg_catalog.strpos(r, '.') > 0 THEN n.nspname = pg_catalog.split_part(r, '.', 1) AND c.relname = pg_catalog.split_part(r, '.', 2) ELSE c.relname = r AND pg_catalog.pg_table_is_visible(c.oid) END))`, &relationList)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.GetConnectTimeout())
+	defer cancel()
+	if err := s.DB.QueryRowContext(ctx, "SELECT "+strings.Join(parts, ", ")+";", args...).Scan(dest...); err != nil {
+		return fmt.Errorf("fail fetching planning facts: %w", err)
+	}
+	for _, kv := range settingList {
+		if name, value, found := strings.Cut(kv, "="); found {
+			settings[name] = value
+		}
+	}
+	for _, rolname := range req.Roles {
+		roles[rolname] = false
+	}
+	for _, rolname := range roleList {
+		roles[rolname] = true
+	}
+	for _, qualified := range functionList {
+		functions[qualified] = true
+		if _, proname, found := strings.Cut(qualified, "."); found {
+			functions[proname] = true
+		}
+	}
+	for _, relname := range relationList {
+		relations[relname] = true
+	}
+
+	if s.Superuser != superuser || !maps.Equal(s.Settings, settings) || !maps.Equal(s.Roles, roles) ||
+		!maps.Equal(s.Functions, functions) || !maps.Equal(s.Relations, relations) {
+		if s.Settings != nil { // first gathering is not a change
+			logInfof("server [%s] planning facts changed: superuser=%v settings=%v roles=%v functions=%v relations=%v",
+				s.Name(), superuser, settings, roles, functions, relations)
+		}
+		s.Planned = false
+	}
+	s.Superuser, s.Settings, s.Roles, s.Functions, s.Relations = superuser, settings, roles, functions, relations
+	return nil
+}
+
+// mergeNames appends names of b that are absent in a, keeping order
+func mergeNames(a, b []string) []string {
+	res := make([]string, 0, len(a)+len(b))
+	seen := make(map[string]bool, len(a)+len(b))
+	for _, name := range append(append([]string{}, a...), b...) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
 // Plan will install queries that compatible with server fact (version, level, recovery, plugin, tags,...)
 func (s *Server) Plan(queries ...*Query) {
 	// if queries are explicitly given, use it instead of server.queries
@@ -377,23 +516,51 @@ func (s *Server) Plan(queries ...*Query) {
 	}
 
 	// check query compatibility
+	previous := slices.Concat(s.Collectors, s.retired)
+	s.retired = nil
 	instances := make([]*Collector, 0)
 	var installedNames, discardedNames []string
+	discarded := make(map[string]string)
+	planStatus := make(map[string]string, len(s.queries))
 	for name, query := range s.queries {
-		if ok, reason := s.Compatible(query); ok {
-			instances = append(instances, NewCollector(query, s))
+		status := planStatusInstalled
+		ok, reason := s.Compatible(query)
+		if !ok {
+			status = planStatusIncompatible
+		} else if rendered, err := s.renderQuery(query); err != nil {
+			status, reason = planStatusTemplateError, err.Error()
+		} else {
+			query = rendered // collectors run SQL rendered against current facts
+			if s.PlanCheck {
+				status, reason = s.CheckPrivilege(query)
+			}
+		}
+		planStatus[query.Branch] = status
+		if status == planStatusInstalled || status == planStatusUnchecked {
+			if status == planStatusUnchecked {
+				logInfof("query [%s].%s installed unchecked: %s", query.Name, name, reason)
+			}
+			instance := NewCollector(query, s)
+			instance.carryOver(previous)
+			instances = append(instances, instance)
 			installedNames = append(installedNames, query.Branch)
 		} else {
 			discardedNames = append(discardedNames, query.Branch)
+			discarded[query.Branch] = reason
 			logDebugf("query [%s].%s discarded because of %s", query.Name, name, reason)
 		}
 	}
+	s.discarded = discarded
+	s.planStatus = planStatus
 
 	// sort by priority
 	sort.Slice(instances, func(i, j int) bool {
 		return instances[i].Priority < instances[j].Priority
 	})
 	s.Collectors = instances
+	if len(s.relabel) > 0 { // series renamed by relabel rules merge into families of other collectors
+		newRelabelFamilies(instances)
+	}
 
 	// reset statistics after planning
 	s.ResetStats()
@@ -402,6 +569,65 @@ func (s *Server) Plan(queries ...*Query) {
 		s.Name(), len(s.queries), len(installedNames), len(discardedNames), strings.Join(installedNames, ", "), strings.Join(discardedNames, ", "))
 }
 
+// CheckPrivilege runs the query wrapped in EXPLAIN on target server, which plans
+// the query without executing it. Queries failing with insufficient privilege
+// (42501) or undefined table (42P01) are reported with a discarding status.
+// Other errors (e.g. statements that could not be explained) keep the query installed.
+// The main SQL of a foreach query is explained with $n bound to the first row
+// of its driver, which is executed for that. Without a driver row, or if the
+// driver fails otherwise, the query is installed with status unchecked.
+func (s *Server) CheckPrivilege(query *Query) (status, reason string) {
+	if s.DB == nil || s.PgbouncerMode {
+		return planStatusInstalled, ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.GetConnectTimeout())
+	defer cancel()
+	collector := &Collector{Query: query, Server: s, boundForeach: compileNamedParams(query.Foreach)}
+	var driverRow []interface{}
+	if query.Foreach != "" {
+		driver, err := collector.executeForeach(ctx)
+		if err != nil {
+			if status, reason = s.privilegeStatus(query, err); status != planStatusInstalled {
+				return status, reason
+			}
+			return planStatusUnchecked, fmt.Sprintf("query %s main SQL is not checked on server [%s]: %s", query.Name, s.Name(), err.Error())
+		}
+		if len(driver.rows) == 0 {
+			return planStatusUnchecked, fmt.Sprintf("query %s main SQL is not checked on server [%s]: foreach driver returned no row", query.Name, s.Name())
+		}
+		driverRow = driver.rows[0]
+	}
+	// named parameters are bound as they are during collection, after driver columns
+	explained, args, err := collector.bind(compileNamedParams(query.SQL), len(driverRow))
+	var rows *sql.Rows
+	if err == nil {
+		args = append(append([]interface{}{}, driverRow...), args...)
+		rows, err = s.DB.QueryContext(ctx, "EXPLAIN "+strings.TrimRight(strings.TrimSpace(explained), "; \t\n"), args...)
+	}
+	if err == nil {
+		_ = rows.Close()
+		return planStatusInstalled, ""
+	}
+	if status, reason = s.privilegeStatus(query, err); status == planStatusInstalled {
+		logDebugf("query [%s] privilege check on server [%s] skipped: %s", query.Name, s.Name(), err.Error())
+	}
+	return status, reason
+}
+
+// privilegeStatus maps an error of plan check to a discarding status, other errors keep the query installed
+func (s *Server) privilegeStatus(query *Query, err error) (status, reason string) {
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) {
+		switch string(pgErr.Code) {
+		case pgSQLStateInsufficientPrivilege:
+			return planStatusPermissionDenied, fmt.Sprintf("user [%s] has no privilege to run query %s on server [%s]: %s", s.Username, query.Name, s.Name(), pgErr.Message)
+		case pgSQLStateUndefinedTable:
+			return planStatusUndefinedTable, fmt.Sprintf("query %s refers to undefined relation on server [%s]: %s", query.Name, s.Name(), pgErr.Message)
+		}
+	}
+	return planStatusInstalled, ""
+}
+
 // ResetStats will clear all statistic info
 func (s *Server) ResetStats() {
 	n := len(s.Collectors)
@@ -411,6 +637,8 @@ func (s *Server) ResetStats() {
 	s.queryScrapeErrorCount = make(map[string]float64, n)
 	s.queryScrapePredicateSkipCount = make(map[string]float64, n)
 	s.queryScrapeMetricCount = make(map[string]float64, n)
+	s.queryScrapeUnknownValueCount = make(map[string]float64, n)
+	s.queryScrapeSeriesDropped = make(map[string]float64, n)
 	s.queryScrapeDuration = make(map[string]float64, n)
 
 	for _, query := range s.Collectors {
@@ -422,6 +650,12 @@ func (s *Server) ResetStats() {
 			s.queryScrapePredicateSkipCount[query.Name] = 0
 		}
 		s.queryScrapeMetricCount[query.Name] = 0
+		if query.HasValueCheck() {
+			s.queryScrapeUnknownValueCount[query.Name] = 0
+		}
+		if query.MaxSeries > 0 {
+			s.queryScrapeSeriesDropped[query.Name] = 0
+		}
 		s.queryScrapeDuration[query.Name] = 0
 	}
 }
@@ -433,12 +667,10 @@ func (s *Server) Compatible(query *Query) (res bool, reason string) {
 		return false, fmt.Sprintf("query %s is marked skip", query.Name)
 	}
 
-	// check mode
-	if pgbouncerQuery := query.HasTag("pgbouncer"); pgbouncerQuery != s.PgbouncerMode {
-		if s.PgbouncerMode {
-			return false, fmt.Sprintf("pgbouncer server doese not match with normal postgres query %s", query.Name)
-		}
-		return false, fmt.Sprintf("pgbouncer query %s does not match with normal postgres server", query.Name)
+	// check mode, a pgbouncer server only runs queries whose tags refer to pgbouncer, and on
+	// a normal postgres server the pgbouncer atom is false within tag expressions
+	if s.PgbouncerMode && !query.refersTag("pgbouncer") {
+		return false, fmt.Sprintf("pgbouncer server doese not match with normal postgres query %s", query.Name)
 	}
 
 	// check version
@@ -451,72 +683,17 @@ func (s *Server) Compatible(query *Query) (res bool, reason string) {
 		}
 	}
 
-	// check query side tags
+	// check query side tags, each tag could be a single tag or a boolean tag expression
 	for _, tag := range query.Tags {
-		// check extension is installed on target database
-		if strings.HasPrefix(tag, "extension:") {
-			if _, found := s.Extensions[strings.TrimPrefix(tag, "extension:")]; !found {
-				return false, fmt.Sprintf("server [%s] does not have extension %s", s.Name(), tag)
-			}
-			continue
-		}
-
-		// check schema exist on target database
-		if strings.HasPrefix(tag, "schema:") {
-			if _, found := s.Namespaces[strings.TrimPrefix(tag, "schema:")]; !found {
-				return false, fmt.Sprintf("server [%s] does not have schema %s", s.Name(), tag)
-			}
-			continue
-		}
-
-		// check if dbname prefix tag match server.Database
-		if strings.HasPrefix(tag, "dbname:") {
-			if s.Database != strings.TrimPrefix(tag, "dbname:") {
-				return false, fmt.Sprintf("server [%s] dbname does %s not match with query tag %s", s.Name(), s.Database, tag)
-			}
-			continue
-		}
-
-		// check if username prefix tag match server.Username
-		if strings.HasPrefix(tag, "username:") {
-			if s.Username != strings.TrimPrefix(tag, "username:") {
-				return false, fmt.Sprintf("server [%s] username [%s] does not match %s", s.Name(), s.Username, tag)
-			}
-			continue
-		}
-
-		// check server does not have given tag
-		if strings.HasPrefix(tag, "not:") {
-			if negTag := strings.TrimPrefix(tag, "not:"); s.HasTag(negTag) {
-				return false, fmt.Sprintf("server [%s] has tag %s that query %s forbid", s.Name(), negTag, query.Name)
-			}
-			continue
+		expr, err := parseTag(tag)
+		if err != nil {
+			return false, fmt.Sprintf("query %s has invalid tag %q: %v", query.Name, tag, err)
 		}
-
-		// check 3 default tags: cluster, primary, standby|replica
-		switch tag {
-		case "cluster":
-			if s.Forked {
-				return false, fmt.Sprintf("cluster level query %s will not run on forked server %v", query.Name, s.Name())
-			}
-			continue
-		case "primary", "master", "leader":
-			if s.Recovery {
-				return false, fmt.Sprintf("primary-only query %s will not run on standby server %v", query.Name, s.Name())
-			}
-			continue
-		case "standby", "replica", "slave":
-			if !s.Recovery {
-				return false, fmt.Sprintf("standby-only query %s will not run on primary server %v", query.Name, s.Name())
-			}
-			continue
-		case "pgbouncer":
-			continue
-		default:
-			// if this tag is nether a pre-defined tag nor a prefixed pattern tag, check whether server have that tag
-			if !s.HasTag(tag) {
-				return false, fmt.Sprintf("server [%s] does not have tag %s that query %s require", s.Name(), tag, query.Name)
+		if ok, reason := s.evalTag(query, expr); !ok {
+			if isTagExpression(tag) {
+				reason = fmt.Sprintf("tag expression %q is false: %s", tag, reason)
 			}
+			return false, reason
 		}
 	}
 	return true, ""
@@ -530,9 +707,56 @@ func (s *Server) Explain() string {
 	for _, i := range s.Collectors {
 		res = append(res, i.Explain())
 	}
+	if s.UP && !s.PgbouncerMode {
+		res = append(res, s.explainFacts())
+	}
+	if len(s.discarded) > 0 {
+		res = append(res, s.explainDiscarded())
+	}
 	return strings.Join(res, "\n")
 }
 
+// explainFacts lists server facts used for planning
+func (s *Server) explainFacts() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("##\n# FACTS\n")
+	buf.WriteString(fmt.Sprintf("#       %-32s %d\n", "version", s.Version))
+	buf.WriteString(fmt.Sprintf("#       %-32s %v\n", "recovery", s.Recovery))
+	buf.WriteString(fmt.Sprintf("#       %-32s %s\n", "database", s.Database))
+	buf.WriteString(fmt.Sprintf("#       %-32s %s\n", "username", s.Username))
+	buf.WriteString(fmt.Sprintf("#       %-32s %v\n", "superuser", s.Superuser))
+	for _, name := range sortedKeys(s.Roles) {
+		buf.WriteString(fmt.Sprintf("#       %-32s %v\n", "role:"+name, s.Roles[name]))
+	}
+	for _, name := range sortedKeys(s.ExtensionVersions) {
+		buf.WriteString(fmt.Sprintf("#       %-32s %s\n", "extension:"+name, s.ExtensionVersions[name]))
+	}
+	for _, name := range sortedKeys(s.Settings) {
+		buf.WriteString(fmt.Sprintf("#       %-32s %s\n", "setting:"+name, s.Settings[name]))
+	}
+	return buf.String()
+}
+
+// sortedKeys returns sorted keys of a string keyed map
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// explainDiscarded lists discarded queries and the reason of discarding
+func (s *Server) explainDiscarded() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("##\n# DISCARDED\n")
+	for _, branch := range sortedKeys(s.discarded) {
+		buf.WriteString(fmt.Sprintf("#       %-32s %s\n", branch, s.discarded[branch]))
+	}
+	return buf.String()
+}
+
 // Stat will turn Server internal stats into HTML
 func (s *Server) Stat() string {
 	s.lock.RLock()
@@ -589,6 +813,7 @@ func (s *Server) Collect(ch chan<- prometheus.Metric) {
 	// fact change (including first time) will incur a plan procedure
 	if !s.Planned {
 		s.Plan()
+		s.removeStaleResults() // only live plans know the keys of running collectors
 	}
 
 	// First pass: execute all queries with Fatal flag
@@ -658,6 +883,13 @@ func (s *Server) executeQuery(query *Collector, ch chan<- prometheus.Metric) err
 
 	if query.CacheHit() {
 		s.queryScrapeHitCount[query.Name]++
+	} else {
+		if query.UnknownValues() > 0 {
+			s.queryScrapeUnknownValueCount[query.Name] += float64(query.UnknownValues())
+		}
+		if query.DroppedSeries() > 0 {
+			s.queryScrapeSeriesDropped[query.Name] += float64(query.DroppedSeries())
+		}
 	}
 
 	// Update predicate skip count if applicable
@@ -748,6 +980,20 @@ func WithQueries(queries map[string]*Query) ServerOpt {
 	}
 }
 
+// WithRelabel set exporter-wide metric relabel rules applied to query metrics
+func WithRelabel(rules RelabelRules) ServerOpt {
+	return func(s *Server) {
+		s.relabel = rules
+	}
+}
+
+// WithLookups set lookup definitions used by LABEL columns
+func WithLookups(lookups map[string]*Lookup) ServerOpt {
+	return func(s *Server) {
+		s.lookups = lookups
+	}
+}
+
 // WithServerTags will mark server only execute query without cluster tag
 func WithServerTags(tags []string) ServerOpt {
 	return func(s *Server) {
@@ -763,3 +1009,24 @@ func WithServerConnectTimeout(timeout int) ServerOpt {
 		s.ConnectTimeout = timeout
 	}
 }
+
+// WithServerPlanCheck will validate queries with EXPLAIN during planning
+func WithServerPlanCheck(planCheck bool) ServerOpt {
+	return func(s *Server) {
+		s.PlanCheck = planCheck
+	}
+}
+
+// WithServerCacheDir will persist results of long ttl queries in given dir
+func WithServerCacheDir(dir string) ServerOpt {
+	return func(s *Server) {
+		s.cacheDir = dir
+	}
+}
+
+// WithServerSettings will gather given pg_settings during server precheck
+func WithServerSettings(settings []string) ServerOpt {
+	return func(s *Server) {
+		s.PrecheckSettings = settings
+	}
+}
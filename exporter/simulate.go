@@ -0,0 +1,196 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ================ Plan Simulation ================ */
+
+// A simulation spec describes synthetic server facts as comma separated
+// key=value pairs. List values continue until the next key=value pair,
+// and list items may carry a value after a colon:
+//
+//	version=160004,recovery=true,extensions=pg_stat_statements,timescaledb:2.13.1,tags=foo
+//
+// supported keys: version, recovery, pgbouncer, forked, superuser, dbname,
+// username, extensions, schemas, tags, roles, settings, functions, relations
+
+// parseSimulationSpec splits a simulation spec into key and list values
+func parseSimulationSpec(spec string) (map[string][]string, error) {
+	res := make(map[string][]string)
+	var key string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		k, v, found := strings.Cut(part, "=")
+		if !found {
+			if key == "" {
+				return nil, fmt.Errorf("simulation spec item %q is not a key=value pair", part)
+			}
+			res[key] = append(res[key], part)
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(k))
+		if _, dup := res[key]; dup {
+			return nil, fmt.Errorf("simulation spec key %q is given more than once", key)
+		}
+		res[key] = nil
+		if v = strings.TrimSpace(v); v != "" {
+			res[key] = append(res[key], v)
+		}
+	}
+	return res, nil
+}
+
+// parseSimulatedVersion accepts server_version_num (160004) or major[.minor] (16.4, 16)
+func parseSimulatedVersion(version string) (int, error) {
+	major, minor, dotted := strings.Cut(version, ".")
+	m, err := strconv.Atoi(major)
+	if err != nil || m <= 0 {
+		return 0, fmt.Errorf("invalid version %q", version)
+	}
+	if !dotted {
+		if m < 100 { // major only
+			return m * 10000, nil
+		}
+		return m, nil
+	}
+	n, err := strconv.Atoi(minor)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid version %q", version)
+	}
+	if m < 10 { // 9.6 => 90600
+		return m*10000 + n*100, nil
+	}
+	return m*10000 + n, nil
+}
+
+// NewSimulatedServer builds a server with synthetic facts from a simulation spec.
+// The server has no connection, it is only used for planning.
+func NewSimulatedServer(spec string, queries map[string]*Query) (*Server, error) {
+	kv, err := parseSimulationSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	s := NewServer("postgresql:///simulation", WithQueries(queries))
+	s.UP = true
+	s.Database = "postgres"
+	s.Username = "postgres"
+	s.Namespaces = map[string]bool{"pg_catalog": true, "information_schema": true, "public": true}
+	s.Extensions = map[string]bool{}
+	s.ExtensionVersions = map[string]string{}
+	s.Settings = map[string]string{}
+	s.Roles = map[string]bool{}
+	s.Functions = map[string]bool{}
+	s.Relations = map[string]bool{}
+
+	single := func(key string, values []string) (string, error) {
+		if len(values) != 1 {
+			return "", fmt.Errorf("simulation spec key %q expects exactly one value, got %d", key, len(values))
+		}
+		return values[0], nil
+	}
+	boolean := func(key string, values []string) (bool, error) {
+		v, err := single(key, values)
+		if err != nil {
+			return false, err
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("simulation spec key %q expects a boolean: %w", key, err)
+		}
+		return b, nil
+	}
+
+	for key, values := range kv {
+		var v string
+		switch key {
+		case "version":
+			if v, err = single(key, values); err == nil {
+				s.Version, err = parseSimulatedVersion(v)
+			}
+		case "recovery":
+			s.Recovery, err = boolean(key, values)
+		case "pgbouncer":
+			s.PgbouncerMode, err = boolean(key, values)
+		case "forked":
+			s.Forked, err = boolean(key, values)
+		case "superuser":
+			s.Superuser, err = boolean(key, values)
+		case "dbname":
+			s.Database, err = single(key, values)
+		case "username":
+			s.Username, err = single(key, values)
+		case "tags":
+			s.Tags = values
+		case "schemas":
+			for _, nspname := range values {
+				s.Namespaces[nspname] = true
+			}
+		case "extensions":
+			for _, item := range values {
+				extname, extversion, _ := strings.Cut(item, ":")
+				s.Extensions[extname] = true
+				s.ExtensionVersions[extname] = extversion
+			}
+		case "roles":
+			for _, rolname := range values {
+				s.Roles[rolname] = true
+			}
+		case "settings":
+			for _, item := range values {
+				name, value, _ := strings.Cut(item, ":")
+				s.Settings[name] = value
+			}
+		case "functions":
+			for _, qualified := range values {
+				s.Functions[qualified] = true
+				if _, proname, found := strings.Cut(qualified, "."); found {
+					s.Functions[proname] = true
+				}
+			}
+		case "relations":
+			for _, relname := range values {
+				s.Relations[relname] = true
+			}
+		default:
+			return nil, fmt.Errorf("unknown simulation spec key %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.Databases[s.Database] = true
+	return s, nil
+}
+
+// ExplainPlan summarizes planning result: facts, installed and discarded queries
+func (s *Server) ExplainPlan() string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	buf := new(bytes.Buffer)
+	if s.PgbouncerMode {
+		buf.WriteString(fmt.Sprintf("##\n# FACTS\n#       %-32s %v\n#       %-32s %v\n", "pgbouncer", true, "version", s.Version))
+	} else {
+		buf.WriteString(s.explainFacts())
+	}
+	buf.WriteString("##\n# INSTALLED\n")
+	installed := make([]string, 0, len(s.Collectors))
+	for _, c := range s.Collectors {
+		installed = append(installed, fmt.Sprintf("#       %-32s %-32s priority=%d ttl=%v", c.Branch, c.Name, c.Priority, c.TTL))
+	}
+	sort.Strings(installed)
+	for _, line := range installed {
+		buf.WriteString(line + "\n")
+	}
+	if len(s.discarded) > 0 {
+		buf.WriteString(s.explainDiscarded())
+	}
+	return buf.String()
+}
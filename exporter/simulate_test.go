@@ -0,0 +1,89 @@
+package exporter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSimulationSpec(t *testing.T) {
+	got, err := parseSimulationSpec("version=160004, recovery=true,extensions=pg_stat_statements,timescaledb:2.13.1,tags=foo,bar,schemas=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"version":    {"160004"},
+		"recovery":   {"true"},
+		"extensions": {"pg_stat_statements", "timescaledb:2.13.1"},
+		"tags":       {"foo", "bar"},
+		"schemas":    nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseSimulationSpec = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"foo", "version=1,version=2"} {
+		if _, err := parseSimulationSpec(bad); err == nil {
+			t.Fatalf("parseSimulationSpec(%q) expected error", bad)
+		}
+	}
+}
+
+func TestParseSimulatedVersion(t *testing.T) {
+	tests := map[string]int{"160004": 160004, "16": 160000, "16.4": 160004, "9.6": 90600}
+	for input, want := range tests {
+		got, err := parseSimulatedVersion(input)
+		if err != nil || got != want {
+			t.Fatalf("parseSimulatedVersion(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "x", "16.x", "-1"} {
+		if _, err := parseSimulatedVersion(bad); err == nil {
+			t.Fatalf("parseSimulatedVersion(%q) expected error", bad)
+		}
+	}
+}
+
+func TestNewSimulatedServerPlan(t *testing.T) {
+	queries := map[string]*Query{
+		"pg_primary":  makeGaugeQuery("pg_primary", 1, "primary"),
+		"pg_replica":  makeGaugeQuery("pg_replica", 2, "replica"),
+		"pg_pgss":     makeGaugeQuery("pg_pgss", 3, "extension:pg_stat_statements"),
+		"pg_tsdb":     makeGaugeQuery("pg_tsdb", 4, "extension:timescaledb>=2.10"),
+		"pg_foo":      makeGaugeQuery("pg_foo", 5, "foo"),
+		"pg_new":      makeGaugeQuery("pg_new", 6),
+		"pg_bouncer":  makeGaugeQuery("pg_bouncer", 7, "pgbouncer"),
+		"pg_settings": makeGaugeQuery("pg_settings", 8, "setting:track_io_timing=on"),
+	}
+	queries["pg_new"].MinVersion = 170000
+
+	s, err := NewSimulatedServer("version=16.4,recovery=true,extensions=pg_stat_statements,timescaledb:2.13.1,tags=foo,settings=track_io_timing:on", queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DB != nil || !s.Recovery || s.Version != 160004 {
+		t.Fatalf("unexpected simulated server facts: db=%v recovery=%v version=%d", s.DB, s.Recovery, s.Version)
+	}
+	s.Plan()
+	installed := make([]string, 0)
+	for _, c := range s.Collectors {
+		installed = append(installed, c.Branch)
+	}
+	want := []string{"pg_replica", "pg_pgss", "pg_tsdb", "pg_foo", "pg_settings"}
+	if !reflect.DeepEqual(installed, want) {
+		t.Fatalf("installed = %v, want %v", installed, want)
+	}
+
+	plan := s.ExplainPlan()
+	for _, text := range []string{"# INSTALLED", "# DISCARDED", "primary-only query pg_primary", "lower than query min version 170000", "pgbouncer query pg_bouncer"} {
+		if !strings.Contains(plan, text) {
+			t.Fatalf("plan missing %q:\n%s", text, plan)
+		}
+	}
+
+	for _, bad := range []string{"version=abc", "recovery=maybe", "color=blue", "dbname=a,b"} {
+		if _, err := NewSimulatedServer(bad, queries); err == nil {
+			t.Fatalf("NewSimulatedServer(%q) expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	texttmpl "text/template"
+	"text/template/parse"
+)
+
+/* ================ SQL Template ================ */
+
+// SQL of queries, predicate queries and foreach drivers containing `{{` is a
+// Go text/template, rendered against server facts each time a server plans its
+// queries, so one query can cover several versions instead of duplicated
+// min_version/max_version branches:
+//
+//	{{ if ge .Version 170000 }}...{{ else }}...{{ end }}
+//	{{ if .HasExtension "pg_stat_statements" }}...{{ end }}
+//	{{ .Setting "block_size" }}
+//
+// Facts are methods of sqlTemplateFacts. Settings, roles, functions and
+// relations referenced with string literals are gathered during precheck like
+// those of query tags. A template failing to parse fails config loading, and one
+// failing to render discards the query with planning status template_error.
+// Write `{{ "{{" }}` for a literal `{{`.
+
+// sqlTemplateFacts exposes server facts to SQL templates
+type sqlTemplateFacts struct {
+	s *Server
+}
+
+// Version returns server version number, e.g. 170002
+func (f sqlTemplateFacts) Version() int { return f.s.Version }
+
+// Database returns current database name
+func (f sqlTemplateFacts) Database() string { return f.s.Database }
+
+// Username returns current user name
+func (f sqlTemplateFacts) Username() string { return f.s.Username }
+
+// Recovery reports whether server is in recovery
+func (f sqlTemplateFacts) Recovery() bool { return f.s.Recovery }
+
+// Superuser reports whether current user is a superuser
+func (f sqlTemplateFacts) Superuser() bool { return f.s.Superuser }
+
+// HasTag reports whether server has tag set by --tag
+func (f sqlTemplateFacts) HasTag(tag string) bool { return f.s.HasTag(tag) }
+
+// HasExtension reports whether extension is installed in current database
+func (f sqlTemplateFacts) HasExtension(name string) bool { return f.s.Extensions[name] }
+
+// ExtensionVersion returns installed version of extension, empty if absent
+func (f sqlTemplateFacts) ExtensionVersion(name string) string { return f.s.ExtensionVersions[name] }
+
+// HasSchema reports whether schema exists in current database
+func (f sqlTemplateFacts) HasSchema(name string) bool { return f.s.Namespaces[name] }
+
+// HasRole reports whether current user is a member of role
+func (f sqlTemplateFacts) HasRole(name string) bool { return f.s.Roles[name] }
+
+// HasFunction reports whether function exists, optionally schema qualified
+func (f sqlTemplateFacts) HasFunction(name string) bool { return f.s.Functions[name] }
+
+// HasRelation reports whether relation exists in current database
+func (f sqlTemplateFacts) HasRelation(name string) bool { return f.s.Relations[name] }
+
+// Setting returns value of a pg_settings entry gathered during precheck
+func (f sqlTemplateFacts) Setting(name string) (string, error) {
+	value, found := f.s.Settings[name]
+	if !found {
+		return "", fmt.Errorf("server [%s] does not have setting %s", f.s.Name(), name)
+	}
+	return value, nil
+}
+
+// sqlTemplateFactPrefixes maps fact methods with a name argument to tag prefixes
+// of planning facts that have to be gathered during precheck
+var sqlTemplateFactPrefixes = map[string]string{
+	"Setting":     "setting",
+	"HasRole":     "role",
+	"HasFunction": "function",
+	"HasRelation": "relation",
+}
+
+// isSQLTemplate reports whether SQL is a template
+func isSQLTemplate(sql string) bool {
+	return strings.Contains(sql, "{{")
+}
+
+// parseSQLTemplate parses SQL as a template
+func parseSQLTemplate(sql string) (*texttmpl.Template, error) {
+	return texttmpl.New("sql").Option("missingkey=error").Parse(sql)
+}
+
+// HasTemplate reports whether SQL, foreach or any predicate SQL of this query is a template
+func (q *Query) HasTemplate() bool {
+	if isSQLTemplate(q.SQL) || isSQLTemplate(q.Foreach) {
+		return true
+	}
+	for _, pq := range q.PredicateQueries {
+		if isSQLTemplate(pq.SQL) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTemplates parses SQL, foreach and predicate SQL templates of this
+// query, and checks relation names they refer to
+func (q *Query) validateTemplates() error {
+	parse := func(sql string) error {
+		if !isSQLTemplate(sql) {
+			return nil
+		}
+		if _, err := parseSQLTemplate(sql); err != nil {
+			return err
+		}
+		var err error
+		walkSQLTemplateFacts(sql, func(prefix, name string) {
+			if prefix == "relation" && !validRelationName(name) && err == nil {
+				err = fmt.Errorf("invalid relation name %q, want name or schema.name", name)
+			}
+		})
+		return err
+	}
+	if err := parse(q.SQL); err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+	if err := parse(q.Foreach); err != nil {
+		return fmt.Errorf("foreach template: %w", err)
+	}
+	for i, pq := range q.PredicateQueries {
+		if err := parse(pq.SQL); err != nil {
+			return fmt.Errorf("predicate_queries[%d] template: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// walkSQLTemplateFacts calls fn with tag prefix and name of each planning fact
+// referenced by a string literal in SQL template, e.g. `.Setting "block_size"`
+func walkSQLTemplateFacts(sql string, fn func(prefix, name string)) {
+	if !isSQLTemplate(sql) {
+		return
+	}
+	tmpl, err := parseSQLTemplate(sql)
+	if err != nil || tmpl.Tree == nil {
+		return // broken templates are rejected while loading config
+	}
+	var walk func(node parse.Node)
+	walkBranch := func(n *parse.BranchNode) {
+		walk(n.Pipe)
+		walk(n.List)
+		if n.ElseList != nil {
+			walk(n.ElseList)
+		}
+	}
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walkBranch(&n.BranchNode)
+		case *parse.RangeNode:
+			walkBranch(&n.BranchNode)
+		case *parse.WithNode:
+			walkBranch(&n.BranchNode)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			if field, ok := n.Args[0].(*parse.FieldNode); ok && len(field.Ident) == 1 {
+				if prefix, found := sqlTemplateFactPrefixes[field.Ident[0]]; found {
+					for _, arg := range n.Args[1:] {
+						if s, ok := arg.(*parse.StringNode); ok {
+							fn(prefix, s.Text)
+						}
+					}
+				}
+			}
+			for _, arg := range n.Args {
+				if pipe, ok := arg.(*parse.PipeNode); ok {
+					walk(pipe)
+				}
+			}
+		}
+	}
+	walk(tmpl.Tree.Root)
+}
+
+// renderSQL renders a SQL template against server facts, SQL without
+// template is returned as is
+func (s *Server) renderSQL(sql string) (string, error) {
+	if !isSQLTemplate(sql) {
+		return sql, nil
+	}
+	tmpl, err := parseSQLTemplate(sql)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, sqlTemplateFacts{s: s}); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(buf.String()) == "" {
+		return "", fmt.Errorf("rendered to empty SQL")
+	}
+	return buf.String(), nil
+}
+
+// renderQuery returns a copy of query with SQL templates rendered against
+// server facts, or the query itself if it has no template
+func (s *Server) renderQuery(query *Query) (*Query, error) {
+	if !query.HasTemplate() {
+		return query, nil
+	}
+	rendered := *query
+	var err error
+	if rendered.SQL, err = s.renderSQL(query.SQL); err != nil {
+		return nil, fmt.Errorf("query %s template: %w", query.Name, err)
+	}
+	if query.Foreach != "" {
+		if rendered.Foreach, err = s.renderSQL(query.Foreach); err != nil {
+			return nil, fmt.Errorf("query %s foreach template: %w", query.Name, err)
+		}
+	}
+	rendered.PredicateQueries = make([]PredicateQuery, len(query.PredicateQueries))
+	for i, pq := range query.PredicateQueries {
+		if pq.SQL, err = s.renderSQL(pq.SQL); err != nil {
+			return nil, fmt.Errorf("query %s predicate_queries[%d] template: %w", query.Name, i, err)
+		}
+		rendered.PredicateQueries[i] = pq
+	}
+	return &rendered, nil
+}
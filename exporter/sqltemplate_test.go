@@ -0,0 +1,119 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderSQLTemplate(t *testing.T) {
+	s := newTagTestServer()
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{sql: "SELECT 1", want: "SELECT 1"},
+		{sql: "SELECT {{ if ge .Version 150000 }}new{{ else }}old{{ end }}", want: "SELECT new"},
+		{sql: `SELECT {{ if .HasExtension "pg_stat_statements" }}1{{ else }}0{{ end }}`, want: "SELECT 1"},
+		{sql: `SELECT {{ .Setting "max_connections" }}, '{{ .ExtensionVersion "timescaledb" }}'`, want: "SELECT 100, '2.13.1'"},
+		{sql: `SELECT {{ .HasRole "pg_monitor" }}, {{ .HasFunction "pg_ls_waldir" }}, {{ .HasRelation "public.missing" }}`, want: "SELECT true, true, false"},
+		{sql: `SELECT '{{ .Database }}', '{{ .Username }}', {{ .Recovery }}, {{ .HasTag "force" }}, {{ .HasSchema "public" }}`, want: "SELECT 'postgres', 'monitor', false, true, true"},
+		{sql: `SELECT '{{ "{{" }}1,2}}'::int[]`, want: "SELECT '{{1,2}}'::int[]"},
+	}
+	for _, tt := range tests {
+		got, err := s.renderSQL(tt.sql)
+		if err != nil || got != tt.want {
+			t.Errorf("renderSQL(%q) = %q, %v, want %q", tt.sql, got, err, tt.want)
+		}
+	}
+	for _, sql := range []string{
+		`SELECT {{ .Setting "block_size" }}`, // not gathered
+		"SELECT {{ .Unknown }}",
+		"SELECT {{ if .Recovery }}",
+		"{{ if .Recovery }}SELECT 1{{ end }}",
+	} {
+		if _, err := s.renderSQL(sql); err == nil {
+			t.Errorf("renderSQL(%q) should fail", sql)
+		}
+	}
+}
+
+func TestPlanRendersSQLTemplate(t *testing.T) {
+	s := newTagTestServer()
+	templated := makeGaugeQuery("templated", 1)
+	templated.SQL = "SELECT 'db' AS datname, {{ if ge .Version 170000 }}2{{ else }}1{{ end }} AS value"
+	templated.PredicateQueries = []PredicateQuery{{SQL: `SELECT {{ .HasExtension "pg_stat_statements" }}`}}
+	broken := makeGaugeQuery("broken", 2)
+	broken.SQL = `SELECT {{ .Setting "block_size" }} AS value`
+	s.queries = map[string]*Query{"templated": templated, "broken": broken}
+	s.Plan()
+
+	if len(s.Collectors) != 1 {
+		t.Fatalf("expected 1 collector, got %d", len(s.Collectors))
+	}
+	collector := s.Collectors[0]
+	if collector.SQL != "SELECT 'db' AS datname, 1 AS value" || collector.PredicateQueries[0].SQL != "SELECT true" {
+		t.Fatalf("collector should run rendered SQL, got %q and %q", collector.SQL, collector.PredicateQueries[0].SQL)
+	}
+	if !strings.Contains(templated.SQL, "{{") || !strings.Contains(templated.PredicateQueries[0].SQL, "{{") {
+		t.Fatal("rendering should not modify the query definition")
+	}
+	if s.planStatus["broken"] != planStatusTemplateError || !strings.Contains(s.discarded["broken"], "setting block_size") {
+		t.Fatalf("broken template should be discarded with reason, got %q %q", s.planStatus["broken"], s.discarded["broken"])
+	}
+
+	// planning again renders against new facts
+	s.Version = 170000
+	s.Plan()
+	if got := s.Collectors[0].SQL; got != "SELECT 'db' AS datname, 2 AS value" {
+		t.Fatalf("replanning should render against new facts, got %q", got)
+	}
+}
+
+func TestCollectSQLTemplateFactRequirements(t *testing.T) {
+	queries := map[string]*Query{
+		"a": {
+			SQL:              `SELECT {{ .Setting "block_size" }}{{ if and (.HasRole "app_reader") (.HasFunction "public.f") }}, 1{{ end }}`,
+			Foreach:          `SELECT {{ with .HasRelation "public.events" }}1{{ end }}`,
+			PredicateQueries: []PredicateQuery{{SQL: `SELECT {{ .Setting "wal_level" }} = 'logical'`}},
+		},
+	}
+	req := collectTagFactRequirements(queries)
+	want := &tagFactRequirements{
+		Settings:  []string{"block_size", "wal_level"},
+		Roles:     []string{"app_reader"},
+		Functions: []string{"f"},
+		Relations: []string{"public.events"},
+	}
+	if !reflect.DeepEqual(req, want) {
+		t.Fatalf("requirements = %+v, want %+v", req, want)
+	}
+}
+
+func TestParseConfigRejectsBrokenTemplate(t *testing.T) {
+	for name, sql := range map[string]string{
+		"query":     "  query: SELECT {{ if .Recovery }}1\n",
+		"foreach":   "  foreach: SELECT {{ .HasTag }\n  query: SELECT 1\n",
+		"predicate": "  query: SELECT 1\n  predicate_queries:\n    - predicate_query: SELECT {{ end }}\n",
+		"relation":  "  query: SELECT {{ if .HasRelation \"a.b.c\" }}1{{ end }}\n",
+	} {
+		config := "q:\n" + sql + "  metrics:\n    - v: { usage: GAUGE }\n"
+		if _, err := ParseConfig([]byte(config)); err == nil || !strings.Contains(err.Error(), "template") {
+			t.Errorf("broken %s template should fail config loading, got %v", name, err)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "q.sql"), []byte("SELECT {{ if .Recovery }}1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := filepath.Join(dir, "q.yml")
+	if err := os.WriteFile(cfgPath, []byte("q:\n  query_file: q.sql\n  metrics:\n    - v: { usage: GAUGE }\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(cfgPath); err == nil || !strings.Contains(err.Error(), `query "q" template`) {
+		t.Fatalf("broken template of query_file should fail config loading, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const stateSetConfig = `
+pg_activity:
+  query: SELECT datname, state, sync_state FROM test
+  metrics:
+    - datname:    { usage: LABEL }
+    - state:      { usage: STATESET, states: [ active, idle, "idle in transaction" ], description: backend state }
+    - sync_state: { usage: GAUGE, rename: sync_priority, default: -1, mapping: { async: 0, potential: 1, sync: 2, quorum: 3 } }
+`
+
+func TestParseConfigStateSet(t *testing.T) {
+	queries, err := ParseConfig([]byte(stateSetConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_activity"]
+	if !q.Columns["state"].IsStateSet() || q.Columns["state"].StateLabel() != "state" || !q.HasValueCheck() {
+		t.Fatalf("unexpected stateset column: %+v", q.Columns["state"])
+	}
+	metrics := q.MetricList()
+	if metrics[0].Name != "pg_activity_state{datname,state}" || metrics[1].Name != "pg_activity_sync_priority{datname}" {
+		t.Fatalf("unexpected metric list: %s, %s", metrics[0].Name, metrics[1].Name)
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "States [active idle idle in transaction]") || !strings.Contains(explain, "Mapping map[async:0") {
+		t.Fatalf("explain should render states and mapping:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"no states":         `{ usage: STATESET }`,
+		"duplicate state":   `{ usage: STATESET, states: [ a, a ] }`,
+		"empty state":       `{ usage: STATESET, states: [ "" ] }`,
+		"states on gauge":   `{ usage: GAUGE, states: [ a ] }`,
+		"mapping on label":  `{ usage: LABEL, mapping: { a: 1 } }`,
+		"stateset mapping":  `{ usage: STATESET, states: [ a ], mapping: { a: 1 } }`,
+		"non-finite map":    `{ usage: GAUGE, mapping: { a: .inf } }`,
+		"label conflict":    `{ usage: STATESET, rename: datname, states: [ a ] }`,
+		"invalid state lbl": `{ usage: STATESET, rename: "__state", states: [ a ] }`,
+	}
+	for name, column := range tests {
+		content := "q:\n  query: SELECT 1\n  metrics:\n    - datname: { usage: LABEL }\n    - v: " + column + "\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCollectorStateSetAndMapping(t *testing.T) {
+	queries, err := ParseConfig([]byte(stateSetConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"app", "active", "sync"},
+		{"meta", "idle", nil},
+		{"misc", "disabled", "unknown"},
+		{"null", nil, "async"},
+	}
+	collector := newFakeCollector(t, queries["pg_activity"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "state", "sync_state"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute stateset query: %v", err)
+	}
+	if got := collector.UnknownValues(); got != 2 {
+		t.Fatalf("unknown values = %d, want 2", got)
+	}
+	// 3 rows with states x 3 states + 4 mapped values
+	if got := collector.ResultSize(); got != 13 {
+		t.Fatalf("result size = %d, want 13", got)
+	}
+
+	samples, families := gatherSamples(t, collector)
+	if help := families["pg_activity_state"].GetHelp(); help != "backend state" {
+		t.Fatalf("stateset help = %q", help)
+	}
+	lbl := func(datname, state string) map[string]string {
+		return map[string]string{"cluster": "c1", "datname": datname, "state": state}
+	}
+	requireSample(t, samples, "pg_activity_state", lbl("app", "active"), 1)
+	requireSample(t, samples, "pg_activity_state", lbl("app", "idle"), 0)
+	requireSample(t, samples, "pg_activity_state", lbl("app", "idle in transaction"), 0)
+	requireSample(t, samples, "pg_activity_state", lbl("meta", "idle"), 1)
+	requireSample(t, samples, "pg_activity_state", lbl("misc", "active"), 0)
+	requireSample(t, samples, "pg_activity_state", lbl("misc", "idle"), 0)
+	if _, found := samples[sampleKey("pg_activity_state", lbl("null", "active"))]; found {
+		t.Fatal("NULL stateset value should be absent")
+	}
+
+	mapped := func(datname string) float64 {
+		return samples[sampleKey("pg_activity_sync_priority", map[string]string{"cluster": "c1", "datname": datname})]
+	}
+	if mapped("app") != 2 || mapped("meta") != -1 || mapped("null") != 0 || !math.IsNaN(mapped("misc")) {
+		t.Fatalf("unexpected mapped values: app=%v meta=%v null=%v misc=%v", mapped("app"), mapped("meta"), mapped("null"), mapped("misc"))
+	}
+}
+
+func TestServerCountsUnknownValues(t *testing.T) {
+	queries, err := ParseConfig([]byte(stateSetConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector := newFakeCollector(t, queries["pg_activity"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "state", "sync_state"}, values: [][]driver.Value{{"app", "starting", "sync"}}}
+	}, nil)
+	s := collector.Server
+	s.Collectors = []*Collector{collector}
+	s.ResetStats()
+	if v, found := s.queryScrapeUnknownValueCount["pg_activity"]; !found || v != 0 {
+		t.Fatalf("unknown value counter should be initialized, got %v %v", v, found)
+	}
+
+	ch := make(chan prometheus.Metric, 16)
+	for i := 0; i < 2; i++ {
+		s.scrapeBegin = time.Now()
+		collector.lastScrape = time.Time{}
+		if err := s.executeQuery(collector, ch); err != nil {
+			t.Fatal(err)
+		}
+		for len(ch) > 0 {
+			<-ch
+		}
+	}
+	if got := s.queryScrapeUnknownValueCount["pg_activity"]; got != 2 {
+		t.Fatalf("unknown value count = %v, want 2", got)
+	}
+}
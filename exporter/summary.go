@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+/* ================ Summary ================ */
+
+// A SUMMARY column is emitted as a summary family: <name>{quantile=...},
+// <name>_count and <name>_sum. Quantiles come from one of two sources:
+//
+//   - raw observations: each row is one observation, rows sharing a label tuple
+//     form one snapshot, and configured quantiles are computed by the exporter
+//     with linear interpolation, the same as percentile_cont in PostgreSQL.
+//   - computed in SQL: when `sum` is set, each row is one summary, the column
+//     itself is the observation count, `sum` names the sum column and
+//     `quantile_columns` name the columns holding each configured quantile.
+
+// summaryAccumulator holds one summary of a SUMMARY column and label tuple
+type summaryAccumulator struct {
+	column       *Column
+	labels       []string
+	observations []float64           // raw observations
+	quantiles    map[float64]float64 // quantiles computed in SQL
+	count        uint64
+	sum          float64
+}
+
+// summaryGroup returns the accumulator of a summary column and label tuple, creating it on demand
+func summaryGroup(summaries map[string]map[string]*summaryAccumulator, metricName string, column *Column, labels []string) *summaryAccumulator {
+	groups := summaries[metricName]
+	if groups == nil {
+		groups = make(map[string]*summaryAccumulator)
+		summaries[metricName] = groups
+	}
+	labelKey := encodeLabelTuple(labels)
+	accumulator := groups[labelKey]
+	if accumulator == nil {
+		accumulator = &summaryAccumulator{column: column, labels: append([]string(nil), labels...)}
+		groups[labelKey] = accumulator
+	}
+	return accumulator
+}
+
+// observeSummary adds one result row to a summary
+func (q *Collector) observeSummary(a *summaryAccumulator, colData []interface{}, columnIndexes map[string]int, dataIndex int) error {
+	column := a.column
+	if column.Sum == "" {
+		value, present, err := castHistogramFloat64(colData[dataIndex], column)
+		if err != nil || !present {
+			return err
+		}
+		a.observations = append(a.observations, value)
+		a.count++
+		a.sum += value
+		if math.IsNaN(a.sum) || math.IsInf(a.sum, 0) {
+			return fmt.Errorf("non-finite observation sum")
+		}
+		return nil
+	}
+
+	count, present, err := castHistogramCount(colData[dataIndex], column)
+	if err != nil || !present {
+		return err
+	}
+	if a.quantiles != nil {
+		return fmt.Errorf("duplicate summary rows for one label tuple")
+	}
+	sum, present, err := castHistogramFloat64(colData[columnIndexes[column.Sum]], q.Columns[column.Sum])
+	if err != nil {
+		return fmt.Errorf("sum: %w", err)
+	}
+	if !present && count > 0 {
+		return fmt.Errorf("missing sum of %d observations", count)
+	}
+	a.quantiles = make(map[float64]float64, len(column.Quantiles))
+	for i, quantile := range column.Quantiles {
+		value := math.NaN() // NULL quantile of an empty population
+		if raw := colData[columnIndexes[column.QuantileColumns[i]]]; raw != nil {
+			if value, _, err = castHistogramFloat64(raw, q.Columns[column.QuantileColumns[i]]); err != nil {
+				return fmt.Errorf("quantile %v: %w", quantile, err)
+			}
+		}
+		a.quantiles[quantile] = value
+	}
+	a.count, a.sum = count, sum
+	return nil
+}
+
+// summaryQuantiles computes configured quantiles of raw observations with
+// linear interpolation between closest ranks. An empty summary yields NaN.
+func summaryQuantiles(observations []float64, quantiles []float64) map[float64]float64 {
+	sorted := append([]float64(nil), observations...)
+	sort.Float64s(sorted)
+	result := make(map[float64]float64, len(quantiles))
+	for _, quantile := range quantiles {
+		if len(sorted) == 0 {
+			result[quantile] = math.NaN()
+			continue
+		}
+		rank := quantile * float64(len(sorted)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		result[quantile] = sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+	}
+	return result
+}
+
+// summaryMetric builds a summary metric from an accumulator, nil if dropped by relabel rules
+func (q *Collector) summaryMetric(metricName string, a *summaryAccumulator) (prometheus.Metric, error) {
+	desc, labels := q.relabelSeries(q.descriptors[metricName], dto.MetricType_SUMMARY, a.labels)
+	if desc == nil {
+		return nil, nil
+	}
+	quantiles := a.quantiles
+	if quantiles == nil {
+		quantiles = summaryQuantiles(a.observations, a.column.Quantiles)
+	}
+	return prometheus.NewConstSummary(desc, a.count, a.sum, quantiles, labels...)
+}
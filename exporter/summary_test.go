@@ -0,0 +1,169 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const summaryConfig = `
+pg_query:
+  query: SELECT datname, calls, total, p50, p99, duration FROM test
+  metrics:
+    - datname:  { usage: LABEL }
+    - calls:    { usage: SUMMARY, rename: exec_time, quantiles: [ 0.5, 0.99 ], sum: total, quantile_columns: [ p50, p99 ], description: execution time }
+    - total:    { usage: DISCARD, scale: 1e-3 }
+    - p50:      { usage: DISCARD, scale: 1e-3 }
+    - p99:      { usage: DISCARD, scale: 1e-3 }
+    - duration: { usage: SUMMARY, quantiles: [ 0, 0.5, 0.9, 1 ] }
+`
+
+func TestParseConfigSummary(t *testing.T) {
+	queries, err := ParseConfig([]byte(summaryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_query"]
+	if !q.HasSummary() || !q.Columns["calls"].IsSummary() || strings.Join(q.Columns["calls"].sourceColumns(), ",") != "total,p50,p99" {
+		t.Fatalf("unexpected summary column: %+v", q.Columns["calls"])
+	}
+	if q.Columns["duration"].sourceColumns() != nil {
+		t.Fatal("raw observation summary should read no source columns")
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "Quantiles [0.5 0.99] sum=total columns=[p50 p99]") {
+		t.Fatalf("explain should render summary quantiles:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"no quantiles":         `{ usage: SUMMARY }`,
+		"quantile over 1":      `{ usage: SUMMARY, quantiles: [ 1.5 ] }`,
+		"unordered quantiles":  `{ usage: SUMMARY, quantiles: [ 0.9, 0.5 ] }`,
+		"quantiles on gauge":   `{ usage: GAUGE, quantiles: [ 0.5 ] }`,
+		"columns without sum":  `{ usage: SUMMARY, quantiles: [ 0.5 ], quantile_columns: [ p ] }`,
+		"column count":         `{ usage: SUMMARY, quantiles: [ 0.5, 0.9 ], sum: s, quantile_columns: [ p ] }`,
+		"undefined quantile":   `{ usage: SUMMARY, quantiles: [ 0.5 ], sum: s, quantile_columns: [ x ] }`,
+		"count on summary":     `{ usage: SUMMARY, quantiles: [ 0.5 ], sum: s, quantile_columns: [ p ], count: s }`,
+		"bucket on summary":    `{ usage: SUMMARY, quantiles: [ 0.5 ], bucket: [ 1 ] }`,
+		"quantile_col on hist": `{ usage: HISTOGRAM, bucket: [ 1 ], quantile_columns: [ p ] }`,
+	}
+	for name, column := range tests {
+		content := "q:\n  query: SELECT 1\n  metrics:\n    - datname: { usage: LABEL }\n    - s: { usage: DISCARD }\n    - p: { usage: DISCARD }\n    - v: " + column + "\n"
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+	conflict := "q:\n  query: SELECT 1\n  metrics:\n    - quantile: { usage: LABEL }\n    - v: { usage: SUMMARY, quantiles: [ 0.5 ] }\n"
+	if _, err := ParseConfig([]byte(conflict)); err == nil {
+		t.Fatal("label quantile should conflict with summary quantile label")
+	}
+}
+
+func TestSummaryQuantiles(t *testing.T) {
+	got := summaryQuantiles([]float64{4, 1, 3, 2}, []float64{0, 0.5, 0.9, 1})
+	want := map[float64]float64{0: 1, 0.5: 2.5, 0.9: 3.7, 1: 4}
+	for quantile, value := range want {
+		if math.Abs(got[quantile]-value) > 1e-9 {
+			t.Fatalf("quantile %v = %v, want %v", quantile, got[quantile], value)
+		}
+	}
+	if empty := summaryQuantiles(nil, []float64{0.5}); !math.IsNaN(empty[0.5]) {
+		t.Fatalf("quantile of empty summary should be NaN, got %v", empty[0.5])
+	}
+}
+
+func TestCollectorSummary(t *testing.T) {
+	queries, err := ParseConfig([]byte(summaryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]driver.Value{
+		{"app", int64(10), 2000.0, 100.0, 900.0, 1.0},
+		{"meta", int64(0), nil, nil, nil, 4.0},
+		{"app", nil, nil, nil, nil, 3.0},
+		{"app", nil, nil, nil, nil, nil},
+		{"app", nil, nil, nil, nil, 2.0},
+	}
+	collector := newFakeCollector(t, queries["pg_query"], func() driver.Rows {
+		return &fakeRows{columns: []string{"datname", "calls", "total", "p50", "p99", "duration"}, values: values}
+	}, nil)
+	collector.scrapeBegin = time.Now()
+	collector.execute()
+	if err := collector.Error(); err != nil {
+		t.Fatalf("execute summary query: %v", err)
+	}
+	if got := collector.ResultSize(); got != 4 {
+		t.Fatalf("result size = %d, want 4 summaries", got)
+	}
+
+	collector.TTL = 3600
+	collector.Server.scrapeBegin = time.Now()
+	collector.lastScrape = collector.Server.scrapeBegin
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatalf("register collector: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather collector: %v", err)
+	}
+	summaries := make(map[string]*dto.Summary)
+	for _, family := range families {
+		if family.GetType() != dto.MetricType_SUMMARY {
+			t.Fatalf("family %s type = %s, want SUMMARY", family.GetName(), family.GetType())
+		}
+		for _, metric := range family.GetMetric() {
+			summaries[family.GetName()+"/"+labelValue(metric, "datname")] = metric.GetSummary()
+		}
+	}
+	quantile := func(s *dto.Summary, q float64) float64 {
+		for _, item := range s.GetQuantile() {
+			if item.GetQuantile() == q {
+				return item.GetValue()
+			}
+		}
+		t.Fatalf("missing quantile %v in %v", q, s)
+		return 0
+	}
+
+	app := summaries["pg_query_exec_time/app"]
+	if app.GetSampleCount() != 10 || app.GetSampleSum() != 2 || quantile(app, 0.5) != 0.1 || quantile(app, 0.99) != 0.9 {
+		t.Fatalf("unexpected SQL computed summary: %v", app)
+	}
+	meta := summaries["pg_query_exec_time/meta"]
+	if meta.GetSampleCount() != 0 || !math.IsNaN(quantile(meta, 0.5)) {
+		t.Fatalf("empty SQL computed summary should have NaN quantiles: %v", meta)
+	}
+	raw := summaries["pg_query_duration/app"]
+	if raw.GetSampleCount() != 3 || raw.GetSampleSum() != 6 || quantile(raw, 0) != 1 || quantile(raw, 0.5) != 2 || quantile(raw, 1) != 3 {
+		t.Fatalf("unexpected raw observation summary: %v", raw)
+	}
+}
+
+func TestCollectorSummaryInvalid(t *testing.T) {
+	queries, err := ParseConfig([]byte(summaryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][][]driver.Value{
+		"duplicate row":    {{"a", int64(1), 1.0, 1.0, 1.0, nil}, {"a", int64(1), 1.0, 1.0, 1.0, nil}},
+		"missing sum":      {{"a", int64(1), nil, 1.0, 1.0, nil}},
+		"negative count":   {{"a", int64(-1), 1.0, 1.0, 1.0, nil}},
+		"invalid quantile": {{"a", int64(1), 1.0, "x", 1.0, nil}},
+		"invalid raw":      {{"a", nil, nil, nil, nil, "NaN"}},
+	}
+	for name, values := range tests {
+		collector := newFakeCollector(t, queries["pg_query"], func() driver.Rows {
+			return &fakeRows{columns: []string{"datname", "calls", "total", "p50", "p99", "duration"}, values: values}
+		}, nil)
+		collector.scrapeBegin = time.Now()
+		collector.execute()
+		if collector.Error() == nil || collector.ResultSize() != 0 {
+			t.Fatalf("%s: expected execution error without result, got %v", name, collector.Error())
+		}
+	}
+}
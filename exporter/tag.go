@@ -0,0 +1,543 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* ================ Tag Expression ================ */
+
+// A query tag is either a single tag atom such as `primary` or
+// `extension:pg_stat_statements`, or a boolean expression that combines atoms
+// with `and`, `or`, `not` and parentheses:
+//
+//	(primary and extension:pg_stat_statements) or tag:force
+//
+// Entries of Query.Tags are still AND-ed together, so a flat tag list keeps
+// its original meaning.
+
+// tagExpr is a parsed tag expression node
+type tagExpr interface {
+	String() string
+}
+
+// tagAtom is a single tag, optionally with a prefix, a comparison operator and a value
+type tagAtom struct {
+	raw    string // original text of this atom
+	prefix string // extension, schema, dbname, username, not, tag, setting, role, function, relation or empty
+	name   string // tag name, or the object name of a prefixed tag
+	op     string // comparison operator for setting: and extension: atoms, empty if absent
+	value  string // comparison operand
+}
+
+type tagNot struct {
+	x tagExpr
+}
+
+type tagBinary struct {
+	op   string // and | or
+	l, r tagExpr
+}
+
+func (a *tagAtom) String() string { return a.raw }
+func (n *tagNot) String() string  { return "not " + n.x.String() }
+func (b *tagBinary) String() string {
+	return "(" + b.l.String() + " " + b.op + " " + b.r.String() + ")"
+}
+
+// tagOperators are ordered so that two-character operators match first
+var tagOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// isTagExpression tells whether a tag has to be parsed as a boolean expression
+func isTagExpression(tag string) bool {
+	return strings.ContainsAny(tag, "() \t\n")
+}
+
+// parseTag parses a query tag into a tag expression
+func parseTag(tag string) (tagExpr, error) {
+	if !isTagExpression(tag) {
+		return parseTagAtom(tag)
+	}
+	p := &tagParser{tokens: tokenizeTag(tag)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty tag expression")
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in tag expression", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// tokenizeTag splits an expression into parentheses and whitespace separated words
+func tokenizeTag(tag string) (tokens []string) {
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range tag {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], keyword)
+}
+
+func (p *tagParser) parseOr() (tagExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &tagBinary{op: "or", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *tagParser) parseAnd() (tagExpr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &tagBinary{op: "and", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *tagParser) parseUnary() (tagExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of tag expression")
+	}
+	token := p.tokens[p.pos]
+	switch {
+	case strings.EqualFold(token, "not"):
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &tagNot{x: x}, nil
+	case token == "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return nil, fmt.Errorf("missing ')' in tag expression")
+		}
+		p.pos++
+		return x, nil
+	case token == ")" || strings.EqualFold(token, "and") || strings.EqualFold(token, "or"):
+		return nil, fmt.Errorf("unexpected %q in tag expression", token)
+	}
+	p.pos++
+	return parseTagAtom(token)
+}
+
+// parseTagAtom parses a single tag such as `primary`, `role:pg_monitor`,
+// `setting:track_io_timing=on` or `extension:timescaledb>=2.10`
+func parseTagAtom(tag string) (*tagAtom, error) {
+	if tag == "" {
+		return nil, fmt.Errorf("empty tag")
+	}
+	atom := &tagAtom{raw: tag, name: tag}
+	prefix, rest, found := strings.Cut(tag, ":")
+	if !found {
+		return atom, nil
+	}
+	switch prefix {
+	case "extension", "setting":
+		atom.prefix = prefix
+		atom.name = rest
+		if i := strings.IndexAny(rest, "<>=!"); i >= 0 {
+			for _, op := range tagOperators {
+				if strings.HasPrefix(rest[i:], op) {
+					atom.name, atom.op, atom.value = rest[:i], op, rest[i+len(op):]
+					break
+				}
+			}
+			if atom.op == "" || strings.ContainsAny(atom.value, "<>=!") {
+				return nil, fmt.Errorf("tag %q has invalid comparison operator", tag)
+			}
+			if atom.value == "" {
+				return nil, fmt.Errorf("tag %q has empty comparison value", tag)
+			}
+			if prefix == "extension" && parseVersionParts(atom.value) == nil {
+				return nil, fmt.Errorf("tag %q has invalid extension version %q", tag, atom.value)
+			}
+		}
+	case "schema", "dbname", "username", "not", "tag", "role", "function", "relation":
+		atom.prefix = prefix
+		atom.name = rest
+		if prefix == "relation" && rest != "" && !validRelationName(rest) {
+			return nil, fmt.Errorf("tag %q has invalid relation name, want name or schema.name", tag)
+		}
+	default:
+		return atom, nil // not a known prefix, the whole string is a plain server tag
+	}
+	if atom.name == "" {
+		return nil, fmt.Errorf("tag %q has empty %s name", tag, atom.prefix)
+	}
+	return atom, nil
+}
+
+// validRelationName reports whether name is a relation `name` or `schema.name`.
+// Names are matched as stored in pg_class, without quoting or case folding, an
+// unqualified name matches relations visible in search_path
+func validRelationName(name string) bool {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || strings.ContainsFunc(part, func(r rune) bool { return r == '"' || unicode.IsSpace(r) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// walkTagAtoms calls fn on every atom of a tag expression
+func walkTagAtoms(e tagExpr, fn func(atom *tagAtom)) {
+	switch v := e.(type) {
+	case *tagAtom:
+		fn(v)
+	case *tagNot:
+		walkTagAtoms(v.x, fn)
+	case *tagBinary:
+		walkTagAtoms(v.l, fn)
+		walkTagAtoms(v.r, fn)
+	}
+}
+
+// refersTag reports whether any tag expression of this query has a plain atom of given tag
+func (q *Query) refersTag(tag string) bool {
+	found := false
+	for _, raw := range q.Tags {
+		expr, err := parseTag(raw)
+		if err != nil {
+			continue
+		}
+		walkTagAtoms(expr, func(atom *tagAtom) {
+			if atom.prefix == "" && atom.raw == tag {
+				found = true
+			}
+		})
+	}
+	return found
+}
+
+/* ================ Tag Evaluation ================ */
+
+// evalTag evaluates a tag expression against server facts. When the result is
+// false, reason explains which atoms caused it.
+func (s *Server) evalTag(query *Query, e tagExpr) (ok bool, reason string) {
+	switch v := e.(type) {
+	case *tagAtom:
+		return s.matchTagAtom(query, v)
+	case *tagNot:
+		if ok, _ := s.evalTag(query, v.x); ok {
+			return false, fmt.Sprintf("%s holds on server [%s]", v.x, s.Name())
+		}
+		return true, ""
+	case *tagBinary:
+		lok, lreason := s.evalTag(query, v.l)
+		if v.op == "and" {
+			if !lok {
+				return false, lreason
+			}
+			return s.evalTag(query, v.r)
+		}
+		if lok {
+			return true, ""
+		}
+		rok, rreason := s.evalTag(query, v.r)
+		if rok {
+			return true, ""
+		}
+		return false, lreason + "; " + rreason
+	}
+	return false, fmt.Sprintf("unknown tag expression %v", e)
+}
+
+// matchTagAtom checks a single tag against server facts
+func (s *Server) matchTagAtom(query *Query, atom *tagAtom) (bool, string) {
+	tag := atom.raw
+	switch atom.prefix {
+	case "extension": // check extension is installed on target database
+		if _, found := s.Extensions[atom.name]; !found {
+			return false, fmt.Sprintf("server [%s] does not have extension %s", s.Name(), tag)
+		}
+		if atom.op != "" {
+			version := s.ExtensionVersions[atom.name]
+			if !compareVersion(version, atom.op, atom.value) {
+				return false, fmt.Sprintf("server [%s] extension %s version %q does not satisfy %s", s.Name(), atom.name, version, tag)
+			}
+		}
+		return true, ""
+	case "schema": // check schema exist on target database
+		if _, found := s.Namespaces[atom.name]; !found {
+			return false, fmt.Sprintf("server [%s] does not have schema %s", s.Name(), tag)
+		}
+		return true, ""
+	case "dbname": // check if dbname prefix tag match server.Database
+		if s.Database != atom.name {
+			return false, fmt.Sprintf("server [%s] dbname does %s not match with query tag %s", s.Name(), s.Database, tag)
+		}
+		return true, ""
+	case "username": // check if username prefix tag match server.Username
+		if s.Username != atom.name {
+			return false, fmt.Sprintf("server [%s] username [%s] does not match %s", s.Name(), s.Username, tag)
+		}
+		return true, ""
+	case "not": // check server does not have given tag
+		if s.HasTag(atom.name) {
+			return false, fmt.Sprintf("server [%s] has tag %s that query %s forbid", s.Name(), atom.name, query.Name)
+		}
+		return true, ""
+	case "tag": // explicit server tag, also usable for pre-defined tag names
+		if !s.HasTag(atom.name) {
+			return false, fmt.Sprintf("server [%s] does not have tag %s that query %s require", s.Name(), atom.name, query.Name)
+		}
+		return true, ""
+	case "setting": // check gathered pg_settings value
+		value, found := s.Settings[atom.name]
+		if !found {
+			return false, fmt.Sprintf("server [%s] does not have setting %s", s.Name(), atom.name)
+		}
+		if atom.op != "" && !compareSetting(value, atom.op, atom.value) {
+			return false, fmt.Sprintf("server [%s] setting %s = %q does not satisfy %s", s.Name(), atom.name, value, tag)
+		}
+		return true, ""
+	case "role": // check current user is a member of given role
+		if !s.Roles[atom.name] {
+			return false, fmt.Sprintf("server [%s] user [%s] is not a member of role %s", s.Name(), s.Username, atom.name)
+		}
+		return true, ""
+	case "function": // check function exists, optionally schema qualified
+		if !s.Functions[atom.name] {
+			return false, fmt.Sprintf("server [%s] does not have function %s", s.Name(), atom.name)
+		}
+		return true, ""
+	case "relation": // check relation exists on target database
+		if !s.Relations[atom.name] {
+			return false, fmt.Sprintf("server [%s] does not have relation %s", s.Name(), atom.name)
+		}
+		return true, ""
+	}
+
+	// check default tags: cluster, primary, standby|replica, superuser
+	switch tag {
+	case "cluster":
+		if s.Forked {
+			return false, fmt.Sprintf("cluster level query %s will not run on forked server %v", query.Name, s.Name())
+		}
+	case "primary", "master", "leader":
+		if s.Recovery {
+			return false, fmt.Sprintf("primary-only query %s will not run on standby server %v", query.Name, s.Name())
+		}
+	case "standby", "replica", "slave":
+		if !s.Recovery {
+			return false, fmt.Sprintf("standby-only query %s will not run on primary server %v", query.Name, s.Name())
+		}
+	case "superuser": // --tag superuser still marks the server as superuser, as it did before the fact was gathered
+		if !s.Superuser && !s.HasTag(tag) {
+			return false, fmt.Sprintf("superuser-only query %s will not run with non-superuser %s on server %v", query.Name, s.Username, s.Name())
+		}
+	case "pgbouncer":
+		if !s.PgbouncerMode {
+			return false, fmt.Sprintf("pgbouncer query %s does not match with normal postgres server", query.Name)
+		}
+	default:
+		// if this tag is nether a pre-defined tag nor a prefixed pattern tag, check whether server have that tag
+		if !s.HasTag(tag) {
+			return false, fmt.Sprintf("server [%s] does not have tag %s that query %s require", s.Name(), tag, query.Name)
+		}
+	}
+	return true, ""
+}
+
+// compareSetting compares a pg_settings value with an expected value.
+// Numeric values are compared as numbers, anything else is compared as a
+// case-insensitive string which only supports = and !=.
+func compareSetting(actual, op, expected string) bool {
+	a, aerr := strconv.ParseFloat(actual, 64)
+	e, eerr := strconv.ParseFloat(expected, 64)
+	if aerr == nil && eerr == nil {
+		return compareOrdered(a, op, e)
+	}
+	switch op {
+	case "=":
+		return strings.EqualFold(actual, expected)
+	case "!=":
+		return !strings.EqualFold(actual, expected)
+	}
+	return false
+}
+
+// compareVersion compares dotted version strings such as 2.10.1 numerically
+func compareVersion(actual, op, expected string) bool {
+	a, e := parseVersionParts(actual), parseVersionParts(expected)
+	if a == nil || e == nil {
+		return false
+	}
+	cmp := 0
+	for i := 0; i < len(a) || i < len(e); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(e) {
+			y = e[i]
+		}
+		if x != y {
+			if x < y {
+				cmp = -1
+			} else {
+				cmp = 1
+			}
+			break
+		}
+	}
+	return compareOrdered(cmp, op, 0)
+}
+
+// parseVersionParts turns 2.10.1 into [2 10 1], trailing non-digits of each
+// part are ignored (1.5dev => [1 5]), nil is returned for non-version strings
+func parseVersionParts(version string) []int {
+	if version == "" {
+		return nil
+	}
+	fields := strings.Split(version, ".")
+	parts := make([]int, 0, len(fields))
+	for _, field := range fields {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			return nil
+		}
+		n, err := strconv.Atoi(field[:end])
+		if err != nil {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func compareOrdered[T int | float64](a T, op string, b T) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+/* ================ Tag Facts ================ */
+
+// tagFactRequirements lists planning facts referenced by query tags and SQL
+// templates. Only these are gathered during precheck, so unused predicates cost nothing.
+type tagFactRequirements struct {
+	Settings  []string
+	Roles     []string
+	Functions []string // bare function names, schema qualifier is matched later
+	Relations []string
+}
+
+// IsEmpty tells whether no extra fact has to be gathered
+func (r *tagFactRequirements) IsEmpty() bool {
+	return len(r.Settings) == 0 && len(r.Roles) == 0 && len(r.Functions) == 0 && len(r.Relations) == 0
+}
+
+// collectTagFactRequirements walks all query tags and SQL templates and collects referenced facts
+func collectTagFactRequirements(queries map[string]*Query) *tagFactRequirements {
+	seen := make(map[string]bool)
+	req := &tagFactRequirements{}
+	add := func(list *[]string, kind, name string) {
+		if key := kind + ":" + name; !seen[key] {
+			seen[key] = true
+			*list = append(*list, name)
+		}
+	}
+	require := func(prefix, name string) {
+		switch prefix {
+		case "setting":
+			add(&req.Settings, "setting", name)
+		case "role":
+			add(&req.Roles, "role", name)
+		case "function":
+			if _, fn, qualified := strings.Cut(name, "."); qualified {
+				name = fn
+			}
+			add(&req.Functions, "function", name)
+		case "relation":
+			add(&req.Relations, "relation", name)
+		}
+	}
+	for _, query := range queries {
+		if query == nil {
+			continue
+		}
+		for _, tag := range query.Tags {
+			expr, err := parseTag(tag)
+			if err != nil {
+				continue // invalid tags are rejected while parsing config, and discarded while planning
+			}
+			walkTagAtoms(expr, func(atom *tagAtom) { require(atom.prefix, atom.name) })
+		}
+		walkSQLTemplateFacts(query.SQL, require)
+		walkSQLTemplateFacts(query.Foreach, require)
+		for _, pq := range query.PredicateQueries {
+			walkSQLTemplateFacts(pq.SQL, require)
+		}
+	}
+	return req
+}
@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const timestampConfig = `
+pg_heartbeat:
+  query: SELECT id, ts, lag FROM test
+  metrics:
+    - id:  { usage: LABEL }
+    - ts:  { usage: TIMESTAMP }
+    - lag: { usage: GAUGE }
+`
+
+func TestParseConfigTimestamp(t *testing.T) {
+	queries, err := ParseConfig([]byte(timestampConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := queries["pg_heartbeat"]
+	if q.TimestampName != "ts" || len(q.MetricNames) != 1 || len(q.LabelNames) != 1 {
+		t.Fatalf("unexpected timestamp query: ts=%q metrics=%v labels=%v", q.TimestampName, q.MetricNames, q.LabelNames)
+	}
+	if explain := q.Explain(); !strings.Contains(explain, "Timestamp  column ts") {
+		t.Fatalf("explain should render timestamp column:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"two timestamps": `
+q:
+  query: SELECT 1
+  metrics:
+    - a: { usage: TIMESTAMP }
+    - b: { usage: TIMESTAMP }
+    - v: { usage: GAUGE }
+`,
+		"with histogram": `
+q:
+  query: SELECT 1
+  metrics:
+    - a: { usage: TIMESTAMP }
+    - v: { usage: HISTOGRAM, bucket: [ 1 ] }
+`,
+		"timestamp only": `
+q:
+  query: SELECT 1
+  metrics:
+    - a: { usage: TIMESTAMP }
+`,
+	}
+	for name, content := range tests {
+		if _, err := ParseConfig([]byte(content)); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCastTimestamp(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	scaled := &Column{Scale: "1e-3"}
+	if err := scaled.parseNumbers(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value  interface{}
+		column *Column
+		want   time.Time
+	}{
+		{at, nil, at},
+		{float64(at.Unix()) + 0.5, nil, at.Add(500 * time.Millisecond)},
+		{at.UnixMilli(), scaled, at},
+		{[]byte("1767323045"), nil, at},
+		{nil, nil, time.Time{}},
+		{"bad", nil, time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := castTimestamp(tt.value, tt.column); !got.Equal(tt.want) {
+			t.Fatalf("castTimestamp(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCollectorTimestamp(t *testing.T) {
+	heartbeat := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	collect := func(config string) map[string]int64 {
+		t.Helper()
+		queries, err := ParseConfig([]byte(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		collector := newFakeCollector(t, queries["pg_heartbeat"], func() driver.Rows {
+			return &fakeRows{columns: []string{"id", "ts", "lag"}, values: [][]driver.Value{{"a", heartbeat, 1.0}, {"b", nil, 2.0}}}
+		}, nil)
+		collector.scrapeBegin = time.Now()
+		collector.execute()
+		if err := collector.Error(); err != nil {
+			t.Fatalf("execute timestamp query: %v", err)
+		}
+		ch := make(chan prometheus.Metric, 8)
+		collector.sendMetrics(ch)
+		close(ch)
+		stamps := make(map[string]int64)
+		for metric := range ch {
+			var m dto.Metric
+			if err := metric.Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			stamps[labelValue(&m, "id")] = m.GetTimestampMs()
+		}
+		return stamps
+	}
+
+	stamps := collect(timestampConfig)
+	if stamps["a"] != heartbeat.UnixMilli() || stamps["b"] != 0 {
+		t.Fatalf("unexpected sample timestamps without keep_timestamp: %v", stamps)
+	}
+
+	keep := strings.Replace(timestampConfig, "  metrics:", "  keep_timestamp: true\n  metrics:", 1)
+	stamps = collect(keep)
+	if stamps["a"] != heartbeat.UnixMilli() || stamps["b"] == 0 || stamps["b"] > time.Now().UnixMilli() {
+		t.Fatalf("keep_timestamp should stamp rows without timestamp with execution time: %v", stamps)
+	}
+}
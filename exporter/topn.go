@@ -0,0 +1,170 @@
+package exporter
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/* ================ Top N ================ */
+
+// defaultTopNHysteresis is the default advantage of rows kept by the previous execution
+const defaultTopNHysteresis = 0.1
+
+// TopN keeps the N rows with the largest `by` value of a query result, so
+// per-table, per-index or per-statement collectors stay bounded on databases
+// with many objects. Rows kept by the previous execution rank with their value
+// multiplied by 1 + hysteresis, so a row takes over a kept row only if it is
+// clearly larger, which keeps the selection stable across scrapes. With
+// `others: true`, label values of the remaining rows become `other`, and they
+// are merged into one aggregate series the same way as relabeled rows. Members
+// of `other` change between executions, so it cannot hold COUNTER, delta or
+// rate columns.
+type TopN struct {
+	By         string   `yaml:"by"`                   // column to rank rows by, descending
+	N          int      `yaml:"n"`                    // number of rows to keep
+	Others     bool     `yaml:"others,omitempty"`     // fold remaining rows into `other` label values
+	Hysteresis *float64 `yaml:"hysteresis,omitempty"` // advantage of previously kept rows, 0.1 by default
+}
+
+// HysteresisRatio returns configured hysteresis or the default one
+func (t *TopN) HysteresisRatio() float64 {
+	if t.Hysteresis != nil {
+		return *t.Hysteresis
+	}
+	return defaultTopNHysteresis
+}
+
+// validateTopN validates top_n of a query, which must be called after query columns are parsed
+func validateTopN(q *Query) error {
+	t := q.TopN
+	if t.N <= 0 {
+		return fmt.Errorf("top_n.n must be positive, got %d", t.N)
+	}
+	if h := t.HysteresisRatio(); h < 0 || math.IsNaN(h) || math.IsInf(h, 0) {
+		return fmt.Errorf("top_n.hysteresis must be a non-negative number, got %v", h)
+	}
+	column := q.Columns[t.By]
+	if column == nil {
+		return fmt.Errorf("top_n.by column %q is not defined", t.By)
+	}
+	if !q.readsNumber(column) || column.IsComputed() {
+		return fmt.Errorf("top_n.by column %q must be a numeric column read from result, got %s", t.By, column.Usage)
+	}
+	if t.Others && len(q.LabelNames) == 0 {
+		return fmt.Errorf("top_n.others requires at least one LABEL column")
+	}
+	if t.Others && q.HasDelta() { // members of other change between executions
+		return fmt.Errorf("top_n.others cannot be used with delta or rate columns")
+	}
+	if t.Others { // other drops when a member is promoted, which reads as a counter reset
+		for _, name := range q.MetricNames {
+			if q.Columns[name].Usage == COUNTER {
+				return fmt.Errorf("top_n.others cannot be used with COUNTER column %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// rowKey identifies the series of a row by raw label values, which are
+// unique per row unlike relabeled ones, plus the pivot name if any
+func (q *Collector) rowKey(colData []interface{}, columnIndexes map[string]int) string {
+	raw := make([]string, 0, len(q.LabelNames)+1)
+	for _, labelName := range q.LabelNames {
+		raw = append(raw, castString(colData[columnIndexes[labelName]]))
+	}
+	if q.Pivot != nil {
+		raw = append(raw, castString(colData[columnIndexes[q.Pivot.Name]]))
+	}
+	return encodeLabelTuple(raw)
+}
+
+// rankedRow is a buffered result row of a top_n query
+type rankedRow struct {
+	data  []interface{}
+	key   string
+	score float64
+	kept  bool // whether this row is among the top N
+}
+
+// rankedRows holds the whole result of a top_n query in result order
+type rankedRows struct {
+	rows []*rankedRow
+	next int
+	kept map[string]bool // keys of rows among the top N
+}
+
+// rankRows reads all remaining rows with scan, which fills colData, and marks
+// the top N of them. Rows are replayed in result order by rankedRows.scan.
+func (q *Collector) rankRows(scan func() (bool, error), colData []interface{}, columnIndexes map[string]int) (*rankedRows, error) {
+	t := q.TopN
+	byIndex, found := columnIndexes[t.By]
+	if !found {
+		return nil, fmt.Errorf("query [%s] missing top_n column %s.%s in result", q.Name, q.Name, t.By)
+	}
+	ranked := &rankedRows{kept: make(map[string]bool, t.N)}
+	for {
+		more, err := scan()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		row := &rankedRow{data: append([]interface{}(nil), colData...), key: q.rowKey(colData, columnIndexes)}
+		row.score = castFloat64(colData[byIndex], q.Columns[t.By])
+		if math.IsNaN(row.score) {
+			row.score = math.Inf(-1) // NULL ranks last
+		} else if q.topNKept[row.key] && row.score > 0 {
+			row.score *= 1 + t.HysteresisRatio()
+		}
+		ranked.rows = append(ranked.rows, row)
+	}
+	order := make([]*rankedRow, len(ranked.rows))
+	copy(order, ranked.rows)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].score > order[j].score })
+	for i := 0; i < len(order) && i < t.N; i++ {
+		order[i].kept = true
+		ranked.kept[order[i].key] = true
+	}
+	return ranked, nil
+}
+
+// scan replays the next buffered row into colData. Rows beyond the top N are
+// skipped, or have their label values replaced by `other` with top_n.others.
+func (r *rankedRows) scan(q *Collector, colData []interface{}, columnIndexes map[string]int) bool {
+	for r.next < len(r.rows) {
+		row := r.rows[r.next]
+		r.next++
+		if !row.kept && !q.TopN.Others {
+			continue
+		}
+		copy(colData, row.data)
+		if !row.kept {
+			for _, labelName := range q.LabelNames {
+				colData[columnIndexes[labelName]] = otherLabelValue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// rowScanner returns a function that scans the next result row into colData
+// and converts typed values, false means there are no more rows
+func (q *Collector) rowScanner(rows *sql.Rows, colArgs []interface{}, colData []interface{}, converters []*columnConverter, columnNames []string) func() (bool, error) {
+	return func() (bool, error) {
+		if !rows.Next() {
+			return false, nil
+		}
+		if err := rows.Scan(colArgs...); err != nil {
+			return false, fmt.Errorf("fail scanning rows: %w", err)
+		}
+		if err := q.convertValues(converters, columnNames, colData); err != nil {
+			return false, fmt.Errorf("query [%s] %w", q.Name, err)
+		}
+		return true, nil
+	}
+}
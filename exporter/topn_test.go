@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+const topNConfig = `
+pg_table:
+  query: SELECT relname, size, scans FROM test
+  top_n: { by: size, n: 2, others: true }
+  metrics:
+    - relname: { usage: LABEL }
+    - size:    { usage: GAUGE }
+    - scans:   { usage: GAUGE }
+`
+
+func TestParseConfigTopN(t *testing.T) {
+	queries, err := ParseConfig([]byte(topNConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain := queries["pg_table"].Explain(); !strings.Contains(explain, "TopN       n=2 by=size hysteresis=0.1 others") {
+		t.Fatalf("explain should render top_n:\n%s", explain)
+	}
+
+	tests := map[string]string{
+		"zero n":         `{ by: v, n: 0 }`,
+		"negative hyst":  `{ by: v, n: 1, hysteresis: -1 }`,
+		"unknown by":     `{ by: x, n: 1 }`,
+		"label by":       `{ by: l, n: 1 }`,
+		"computed by":    `{ by: e, n: 1 }`,
+		"others delta":   `{ by: v, n: 1, others: true }\n  metrics:\n    - l: { usage: LABEL }\n    - v: { usage: COUNTER, delta: true }`,
+		"others nolabel": `{ by: v, n: 1, others: true }\n  metrics:\n    - v: { usage: GAUGE }`,
+		"others counter": `{ by: v, n: 1, others: true }\n  metrics:\n    - l: { usage: LABEL }\n    - v: { usage: GAUGE }\n    - c: { usage: COUNTER }`,
+	}
+	for name, topN := range tests {
+		content := "q:\n  query: SELECT 1\n  top_n: " + topN + "\n"
+		if !strings.Contains(topN, "metrics") {
+			content += "  metrics:\n    - l: { usage: LABEL }\n    - v: { usage: GAUGE }\n    - e: { usage: GAUGE, expr: v }\n"
+		}
+		if _, err := ParseConfig([]byte(strings.ReplaceAll(content, `\n`, "\n"))); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestCollectorTopN(t *testing.T) {
+	queries, err := ParseConfig([]byte(topNConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values [][]driver.Value
+	collector := newFakeCollector(t, queries["pg_table"], func() driver.Rows {
+		return &fakeRows{columns: []string{"relname", "size", "scans"}, values: values}
+	}, nil)
+	execute := func(rows ...[]driver.Value) map[string]float64 {
+		t.Helper()
+		values = rows
+		collector.scrapeBegin = time.Now()
+		collector.execute()
+		if err := collector.Error(); err != nil {
+			t.Fatal(err)
+		}
+		samples, _ := gatherSamples(t, collector)
+		return samples
+	}
+	lbl := func(relname string) map[string]string {
+		return map[string]string{"cluster": "c1", "relname": relname}
+	}
+
+	samples := execute(
+		[]driver.Value{"a", int64(10), int64(1)},
+		[]driver.Value{"b", int64(300), int64(2)},
+		[]driver.Value{"c", nil, int64(4)},
+		[]driver.Value{"d", int64(200), int64(8)},
+	)
+	requireSample(t, samples, "pg_table_size", lbl("b"), 300)
+	requireSample(t, samples, "pg_table_size", lbl("d"), 200)
+	requireSample(t, samples, "pg_table_scans", lbl("other"), 5)
+	if len(samples) != 6 || collector.ResultSize() != 6 {
+		t.Fatalf("top 2 and other should be emitted, got %v", samples)
+	}
+
+	// a outgrows d but not by the hysteresis margin, then clearly
+	samples = execute([]driver.Value{"a", int64(210), int64(1)}, []driver.Value{"b", int64(300), int64(2)}, []driver.Value{"d", int64(200), int64(8)})
+	requireSample(t, samples, "pg_table_size", lbl("d"), 200)
+	requireSample(t, samples, "pg_table_size", lbl("other"), 210)
+	samples = execute([]driver.Value{"a", int64(230), int64(1)}, []driver.Value{"b", int64(300), int64(2)}, []driver.Value{"d", int64(200), int64(8)})
+	requireSample(t, samples, "pg_table_size", lbl("a"), 230)
+	requireSample(t, samples, "pg_table_size", lbl("other"), 200)
+
+	// without others remaining rows are dropped
+	collector.TopN.Others = false
+	samples = execute([]driver.Value{"a", int64(230), int64(1)}, []driver.Value{"b", int64(300), int64(2)}, []driver.Value{"d", int64(200), int64(8)})
+	if len(samples) != 4 {
+		t.Fatalf("only top 2 should be emitted, got %v", samples)
+	}
+}
@@ -133,6 +133,24 @@ func castFloat64(t interface{}, c *Column) float64 {
 	}
 }
 
+// castTimestamp converts a TIMESTAMP column value into sample time. Numbers
+// are epoch seconds after scale. NULL or invalid values yield zero time, which
+// leaves the metrics of that row without explicit timestamp.
+func castTimestamp(t interface{}, c *Column) time.Time {
+	switch v := t.(type) {
+	case nil:
+		return time.Time{}
+	case time.Time:
+		return v
+	}
+	epoch := castFloat64(t, c)
+	if math.IsNaN(epoch) || math.IsInf(epoch, 0) {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(epoch)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
 // castHistogramFloat64 converts one raw SQL histogram observation. A NULL is
 // absent unless the column explicitly defines a default. Histogram samples are
 // required to be finite because one invalid observation invalidates the whole
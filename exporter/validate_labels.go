@@ -14,7 +14,7 @@ import (
 // where `key` equals one of a query's metric label names (after rename).
 //
 // When intro metrics are enabled, it also rejects keys that collide with the
-// exporter internal dynamic metric labels (currently: datname, query).
+// exporter internal dynamic metric labels (currently: datname, query, status).
 func validateConstLabelConflicts(constLabels prometheus.Labels, queries map[string]*Query, disableIntro bool) error {
 	if len(constLabels) == 0 {
 		return nil
@@ -22,7 +22,7 @@ func validateConstLabelConflicts(constLabels prometheus.Labels, queries map[stri
 
 	// Exporter internal dynamic metrics use these variable labels.
 	if !disableIntro {
-		for _, reserved := range []string{"datname", "query"} {
+		for _, reserved := range []string{"datname", "query", "status"} {
 			if _, exists := constLabels[reserved]; exists {
 				return fmt.Errorf("const label %q conflicts with built-in exporter metric label %q", reserved, reserved)
 			}
@@ -48,11 +48,19 @@ func validateConstLabelConflicts(constLabels prometheus.Labels, queries map[stri
 		if _, exists := constLabels["le"]; exists && q.HasHistogram() {
 			return fmt.Errorf("const label %q conflicts with query %q (name=%q) generated Histogram bucket label %q", "le", branch, q.Name, "le")
 		}
+		if _, exists := constLabels["quantile"]; exists && q.HasSummary() {
+			return fmt.Errorf("const label %q conflicts with query %q (name=%q) generated Summary quantile label %q", "quantile", branch, q.Name, "quantile")
+		}
 		for _, lbl := range q.LabelList() {
 			if _, exists := constLabels[lbl]; exists {
 				return fmt.Errorf("const label %q conflicts with query %q (name=%q) label %q", lbl, branch, q.Name, lbl)
 			}
 		}
+		for _, lbl := range sortedKeys(q.Labels) {
+			if _, exists := constLabels[lbl]; exists {
+				return fmt.Errorf("const label %q conflicts with query %q (name=%q) static label %q", lbl, branch, q.Name, lbl)
+			}
+		}
 	}
 
 	return nil